@@ -0,0 +1,168 @@
+package buffer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AdaptiveConfig turns on adaptive batching: instead of using Capacity and
+// FlushInterval as fixed values, the buffer tunes the batch size and the
+// flush interval from the latency and errors of its Flush calls, AIMD-style
+// (additive increase, multiplicative decrease).
+//
+// After every Flush call:
+//   - if it succeeded within TargetLatency, the backend is keeping up: the
+//     batch size grows by BatchStep and the interval shrinks by
+//     IntervalStep;
+//   - if it failed or took longer than TargetLatency, the backend is
+//     struggling: the batch size is multiplied by Backoff and the interval
+//     divided by it, so that each flush asks less of the backend and idle
+//     periods produce fewer of them.
+//
+// Both values stay within [MinBatch, MaxBatch] and [MinInterval,
+// MaxInterval], and start out at Capacity and FlushInterval.
+//
+// Example: one config that suits both a fast Mongo and a throttled HTTP bulk
+// API:
+//
+//	Adaptive: &buffer.AdaptiveConfig{
+//	    TargetLatency: 300 * time.Millisecond,
+//	    MinBatch:      50,
+//	    MaxBatch:      5000,
+//	},
+type AdaptiveConfig struct {
+	// TargetLatency is the Flush duration above which a flush counts as a
+	// sign of congestion. Required.
+	TargetLatency time.Duration
+
+	// MinBatch and MaxBatch bound the batch size.
+	//
+	// Default: 1 and 4 * Capacity.
+	MinBatch int
+	MaxBatch int
+
+	// MinInterval and MaxInterval bound the flush interval.
+	//
+	// Default: FlushInterval / 4 and 4 * FlushInterval.
+	MinInterval time.Duration
+	MaxInterval time.Duration
+
+	// BatchStep is added to the batch size after each healthy flush.
+	//
+	// Default: Capacity / 10, at least 1.
+	BatchStep int
+
+	// IntervalStep is taken off the flush interval after each healthy
+	// flush.
+	//
+	// Default: FlushInterval / 10.
+	IntervalStep time.Duration
+
+	// Backoff is the factor the batch size is multiplied by, and the
+	// interval divided by, after a congested flush. Must be within (0, 1).
+	//
+	// Default: 0.5.
+	Backoff float64
+}
+
+func (a *AdaptiveConfig) withDefaults(capacity int, interval time.Duration) *AdaptiveConfig {
+	cfg := *a
+
+	if cfg.MinBatch < 1 {
+		cfg.MinBatch = 1
+	}
+	if cfg.MaxBatch == 0 {
+		cfg.MaxBatch = 4 * capacity
+	}
+	if cfg.MinInterval == 0 {
+		cfg.MinInterval = interval / 4
+	}
+	if cfg.MaxInterval == 0 {
+		cfg.MaxInterval = 4 * interval
+	}
+	if cfg.BatchStep < 1 {
+		cfg.BatchStep = max(1, capacity/10)
+	}
+	if cfg.IntervalStep <= 0 {
+		cfg.IntervalStep = interval / 10
+	}
+	if cfg.Backoff <= 0 || cfg.Backoff >= 1 {
+		cfg.Backoff = 0.5
+	}
+
+	return &cfg
+}
+
+func (a *AdaptiveConfig) validate() error {
+	if a.TargetLatency <= 0 {
+		return fmt.Errorf("buffer: Adaptive.TargetLatency is required")
+	}
+	if a.MinBatch > a.MaxBatch {
+		return fmt.Errorf("buffer: Adaptive.MinBatch (%d) exceeds MaxBatch (%d)", a.MinBatch, a.MaxBatch)
+	}
+	if a.MinInterval > a.MaxInterval {
+		return fmt.Errorf("buffer: Adaptive.MinInterval (%s) exceeds MaxInterval (%s)", a.MinInterval, a.MaxInterval)
+	}
+	return nil
+}
+
+// adaptiveController holds the current batch size and flush interval. They
+// are updated by whichever goroutine calls Flush (Run or a flush worker)
+// and read by Run, hence the lock for updates and atomics for reads.
+type adaptiveController struct {
+	cfg *AdaptiveConfig
+
+	mu       sync.Mutex
+	size     atomic.Int64
+	interval atomic.Int64
+}
+
+func newAdaptiveController(cfg *AdaptiveConfig, capacity int, interval time.Duration) *adaptiveController {
+	c := &adaptiveController{cfg: cfg}
+	c.size.Store(int64(min(max(capacity, cfg.MinBatch), cfg.MaxBatch)))
+	c.interval.Store(int64(min(max(interval, cfg.MinInterval), cfg.MaxInterval)))
+	return c
+}
+
+func (c *adaptiveController) batchSize() int {
+	return int(c.size.Load())
+}
+
+func (c *adaptiveController) flushInterval() time.Duration {
+	return time.Duration(c.interval.Load())
+}
+
+// observe adjusts the batch size and interval after a Flush call that took
+// latency and returned err.
+func (c *adaptiveController) observe(latency time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	size := c.batchSize()
+	interval := c.flushInterval()
+
+	if err == nil && latency <= c.cfg.TargetLatency {
+		size += c.cfg.BatchStep
+		interval -= c.cfg.IntervalStep
+	} else {
+		size = int(float64(size) * c.cfg.Backoff)
+		interval = time.Duration(float64(interval) / c.cfg.Backoff)
+	}
+
+	c.size.Store(int64(min(max(size, c.cfg.MinBatch), c.cfg.MaxBatch)))
+	c.interval.Store(int64(min(max(interval, c.cfg.MinInterval), c.cfg.MaxInterval)))
+}
+
+// adaptiveFlush returns a FlushFunc that feeds every call of flush to
+// c.observe.
+func adaptiveFlush[T any](c *adaptiveController, flush FlushFunc[T]) FlushFunc[T] {
+	return func(ctx context.Context, batch []T) error {
+		start := time.Now()
+		err := flush(ctx, batch)
+		c.observe(time.Since(start), err)
+		return err
+	}
+}
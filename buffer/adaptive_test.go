@@ -0,0 +1,92 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveController(t *testing.T) {
+	cfg := (&AdaptiveConfig{
+		TargetLatency: 100 * time.Millisecond,
+		MinBatch:      10,
+		MaxBatch:      120,
+		BatchStep:     10,
+		MinInterval:   time.Second,
+		MaxInterval:   8 * time.Second,
+		IntervalStep:  time.Second,
+	}).withDefaults(100, 4*time.Second)
+	c := newAdaptiveController(cfg, 100, 4*time.Second)
+
+	c.observe(10*time.Millisecond, nil)
+	if c.batchSize() != 110 || c.flushInterval() != 3*time.Second {
+		t.Errorf("expected additive increase to 110/3s, got %d/%s", c.batchSize(), c.flushInterval())
+	}
+
+	c.observe(10*time.Millisecond, nil)
+	c.observe(10*time.Millisecond, nil)
+	if c.batchSize() != 120 {
+		t.Errorf("expected the batch size to stop at MaxBatch, got %d", c.batchSize())
+	}
+	if c.flushInterval() != time.Second {
+		t.Errorf("expected the interval to stop at MinInterval, got %s", c.flushInterval())
+	}
+
+	c.observe(time.Second, nil)
+	if c.batchSize() != 60 || c.flushInterval() != 2*time.Second {
+		t.Errorf("expected a slow flush to halve to 60/2s, got %d/%s", c.batchSize(), c.flushInterval())
+	}
+
+	for range 5 {
+		c.observe(time.Millisecond, errors.New("throttled"))
+	}
+	if c.batchSize() != 10 || c.flushInterval() != 8*time.Second {
+		t.Errorf("expected failures to back off to the bounds 10/8s, got %d/%s", c.batchSize(), c.flushInterval())
+	}
+}
+
+// TestBuffer_Adaptive verifies that a struggling backend makes the buffer
+// flush smaller batches.
+func TestBuffer_Adaptive(t *testing.T) {
+	var sizes []int
+	buf := mustNewBuffer(t, Config[int]{
+		Capacity: 8,
+		Adaptive: &AdaptiveConfig{TargetLatency: time.Minute, MinBatch: 2},
+		Flush: func(ctx context.Context, batch []int) error {
+			sizes = append(sizes, len(batch))
+			return errors.New("throttled")
+		},
+	})
+	go buf.Run(context.Background())
+
+	for i := range 14 {
+		buf.Add(context.Background(), i)
+	}
+	buf.Shutdown(context.Background())
+
+	if s := buf.Stats(); s.BatchLimit != 2 {
+		t.Errorf("expected the batch limit to have dropped to 2, got %d", s.BatchLimit)
+	}
+
+	want := []int{8, 4, 2}
+	for i, n := range want {
+		if i >= len(sizes) || sizes[i] != n {
+			t.Fatalf("expected batches to shrink as %v, got %v", want, sizes)
+		}
+	}
+}
+
+func TestBuffer_AdaptiveValidation(t *testing.T) {
+	flush := func(ctx context.Context, batch []int) error { return nil }
+
+	if _, err := NewBuffer(Config[int]{Flush: flush, Adaptive: &AdaptiveConfig{}}); err == nil {
+		t.Error("expected a missing TargetLatency to be rejected")
+	}
+	if _, err := NewBuffer(Config[int]{
+		Flush:    flush,
+		Adaptive: &AdaptiveConfig{TargetLatency: time.Second, MinBatch: 10, MaxBatch: 5},
+	}); err == nil {
+		t.Error("expected MinBatch above MaxBatch to be rejected")
+	}
+}
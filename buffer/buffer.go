@@ -3,7 +3,11 @@ package buffer
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // FlushFunc processes a batch of accumulated items.
@@ -11,10 +15,12 @@ import (
 // It is called when:
 //   - ShouldFlush returns true after an item is appended
 //   - The flush interval ticker fires
+//   - FlushNow is called
 //   - dataChan is closed (graceful shutdown)
 //
-// The context passed is the exact same one given to Run(). During a
-// graceful shutdown, it is highly likely this context has already been
+// The context passed is derived from the one given to Run(): it carries the
+// "buffer.flush" span (see Config.TracerProvider) and is cancelled when the
+// Run context is. During a graceful shutdown, it is highly likely this context has already been
 // cancelled by the parent application. Therefore, implementations must
 // shield the backend call to ensure the final drain succeeds:
 //
@@ -25,9 +31,10 @@ import (
 //	    return backend.Send(flushCtx, batch)
 //	},
 //
-// Returning a non-nil error triggers OnFlushError if set. The batch is
-// reset regardless — the buffer does not retry. Retry logic, if needed,
-// belongs inside FlushFunc or OnFlushError.
+// Returning a non-nil error makes the buffer retry the same batch according
+// to Config.Retry (by default it does not retry). Once retries are exhausted
+// the batch is handed to Config.DeadLetter, if set, OnFlushError is called,
+// and the batch is reset.
 //
 // The batch slice must not be retained after FlushFunc returns. The buffer
 // will reuse the underlying array. Copy if you need to hold on to items:
@@ -72,9 +79,15 @@ type FlushFunc[T any] func(ctx context.Context, batch []T) error
 //	    ShouldFlush: func(batch []MyMsg) bool {
 //	        return len(batch) >= 500 || totalBytes(batch) >= 4*1024*1024
 //	    },
+//	    Retry: buffer.RetryPolicy{
+//	        MaxAttempts: 3,
+//	        BaseBackoff: 200 * time.Millisecond,
+//	    },
+//	    DeadLetter: func(ctx context.Context, batch []MyMsg) error {
+//	        return deadLetterQueue.Send(ctx, batch)
+//	    },
 //	    OnFlushError: func(err error, batch []MyMsg) {
 //	        metrics.FlushErrors.Inc()
-//	        deadLetterQueue.Send(batch)
 //	    },
 //	})
 type Config[T any] struct {
@@ -126,6 +139,30 @@ type Config[T any] struct {
 	// Default: no-op.
 	OnReject func(item T)
 
+	// KeyFunc and Merge coalesce items within a batch: when an incoming
+	// item has the same key as an item already in the current batch, the
+	// two are replaced by Merge(existing, incoming) instead of the batch
+	// growing. Useful for counter-style items, turning thousands of
+	// increments into one update per key per flush:
+	//
+	//	KeyFunc: func(c Click) string { return c.CampaignID },
+	//	Merge: func(existing, incoming Click) Click {
+	//	    existing.Count += incoming.Count
+	//	    return existing
+	//	},
+	//
+	// A merged item takes the place of the existing one in the batch, so
+	// the order of first appearance is kept. WillOverflow and CanAdd are
+	// only consulted for items that add a new key; ShouldFlush is called
+	// after every item, merged or not.
+	//
+	// Both must be set, or neither. Merge runs inside Run and must not
+	// block.
+	//
+	// Default: nil (every item is appended).
+	KeyFunc func(item T) string
+	Merge   func(existing, incoming T) T
+
 	// ShouldFlush is called after each successful append.
 	// Return true to trigger an immediate flush.
 	//
@@ -143,11 +180,13 @@ type Config[T any] struct {
 	// Default: flush when len(batch) >= Capacity.
 	ShouldFlush func(batch []T) bool
 
-	// OnFlushError is called when Flush returns a non-nil error.
+	// OnFlushError is called once per batch that could not be flushed,
+	// after all retries have been exhausted and after DeadLetter (if set)
+	// has been given the batch.
 	//
-	// The batch passed here is the one that failed. The buffer has
-	// already reset — it will not retry. If you need retry or
-	// dead-lettering, implement it here or inside Flush.
+	// err is the last error returned by Flush, joined with the DeadLetter
+	// error if that failed as well. The batch passed here is the one that
+	// failed; the buffer resets it as soon as OnFlushError returns.
 	//
 	// Note: if Flush spawns a goroutine and returns nil optimistically,
 	// errors from that goroutine are outside the buffer's visibility —
@@ -156,6 +195,34 @@ type Config[T any] struct {
 	// Default: no-op (errors are silently ignored).
 	OnFlushError func(err error, batch []T)
 
+	// Retry controls whether and how a failed Flush is re-attempted.
+	// See RetryPolicy.
+	//
+	// Default: no retries.
+	Retry RetryPolicy
+
+	// DeadLetter receives batches whose Flush failed and could not be
+	// retried any further, e.g. to park them on a Pub/Sub topic or a
+	// local file for later replay.
+	//
+	// It is called synchronously with the same context as Flush, so it
+	// should shield its backend call in the same way. The batch slice
+	// must not be retained after DeadLetter returns.
+	//
+	// Default: nil (failed batches are only reported to OnFlushError).
+	DeadLetter FlushFunc[T]
+
+	// DrainTimeout bounds how long retries may keep going once Close has
+	// been called. Retries whose backoff would end past the deadline are
+	// abandoned and the batch goes to DeadLetter straight away, so a dead
+	// backend cannot hold up process shutdown.
+	//
+	// It does not limit an individual Flush call — use a shielded context
+	// with a timeout inside Flush for that.
+	//
+	// Default: 10 seconds.
+	DrainTimeout time.Duration
+
 	// Capacity is the target batch size: the number of items to accumulate
 	// before ShouldFlush (default) triggers a flush.
 	//
@@ -187,6 +254,99 @@ type Config[T any] struct {
 	//
 	// Default: 5 seconds.
 	FlushInterval time.Duration
+
+	// Adaptive, if set, lets the buffer tune the batch size and flush
+	// interval to the backend instead of using Capacity and FlushInterval
+	// as fixed values. See AdaptiveConfig.
+	//
+	// The adaptive batch size replaces the default ShouldFlush. A custom
+	// ShouldFlush keeps working, with the batch also flushed once it
+	// reaches the adaptive size.
+	//
+	// Default: nil (fixed Capacity and FlushInterval).
+	Adaptive *AdaptiveConfig
+
+	// FlushConcurrency is the number of goroutines that call Flush.
+	//
+	// With the default of 1, Flush runs synchronously inside Run: while a
+	// batch is being flushed no new items are taken off dataChan, and a
+	// slow backend quickly makes Add block.
+	//
+	// With a value above 1, Run hands each completed batch to a pool of
+	// flush workers and immediately starts filling the next one. Be aware
+	// that:
+	//   - batches may be flushed, retried and completed out of order;
+	//   - Flush, DeadLetter and OnFlushError may be called concurrently
+	//     and must be safe for that;
+	//   - the "batch must not be retained" contract still applies — each
+	//     worker owns its batch only until Flush returns.
+	//
+	// Default: 1.
+	FlushConcurrency int
+
+	// FlushQueueSize is the number of completed batches that may wait for
+	// a free flush worker when FlushConcurrency is above 1. Once
+	// FlushConcurrency+FlushQueueSize batches are in flight, Run blocks
+	// until one of them has been flushed.
+	//
+	// Ignored when FlushConcurrency is 1.
+	//
+	// Default: FlushConcurrency.
+	FlushQueueSize int
+
+	// WAL enables the durable mode: accepted items are written to a
+	// write-ahead log on local disk and replayed by the next process if
+	// this one dies before flushing them. See WALConfig.
+	//
+	// Default: nil (items only live in memory).
+	WAL *WALConfig[T]
+
+	// Overflow decides what Add and TryAdd do when dataChan is full.
+	// See OverflowPolicy.
+	//
+	// Default: OverflowBlock.
+	Overflow OverflowPolicy
+
+	// OnDrop is called for every item discarded because the buffer was
+	// full: the new item under OverflowDropNewest (and for a failed
+	// TryAdd under OverflowBlock), or the evicted item under
+	// OverflowDropOldest.
+	//
+	// It is called synchronously in the producer's goroutine and must not
+	// block.
+	//
+	// Default: no-op.
+	OnDrop func(item T)
+
+	// Spill receives items that did not fit under OverflowSpill, e.g. to
+	// publish them to a Pub/Sub topic for later processing. Required when
+	// Overflow is OverflowSpill.
+	//
+	// It is called synchronously in the producer's goroutine, so it adds
+	// its own latency to Add.
+	Spill func(item T)
+
+	// Name identifies the buffer in exported metrics, as the "buffer.name"
+	// attribute. Use it to tell several buffers in one process apart.
+	//
+	// Default: "".
+	Name string
+
+	// TracerProvider creates the "buffer.flush" span started around the
+	// delivery of each batch (covering all retries and the dead letter).
+	// Flush receives a context carrying the span, so backend calls made
+	// with it show up as its children.
+	//
+	// Default: the global provider, i.e. the one installed by
+	// tracer.InitTracer. Spans are no-ops until a provider is installed.
+	TracerProvider trace.TracerProvider
+
+	// MeterProvider, if set, exports the counters behind Stats, the flush
+	// latency and batch size histograms, and the number of items in the
+	// channel as OpenTelemetry metrics named "buffer.*".
+	//
+	// Default: nil (metrics are only available through Stats).
+	MeterProvider metric.MeterProvider
 }
 
 func (c *Config[T]) withDefaults() Config[T] {
@@ -218,6 +378,25 @@ func (c *Config[T]) withDefaults() Config[T] {
 	if cfg.OnFlushError == nil {
 		cfg.OnFlushError = func(error, []T) {}
 	}
+	if cfg.OnDrop == nil {
+		cfg.OnDrop = func(T) {}
+	}
+	if cfg.DrainTimeout == 0 {
+		cfg.DrainTimeout = 10 * time.Second
+	}
+	cfg.Retry = cfg.Retry.withDefaults()
+	if cfg.FlushConcurrency < 1 {
+		cfg.FlushConcurrency = 1
+	}
+	if cfg.FlushQueueSize <= 0 {
+		cfg.FlushQueueSize = cfg.FlushConcurrency
+	}
+	if cfg.WAL != nil {
+		cfg.WAL = cfg.WAL.withDefaults()
+	}
+	if cfg.Adaptive != nil {
+		cfg.Adaptive = cfg.Adaptive.withDefaults(cfg.Capacity, cfg.FlushInterval)
+	}
 
 	return cfg
 }
@@ -225,29 +404,119 @@ func (c *Config[T]) withDefaults() Config[T] {
 type Buffer[T any] struct {
 	dataChan chan T
 	cfg      Config[T]
+
+	// drain is started by Close and bounds retries during the final drain.
+	drain drainClock
+
+	// started is claimed by Run, or by Shutdown if Run was never called,
+	// so that exactly one of them runs the loop. done is closed when the
+	// loop returns, and runErr holds what it returned.
+	started atomic.Bool
+	done    chan struct{}
+	runErr  error
+
+	// flushReq carries FlushNow requests to Run, which replies with the
+	// flush error on the given channel.
+	flushReq chan chan error
+
+	// pending is the number of accepted items that have not been through
+	// a flush yet, reported by Shutdown when its deadline hits.
+	pending atomic.Int64
+
+	// obs records Stats and emits the flush spans and metrics.
+	obs *observer
+
+	// adaptive is nil unless Config.Adaptive is set.
+	adaptive *adaptiveController
+
+	// wal is nil unless Config.WAL is set. walLock serialises appending to
+	// the log with sending to dataChan, so that Run receives items in log
+	// order. It is a channel so that waiting for it honours ctx.
+	wal     *wal
+	walLock chan struct{}
+
+	// replay holds the items recovered from the log by NewBuffer, which
+	// Run processes before anything from dataChan. replayedSeqs is the
+	// number of log records they were recovered from.
+	replay       []walEntry[T]
+	replayedSeqs uint64
 }
 
+// NewBuffer returns a Buffer for the given config.
+//
+// If cfg.WAL is set, NewBuffer opens the log and reads back every item a
+// previous process left unflushed; an error is returned if the log cannot
+// be opened.
 func NewBuffer[T any](cfg Config[T]) (*Buffer[T], error) {
 	if cfg.Flush == nil {
 		return nil, fmt.Errorf("buffer: Flush is required")
 	}
+	if cfg.WAL != nil && cfg.WAL.Dir == "" {
+		return nil, fmt.Errorf("buffer: WAL.Dir is required")
+	}
+	if cfg.WAL != nil && cfg.Overflow == OverflowDropOldest {
+		return nil, fmt.Errorf("buffer: OverflowDropOldest cannot be used with WAL")
+	}
+	if cfg.Overflow == OverflowSpill && cfg.Spill == nil {
+		return nil, fmt.Errorf("buffer: Spill is required with OverflowSpill")
+	}
+	if (cfg.KeyFunc == nil) != (cfg.Merge == nil) {
+		return nil, fmt.Errorf("buffer: KeyFunc and Merge must be set together")
+	}
 	c := cfg.withDefaults()
-	return &Buffer[T]{
+	var adaptive *adaptiveController
+	if c.Adaptive != nil {
+		if err := c.Adaptive.validate(); err != nil {
+			return nil, err
+		}
+		adaptive = newAdaptiveController(c.Adaptive, c.Capacity, c.FlushInterval)
+		c.Flush = adaptiveFlush(adaptive, c.Flush)
+		if cfg.ShouldFlush == nil {
+			c.ShouldFlush = func(batch []T) bool {
+				return len(batch) >= adaptive.batchSize()
+			}
+		} else {
+			shouldFlush := cfg.ShouldFlush
+			c.ShouldFlush = func(batch []T) bool {
+				return len(batch) >= adaptive.batchSize() || shouldFlush(batch)
+			}
+		}
+	}
+	b := &Buffer[T]{
 		dataChan: make(chan T, c.ChanSize),
 		cfg:      c,
-	}, nil
+		drain:    newDrainClock(),
+		done:     make(chan struct{}),
+		flushReq: make(chan chan error),
+		adaptive: adaptive,
+	}
+	obs, err := newObserver(c.Name, c.TracerProvider, c.MeterProvider, b.ItemsInChannel)
+	if err != nil {
+		return nil, fmt.Errorf("buffer: metrics: %w", err)
+	}
+	b.obs = obs
+	if c.WAL != nil {
+		if err := b.openWAL(); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
 }
 
 // Add sends an item to the buffer's internal channel for processing by Run.
 //
 // It blocks if the channel is full, providing natural backpressure to the
 // producer. Pass a context with a timeout or deadline to bound how long Add
-// may block before giving up.
+// may block before giving up. Config.Overflow can replace blocking with
+// shedding load; see OverflowPolicy for what Add does then.
 //
 // Add returns ctx.Err() if the context is cancelled before the item could
 // be queued. The item is not added in that case — the caller is responsible
 // for handling it (e.g. nacking a Pub/Sub message).
 //
+// With a WAL configured, Add also returns an error if the item cannot be
+// encoded or written to the log; the item is not added in that case either.
+//
 // Add must not be called after Close. Doing so will panic.
 func (b *Buffer[T]) Add(ctx context.Context, item T) error {
 	// If context is already cancelled, we just return
@@ -256,18 +525,21 @@ func (b *Buffer[T]) Add(ctx context.Context, item T) error {
 		return err
 	}
 
-	select {
-	case b.dataChan <- item:
-		return nil
-	case <-ctx.Done():
-		return ctx.Err()
+	if b.wal != nil {
+		return b.addDurable(ctx, item, true)
 	}
+	return b.offer(ctx, item, true, nil)
 }
 
-// Close signals Run that no more items will be produced.
+// Close signals Run that no more items will be produced. It does not wait
+// for the drain: use Shutdown for that.
 //
 // It closes the internal channel, which causes Run to drain all remaining
-// buffered items, perform a final flush, and return nil.
+// buffered items, perform a final flush, wait for every in-flight flush
+// (see FlushConcurrency) and return nil. Once Run has returned, every
+// accepted item has either been flushed or reported to OnFlushError. If Run
+// was never started, Close starts the final drain in the background, so
+// items added (or recovered from the WAL) before Close are still flushed.
 //
 // # Caller responsibility
 //
@@ -289,8 +561,49 @@ func (b *Buffer[T]) Add(ctx context.Context, item T) error {
 //
 // Close is the only mechanism that stops Run. Cancelling the context passed
 // to Run does NOT stop it — see Run for details.
+//
+// Close also starts the DrainTimeout clock: from here on, failed flushes are
+// only retried while the deadline allows it. Use Shutdown to bound the whole
+// drain rather than just the retries.
 func (b *Buffer[T]) Close() {
+	b.close(b.cfg.DrainTimeout)
+}
+
+// close starts the drain clock with timeout and closes dataChan, starting
+// the loop in the background if Run never did.
+func (b *Buffer[T]) close(timeout time.Duration) {
+	b.drain.start(timeout)
 	close(b.dataChan)
+
+	if b.started.CompareAndSwap(false, true) {
+		// Run was never started: drain in its place.
+		go b.start(context.Background())
+	}
+}
+
+// Stats returns a snapshot of the buffer's counters and histograms.
+//
+// Like ItemsInChannel, it is intended for monitoring and diagnostics; the
+// individual values are read independently and may be slightly out of step
+// with each other.
+func (b *Buffer[T]) Stats() Stats {
+	s := b.obs.snapshot()
+	s.ItemsInChannel = b.ItemsInChannel()
+	s.BatchLimit = b.cfg.Capacity
+	s.FlushInterval = b.flushInterval()
+	if b.adaptive != nil {
+		s.BatchLimit = b.adaptive.batchSize()
+	}
+	return s
+}
+
+// flushInterval returns the current flush interval, which only changes with
+// Config.Adaptive set.
+func (b *Buffer[T]) flushInterval() time.Duration {
+	if b.adaptive != nil {
+		return b.adaptive.flushInterval()
+	}
+	return b.cfg.FlushInterval
 }
 
 // ItemsInChannel returns the number of items currently sitting in the
@@ -307,7 +620,13 @@ func (b *Buffer[T]) ItemsInChannel() int {
 //
 // Run blocks until the internal channel is closed via [Buffer.Close], at
 // which point it drains any remaining items, performs a final flush, and
-// returns nil.
+// returns nil (or the error from closing the WAL, if one is configured).
+//
+// With a WAL configured, Run first processes the items NewBuffer recovered
+// from the log, exactly as if they had just been added.
+//
+// If Close or Shutdown is called before Run, they run the drain themselves
+// and Run only waits for it to finish.
 //
 // # Context
 //
@@ -324,7 +643,8 @@ func (b *Buffer[T]) ItemsInChannel() int {
 //
 //  1. Stop all producers (ensure no further Add calls will be made).
 //  2. Call buf.Close() to signal Run that the input is exhausted.
-//  3. Wait for Run to return (it will drain and flush everything first).
+//  3. Wait for Run to return (it will drain and flush everything first),
+//     or call Shutdown instead of Close to do both with a deadline.
 //
 // Example with a Pub/Sub subscriber as the producer:
 //
@@ -352,54 +672,238 @@ func (b *Buffer[T]) ItemsInChannel() int {
 //	    return backend.Send(flushCtx, batch)
 //	},
 func (b *Buffer[T]) Run(ctx context.Context) error {
+	if b.started.CompareAndSwap(false, true) {
+		b.start(ctx)
+	}
+	<-b.done
+	return b.runErr
+}
+
+// start runs the loop and records its result. It is called by whichever of
+// Run and Shutdown claimed started.
+func (b *Buffer[T]) start(ctx context.Context) {
+	b.runErr = b.run(ctx)
+	close(b.done)
+}
+
+func (b *Buffer[T]) run(ctx context.Context) error {
 	cfg := b.cfg
 	batch := make([]T, 0, cfg.Capacity)
 
-	ticker := time.NewTicker(cfg.FlushInterval)
+	// With a WAL, seqs holds the log sequence number of every item in
+	// batch, and seq is the number of the next item to arrive on dataChan.
+	// Items reach Run in log order, so counting them is enough to know
+	// their numbers.
+	var seqs []uint64
+	seq := b.replayedSeqs
+
+	// With KeyFunc, keys maps the key of each item in batch to its index.
+	var keys map[string]int
+	if cfg.KeyFunc != nil {
+		keys = make(map[string]int)
+	}
+
+	ticker := time.NewTicker(b.flushInterval())
 	defer ticker.Stop()
 
-	flush := func() {
+	var pool *flushPool[T]
+	if cfg.FlushConcurrency > 1 {
+		pool = b.startFlushPool(ctx)
+	}
+
+	// flush returns the error from a synchronous delivery; batches handed
+	// to the pool report theirs to OnFlushError only. FlushNow always
+	// delivers synchronously so that it can return the error.
+	flush := func(trigger Trigger) error {
 		if len(batch) == 0 {
-			return
+			return nil
 		}
 
-		if err := cfg.Flush(ctx, batch); err != nil {
-			cfg.OnFlushError(err, batch)
+		var err error
+		if pool != nil && trigger != TriggerManual {
+			batch, seqs = pool.submit(trigger, batch, seqs)
+		} else {
+			var ok bool
+			if ok, err = b.deliver(ctx, trigger, batch); ok {
+				b.ack(seqs)
+			}
+			b.pending.Add(-int64(len(batch)))
+			batch = batch[:0]
+			seqs = seqs[:0]
 		}
-
-		batch = batch[:0]
-		ticker.Reset(cfg.FlushInterval)
+		clear(keys)
+		ticker.Reset(b.flushInterval())
+		return err
 	}
 
-	processItem := func(item T) {
+	processItem := func(item T, itemSeq uint64) {
+		b.obs.added()
+
+		var key string
+		if keys != nil {
+			key = cfg.KeyFunc(item)
+			if i, ok := keys[key]; ok {
+				batch[i] = cfg.Merge(batch[i], item)
+				if b.wal != nil {
+					seqs = append(seqs, itemSeq)
+				}
+				b.obs.merged()
+				b.pending.Add(-1)
+				if cfg.ShouldFlush(batch) {
+					flush(TriggerSize)
+				}
+				return
+			}
+		}
+
 		if cfg.WillOverflow(batch, item) {
 			if len(batch) > 0 {
-				flush()
+				flush(TriggerOverflow)
 			}
 		}
 
 		if !cfg.CanAdd(batch, item) {
+			b.obs.rejected()
 			cfg.OnReject(item)
+			b.ack([]uint64{itemSeq})
+			b.pending.Add(-1)
 			return
 		}
 
+		if keys != nil {
+			keys[key] = len(batch)
+		}
 		batch = append(batch, item)
+		if b.wal != nil {
+			seqs = append(seqs, itemSeq)
+		}
 
 		if cfg.ShouldFlush(batch) {
-			flush()
+			flush(TriggerSize)
 		}
 	}
 
+	for _, e := range b.replay {
+		processItem(e.item, e.seq)
+	}
+	b.replay = nil
+
 	for {
 		select {
 		case item, ok := <-b.dataChan:
 			if !ok {
-				flush()
+				flush(TriggerClose)
+				if pool != nil {
+					pool.close()
+				}
+				b.obs.close()
+				if b.wal != nil {
+					return b.wal.close()
+				}
 				return nil
 			}
-			processItem(item)
+			processItem(item, seq)
+			seq++
 		case <-ticker.C:
-			flush()
+			flush(TriggerInterval)
+		case reply := <-b.flushReq:
+			// Take in everything that was added before FlushNow was
+			// called, so that it is part of the flush. The channel is
+			// drained until empty rather than for its length, since
+			// OverflowDropOldest may evict items in the meantime.
+		drain:
+			for {
+				select {
+				case item, ok := <-b.dataChan:
+					if !ok {
+						break drain
+					}
+					processItem(item, seq)
+					seq++
+				default:
+					break drain
+				}
+			}
+			reply <- flush(TriggerManual)
 		}
 	}
 }
+
+// deliver hands batch to Flush, retrying according to cfg.Retry. A batch that
+// cannot be flushed is given to DeadLetter (if set) and then reported to
+// OnFlushError. It reports whether the batch was taken by Flush or
+// DeadLetter, and returns the error given to OnFlushError.
+func (b *Buffer[T]) deliver(ctx context.Context, trigger Trigger, batch []T) (bool, error) {
+	return flushWithRetry(ctx, &b.cfg, &b.drain, b.obs, trigger, batch)
+}
+
+// ack tells the WAL, if any, that the items with the given sequence numbers
+// no longer need to be kept.
+func (b *Buffer[T]) ack(seqs []uint64) {
+	if b.wal != nil {
+		b.wal.ack(seqs)
+	}
+}
+
+// openWAL opens the log configured in b.cfg.WAL and decodes the items left
+// behind by a previous process into b.replay.
+func (b *Buffer[T]) openWAL() error {
+	wc := b.cfg.WAL
+	w, payloads, err := openWAL(wc.Dir, wc.SegmentSize, wc.SyncEveryWrite, wc.OnCorrupt)
+	if err != nil {
+		return err
+	}
+
+	// Recovered records keep sequence numbers 0..len(payloads)-1. A record
+	// that no longer decodes is acknowledged straight away so that its
+	// segment can still be cleaned up.
+	b.replay = make([]walEntry[T], 0, len(payloads))
+	for i, payload := range payloads {
+		item, err := wc.Codec.Unmarshal(payload)
+		if err != nil {
+			wc.OnCorrupt(fmt.Errorf("buffer: wal: decode: %w", err))
+			w.ack([]uint64{uint64(i)})
+			continue
+		}
+		b.replay = append(b.replay, walEntry[T]{seq: uint64(i), item: item})
+	}
+	b.replayedSeqs = uint64(len(payloads))
+	b.pending.Add(int64(len(b.replay)))
+
+	b.wal = w
+	b.walLock = make(chan struct{}, 1)
+	return nil
+}
+
+// addDurable writes item to the WAL and then queues it for Run. Holding
+// walLock across both steps keeps dataChan in log order. If the item ends
+// up not being queued, its record is removed from the log again.
+func (b *Buffer[T]) addDurable(ctx context.Context, item T, wait bool) error {
+	payload, err := b.cfg.WAL.Codec.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("buffer: wal: encode: %w", err)
+	}
+
+	if wait || b.cfg.Overflow != OverflowBlock {
+		select {
+		case b.walLock <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	} else {
+		// TryAdd with the blocking policy: another producer holding the
+		// lock is itself waiting for room, so the buffer is full.
+		select {
+		case b.walLock <- struct{}{}:
+		default:
+			b.drop(item)
+			return ErrFull
+		}
+	}
+	defer func() { <-b.walLock }()
+
+	if _, err := b.wal.append(payload); err != nil {
+		return err
+	}
+	return b.offer(ctx, item, wait, b.wal.undo)
+}
@@ -193,6 +193,47 @@ func TestBuffer_Close(t *testing.T) {
 	}
 }
 
+// TestBuffer_CloseWithoutRun verifies that Close does not hang when Run was
+// never started, and flushes the items added before it.
+func TestBuffer_CloseWithoutRun(t *testing.T) {
+	var mu sync.Mutex
+	var received []int
+
+	cfg := Config[int]{
+		Flush: func(ctx context.Context, batch []int) error {
+			mu.Lock()
+			received = append(received, batch...)
+			mu.Unlock()
+			return nil
+		},
+	}
+
+	buf := mustNewBuffer(t, cfg)
+	buf.Add(context.Background(), 1)
+	buf.Add(context.Background(), 2)
+
+	closed := make(chan struct{})
+	go func() {
+		buf.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked without Run")
+	}
+
+	// Run waits for the drain Close started.
+	if err := buf.Run(context.Background()); err != nil {
+		t.Errorf("Run after Close: %v", err)
+	}
+	mu.Lock()
+	if len(received) != 2 {
+		t.Errorf("expected both items to be flushed, got %v", received)
+	}
+	mu.Unlock()
+}
+
 func TestBuffer_WillOverflow(t *testing.T) {
 	var mu sync.Mutex
 	var received [][]int
@@ -487,3 +528,51 @@ func TestBuffer_NoEmptyFlushes(t *testing.T) {
 		t.Errorf("expected 0 flushes for empty buffer, got %d", flushCount)
 	}
 }
+
+// TestBuffer_Merge verifies that items sharing a key are coalesced within a
+// batch, and that keys start over with the next batch.
+func TestBuffer_Merge(t *testing.T) {
+	type click struct {
+		campaign string
+		count    int
+	}
+
+	var batches [][]click
+	cfg := Config[click]{
+		Capacity: 2,
+		KeyFunc:  func(c click) string { return c.campaign },
+		Merge: func(existing, incoming click) click {
+			existing.count += incoming.count
+			return existing
+		},
+		Flush: func(ctx context.Context, batch []click) error {
+			batches = append(batches, append([]click(nil), batch...))
+			return nil
+		},
+	}
+
+	buf := mustNewBuffer(t, cfg)
+	go buf.Run(context.Background())
+	for _, c := range []string{"a", "a", "a", "b", "a"} {
+		buf.Add(context.Background(), click{campaign: c, count: 1})
+	}
+	buf.Shutdown(context.Background())
+
+	if len(batches) != 2 {
+		t.Fatalf("expected 2 batches, got %v", batches)
+	}
+	if first := batches[0]; len(first) != 2 || first[0] != (click{"a", 3}) || first[1] != (click{"b", 1}) {
+		t.Errorf("expected the first batch to be [{a 3} {b 1}], got %v", first)
+	}
+	if second := batches[1]; len(second) != 1 || second[0] != (click{"a", 1}) {
+		t.Errorf("expected the second batch to start over with [{a 1}], got %v", second)
+	}
+	if s := buf.Stats(); s.ItemsMerged != 2 || s.ItemsFlushed != 3 {
+		t.Errorf("expected 2 merged and 3 flushed items, got %d and %d", s.ItemsMerged, s.ItemsFlushed)
+	}
+
+	cfg.Merge = nil
+	if _, err := NewBuffer(cfg); err == nil {
+		t.Error("expected KeyFunc without Merge to be rejected")
+	}
+}
@@ -0,0 +1,81 @@
+package buffer
+
+import (
+	"context"
+	"sync"
+)
+
+// flushPool runs Flush on a fixed number of goroutines so that a slow
+// backend does not stall Run's intake loop.
+//
+// Ownership of batch slices rotates between Run and the workers: Run fills a
+// batch, hands it over through queue and takes an empty one from free. A
+// worker returns the slice to free once deliver has finished with it, so a
+// batch is never written to while a Flush call may still be reading it.
+//
+// At most workers+queue batches are owned by the pool at any time; once
+// that many are in flight, submit blocks and Run stops draining dataChan,
+// which in turn makes Add block — the same backpressure the synchronous
+// mode provides.
+type flushPool[T any] struct {
+	queue chan flushJob[T]
+	free  chan flushJob[T]
+	size  int
+	wg    sync.WaitGroup
+}
+
+// flushJob is a batch travelling through the pool together with the WAL
+// sequence numbers of its items (empty when there is no WAL).
+type flushJob[T any] struct {
+	batch   []T
+	seqs    []uint64
+	trigger Trigger
+}
+
+func (b *Buffer[T]) startFlushPool(ctx context.Context) *flushPool[T] {
+	cfg := b.cfg
+	p := &flushPool[T]{
+		queue: make(chan flushJob[T], cfg.FlushQueueSize),
+		// One extra slot for the batch Run is filling, so returning a
+		// slice never blocks a worker.
+		free: make(chan flushJob[T], cfg.FlushConcurrency+cfg.FlushQueueSize+1),
+		size: cfg.Capacity,
+	}
+
+	for range cfg.FlushConcurrency {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for job := range p.queue {
+				if ok, _ := b.deliver(ctx, job.trigger, job.batch); ok {
+					b.ack(job.seqs)
+				}
+				b.pending.Add(-int64(len(job.batch)))
+				p.free <- flushJob[T]{batch: job.batch[:0], seqs: job.seqs[:0]}
+			}
+		}()
+	}
+	return p
+}
+
+// submit queues batch for flushing and returns an empty batch (and seqs
+// slice) for Run to fill next. It blocks while the maximum number of
+// batches are in flight.
+func (p *flushPool[T]) submit(trigger Trigger, batch []T, seqs []uint64) ([]T, []uint64) {
+	p.queue <- flushJob[T]{batch: batch, seqs: seqs, trigger: trigger}
+
+	select {
+	case next := <-p.free:
+		return next.batch, next.seqs
+	default:
+		// Every other batch is queued or being flushed, which the bound
+		// on queue keeps to at most workers+queue slices.
+		return make([]T, 0, p.size), nil
+	}
+}
+
+// close stops accepting batches and waits for in-flight flushes to finish.
+func (p *flushPool[T]) close() {
+	close(p.queue)
+	p.wg.Wait()
+}
@@ -0,0 +1,134 @@
+package buffer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestBuffer_FlushConcurrency verifies that batches are flushed in parallel
+// and that Shutdown waits for every in-flight flush before returning.
+func TestBuffer_FlushConcurrency(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int64
+	var itemCount atomic.Int64
+	const totalItems = 100
+
+	cfg := Config[int]{
+		Capacity:         10,
+		FlushConcurrency: 4,
+		Flush: func(ctx context.Context, batch []int) error {
+			n := inFlight.Add(1)
+			for {
+				m := maxInFlight.Load()
+				if n <= m || maxInFlight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			itemCount.Add(int64(len(batch)))
+			inFlight.Add(-1)
+			return nil
+		},
+	}
+
+	buf := mustNewBuffer(t, cfg)
+	go buf.Run(context.Background())
+
+	for i := range totalItems {
+		buf.Add(context.Background(), i)
+	}
+	buf.Shutdown(context.Background())
+
+	// No sleep here: Shutdown must not return before the workers are done.
+	if itemCount.Load() != totalItems {
+		t.Errorf("expected %d items flushed by the time Shutdown returns, got %d", totalItems, itemCount.Load())
+	}
+	if maxInFlight.Load() < 2 {
+		t.Errorf("expected flushes to overlap, max in flight was %d", maxInFlight.Load())
+	}
+	if maxInFlight.Load() > 4 {
+		t.Errorf("expected at most 4 concurrent flushes, got %d", maxInFlight.Load())
+	}
+}
+
+// TestBuffer_FlushConcurrencyOwnership verifies that the buffer never writes
+// to a batch while a worker is still flushing it.
+func TestBuffer_FlushConcurrencyOwnership(t *testing.T) {
+	var mu sync.Mutex
+	var corrupted bool
+	seen := make(map[int]bool)
+
+	cfg := Config[int]{
+		Capacity:         5,
+		FlushConcurrency: 3,
+		FlushQueueSize:   1,
+		Flush: func(ctx context.Context, batch []int) error {
+			snapshot := append([]int(nil), batch...)
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			for i, v := range batch {
+				if v != snapshot[i] {
+					corrupted = true
+				}
+				seen[v] = true
+			}
+			return nil
+		},
+	}
+
+	buf := mustNewBuffer(t, cfg)
+	go buf.Run(context.Background())
+
+	for i := range 200 {
+		buf.Add(context.Background(), i)
+	}
+	buf.Shutdown(context.Background())
+
+	mu.Lock()
+	defer mu.Unlock()
+	if corrupted {
+		t.Error("batch was modified while Flush was still using it")
+	}
+	if len(seen) != 200 {
+		t.Errorf("expected 200 distinct items flushed, got %d", len(seen))
+	}
+}
+
+// TestBuffer_FlushConcurrencyBackpressure verifies that once every worker is
+// busy and the queue is full, Run stops taking items and Add blocks.
+func TestBuffer_FlushConcurrencyBackpressure(t *testing.T) {
+	release := make(chan struct{})
+
+	cfg := Config[int]{
+		Capacity:         1,
+		ChanSize:         1,
+		FlushConcurrency: 2,
+		FlushQueueSize:   1,
+		Flush: func(ctx context.Context, batch []int) error {
+			<-release
+			return nil
+		},
+	}
+
+	buf := mustNewBuffer(t, cfg)
+	go buf.Run(context.Background())
+	t.Cleanup(func() {
+		close(release)
+		buf.Close()
+	})
+
+	// 2 items held by workers, 1 queued, 1 blocking Run in submit and
+	// 1 sitting in dataChan.
+	for i := range 5 {
+		buf.Add(context.Background(), i)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := buf.Add(ctx, 5); err == nil {
+		t.Error("expected Add to block once all flush slots are in use")
+	}
+}
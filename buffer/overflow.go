@@ -0,0 +1,143 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrFull is returned by Add and TryAdd when the buffer is full and the
+// item was dropped instead of being queued.
+var ErrFull = errors.New("buffer: full")
+
+// OverflowPolicy decides what happens to an item when dataChan is full,
+// i.e. when Run cannot keep up with producers.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes Add wait for room until its context is done,
+	// pushing backpressure onto the producer. TryAdd drops the item and
+	// returns ErrFull instead of waiting.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropNewest discards the item being added: OnDrop is called
+	// and Add returns ErrFull straight away.
+	OverflowDropNewest
+
+	// OverflowDropOldest evicts the oldest item waiting in dataChan to make
+	// room for the new one, which is always accepted. The evicted item is
+	// passed to OnDrop. Not available together with WAL.
+	OverflowDropOldest
+
+	// OverflowSpill hands the item to Config.Spill and reports success:
+	// Add returns nil, since the item has been taken care of.
+	OverflowSpill
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowSpill:
+		return "spill"
+	}
+	return "unknown"
+}
+
+// TryAdd is the non-blocking variant of Add, meant for latency-sensitive
+// callers such as HTTP handlers that would rather shed load than wait.
+//
+// If dataChan has room, the item is queued and TryAdd returns nil.
+// Otherwise the overflow policy applies as described on OverflowPolicy,
+// except that OverflowBlock does not wait: the item is dropped, OnDrop is
+// called and TryAdd returns ErrFull.
+//
+//	if err := buf.TryAdd(click); errors.Is(err, buffer.ErrFull) {
+//	    w.WriteHeader(http.StatusServiceUnavailable)
+//	    return
+//	}
+//
+// Like Add, TryAdd must not be called after Close.
+func (b *Buffer[T]) TryAdd(item T) error {
+	if b.wal != nil {
+		return b.addDurable(context.Background(), item, false)
+	}
+	return b.offer(context.Background(), item, false, nil)
+}
+
+// offer puts item on dataChan, applying the overflow policy when it is
+// full. wait reports whether OverflowBlock may wait for room (Add) or not
+// (TryAdd). undo, if not nil, is called whenever the item does not end up
+// on dataChan.
+func (b *Buffer[T]) offer(ctx context.Context, item T, wait bool, undo func()) error {
+	if b.enqueue(item) {
+		return nil
+	}
+
+	switch b.cfg.Overflow {
+	case OverflowDropOldest:
+		for {
+			select {
+			case old := <-b.dataChan:
+				b.pending.Add(-1)
+				b.drop(old)
+			default:
+			}
+			if b.enqueue(item) {
+				return nil
+			}
+			// Another producer took the slot; evict again.
+		}
+
+	case OverflowSpill:
+		if undo != nil {
+			undo()
+		}
+		b.obs.spilled()
+		b.cfg.Spill(item)
+		return nil
+
+	case OverflowBlock:
+		if wait {
+			b.pending.Add(1)
+			select {
+			case b.dataChan <- item:
+				return nil
+			case <-ctx.Done():
+				b.pending.Add(-1)
+				if undo != nil {
+					undo()
+				}
+				return ctx.Err()
+			}
+		}
+	}
+
+	if undo != nil {
+		undo()
+	}
+	b.drop(item)
+	return ErrFull
+}
+
+// enqueue puts item on dataChan if it has room. The item is counted as
+// pending before it is sent: once on dataChan it may be evicted or flushed
+// at any time, and pending must not go below zero when it is.
+func (b *Buffer[T]) enqueue(item T) bool {
+	b.pending.Add(1)
+	select {
+	case b.dataChan <- item:
+		return true
+	default:
+		b.pending.Add(-1)
+		return false
+	}
+}
+
+func (b *Buffer[T]) drop(item T) {
+	b.obs.dropped()
+	b.cfg.OnDrop(item)
+}
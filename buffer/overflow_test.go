@@ -0,0 +1,209 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newStalledBuffer returns a buffer whose Run is never started, so dataChan
+// fills up after ChanSize items.
+func newStalledBuffer(t *testing.T, cfg Config[int]) *Buffer[int] {
+	t.Helper()
+	cfg.ChanSize = 2
+	cfg.Flush = func(ctx context.Context, batch []int) error { return nil }
+	return mustNewBuffer(t, cfg)
+}
+
+func TestBuffer_TryAddBlockPolicy(t *testing.T) {
+	var dropped []int
+	buf := newStalledBuffer(t, Config[int]{
+		OnDrop: func(item int) { dropped = append(dropped, item) },
+	})
+
+	for i := range 2 {
+		if err := buf.TryAdd(i); err != nil {
+			t.Fatalf("expected TryAdd to succeed while there is room, got %v", err)
+		}
+	}
+	if err := buf.TryAdd(2); !errors.Is(err, ErrFull) {
+		t.Errorf("expected ErrFull from a full buffer, got %v", err)
+	}
+	if len(dropped) != 1 || dropped[0] != 2 {
+		t.Errorf("expected item 2 to be reported as dropped, got %v", dropped)
+	}
+	if s := buf.Stats(); s.ItemsDropped != 1 {
+		t.Errorf("expected 1 dropped item in stats, got %d", s.ItemsDropped)
+	}
+}
+
+func TestBuffer_OverflowDropNewest(t *testing.T) {
+	var dropped []int
+	buf := newStalledBuffer(t, Config[int]{
+		Overflow: OverflowDropNewest,
+		OnDrop:   func(item int) { dropped = append(dropped, item) },
+	})
+
+	ctx := context.Background()
+	buf.Add(ctx, 1)
+	buf.Add(ctx, 2)
+
+	// Add must not block even though nothing is draining the channel.
+	if err := buf.Add(ctx, 3); !errors.Is(err, ErrFull) {
+		t.Errorf("expected ErrFull, got %v", err)
+	}
+	if len(dropped) != 1 || dropped[0] != 3 {
+		t.Errorf("expected the newest item to be dropped, got %v", dropped)
+	}
+}
+
+func TestBuffer_OverflowDropOldest(t *testing.T) {
+	var dropped []int
+	buf := newStalledBuffer(t, Config[int]{
+		Overflow: OverflowDropOldest,
+		OnDrop:   func(item int) { dropped = append(dropped, item) },
+	})
+
+	ctx := context.Background()
+	for i := 1; i <= 4; i++ {
+		if err := buf.Add(ctx, i); err != nil {
+			t.Fatalf("expected Add to always succeed, got %v", err)
+		}
+	}
+
+	if len(dropped) != 2 || dropped[0] != 1 || dropped[1] != 2 {
+		t.Errorf("expected the two oldest items to be evicted, got %v", dropped)
+	}
+	if first, second := <-buf.dataChan, <-buf.dataChan; first != 3 || second != 4 {
+		t.Errorf("expected [3 4] to remain queued, got [%d %d]", first, second)
+	}
+}
+
+// TestBuffer_DropOldestFlushNow verifies that FlushNow does not wait for
+// items evicted while it drains the channel, and that the pending count
+// stays consistent under concurrent evictions.
+func TestBuffer_DropOldestFlushNow(t *testing.T) {
+	var flushed, dropped atomic.Int64
+	buf := mustNewBuffer(t, Config[int]{
+		Capacity:      1000,
+		ChanSize:      4,
+		FlushInterval: time.Hour,
+		Overflow:      OverflowDropOldest,
+		OnDrop:        func(int) { dropped.Add(1) },
+		Flush: func(ctx context.Context, batch []int) error {
+			flushed.Add(int64(len(batch)))
+			return nil
+		},
+	})
+	go buf.Run(context.Background())
+
+	const producers, perProducer = 4, 2000
+	var wg sync.WaitGroup
+	for range producers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perProducer {
+				buf.TryAdd(i)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		err := buf.FlushNow(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("expected FlushNow to return, got %v", err)
+		}
+		if n := buf.pending.Load(); n < 0 {
+			t.Fatalf("expected a non-negative pending count, got %d", n)
+		}
+	}
+
+	if err := buf.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := buf.pending.Load(); n != 0 {
+		t.Errorf("expected no pending items after Shutdown, got %d", n)
+	}
+	if total := flushed.Load() + dropped.Load(); total != producers*perProducer {
+		t.Errorf("expected every item to be flushed or dropped, got %d of %d", total, producers*perProducer)
+	}
+}
+
+func TestBuffer_OverflowSpill(t *testing.T) {
+	var mu sync.Mutex
+	var spilled []int
+	buf := newStalledBuffer(t, Config[int]{
+		Overflow: OverflowSpill,
+		Spill: func(item int) {
+			mu.Lock()
+			defer mu.Unlock()
+			spilled = append(spilled, item)
+		},
+	})
+
+	for i := 1; i <= 3; i++ {
+		if err := buf.TryAdd(i); err != nil {
+			t.Fatalf("expected spilled items to count as handled, got %v", err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(spilled) != 1 || spilled[0] != 3 {
+		t.Errorf("expected item 3 to be spilled, got %v", spilled)
+	}
+	if s := buf.Stats(); s.ItemsSpilled != 1 || s.ItemsDropped != 0 {
+		t.Errorf("expected 1 spilled and 0 dropped, got %d and %d", s.ItemsSpilled, s.ItemsDropped)
+	}
+}
+
+func TestBuffer_OverflowConfigValidation(t *testing.T) {
+	flush := func(ctx context.Context, batch []int) error { return nil }
+
+	if _, err := NewBuffer(Config[int]{Flush: flush, Overflow: OverflowSpill}); err == nil {
+		t.Error("expected OverflowSpill without Spill to be rejected")
+	}
+	if _, err := NewBuffer(Config[int]{
+		Flush:    flush,
+		Overflow: OverflowDropOldest,
+		WAL:      &WALConfig[int]{Dir: t.TempDir()},
+	}); err == nil {
+		t.Error("expected OverflowDropOldest with WAL to be rejected")
+	}
+}
+
+// TestBuffer_WALTryAddFull verifies that an item dropped by TryAdd does not
+// stay in the write-ahead log.
+func TestBuffer_WALTryAddFull(t *testing.T) {
+	buf := mustNewBuffer(t, Config[int]{
+		ChanSize: 1,
+		Flush:    func(ctx context.Context, batch []int) error { return nil },
+		WAL:      &WALConfig[int]{Dir: t.TempDir()},
+	})
+
+	if err := buf.TryAdd(1); err != nil {
+		t.Fatalf("expected first TryAdd to succeed, got %v", err)
+	}
+	if err := buf.TryAdd(2); !errors.Is(err, ErrFull) {
+		t.Fatalf("expected ErrFull, got %v", err)
+	}
+	if n := buf.wal.nextSeq; n != 1 {
+		t.Errorf("expected only one record in the log, got %d", n)
+	}
+}
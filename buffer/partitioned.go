@@ -0,0 +1,421 @@
+package buffer
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PartitionFlushFunc processes the accumulated batch of a single partition.
+//
+// It follows the same contract as FlushFunc — shield the context during the
+// final drain and do not retain the batch slice — and additionally receives
+// the partition key the batch belongs to.
+type PartitionFlushFunc[K comparable, T any] func(ctx context.Context, key K, batch []T) error
+
+// PartitionConfig holds all behavioural knobs for a PartitionedBuffer.
+//
+// The fields mirror Config and have the same meaning and defaults, except
+// that they apply to each partition independently: every key has its own
+// batch, its own ShouldFlush / WillOverflow evaluation and its own
+// FlushInterval clock. Callbacks that report a batch or an item also
+// receive the key.
+//
+// Only Flush is required.
+//
+//	buf, err := buffer.NewPartitionedBuffer(buffer.PartitionConfig[string, Click]{
+//	    Capacity:      500,
+//	    FlushInterval: 2 * time.Second,
+//	    IdleTTL:       10 * time.Minute,
+//	    Flush: func(ctx context.Context, collection string, batch []Click) error {
+//	        return db.Collection(collection).InsertMany(ctx, toDocs(batch))
+//	    },
+//	})
+type PartitionConfig[K comparable, T any] struct {
+	// Flush is called with the key and batch of a partition every time
+	// that partition is flushed. See FlushFunc for the full semantics.
+	Flush PartitionFlushFunc[K, T]
+
+	// WillOverflow, see Config.WillOverflow. batch is the batch of the
+	// partition the item is being added to.
+	WillOverflow func(batch []T, item T) bool
+
+	// CanAdd, see Config.CanAdd.
+	CanAdd func(batch []T, item T) bool
+
+	// OnReject, see Config.OnReject.
+	OnReject func(key K, item T)
+
+	// ShouldFlush, see Config.ShouldFlush.
+	ShouldFlush func(batch []T) bool
+
+	// OnFlushError, see Config.OnFlushError.
+	OnFlushError func(key K, err error, batch []T)
+
+	// Retry, see Config.Retry.
+	Retry RetryPolicy
+
+	// DeadLetter, see Config.DeadLetter.
+	DeadLetter PartitionFlushFunc[K, T]
+
+	// DrainTimeout, see Config.DrainTimeout.
+	DrainTimeout time.Duration
+
+	// Capacity is the target batch size of each partition, see
+	// Config.Capacity.
+	Capacity int
+
+	// ChanSize is the size of the single channel shared by all partitions,
+	// see Config.ChanSize.
+	ChanSize int
+
+	// FlushInterval is the maximum time between two flushes of the same
+	// partition, see Config.FlushInterval. Each partition keeps its own
+	// deadline, counted from its creation or its last flush.
+	FlushInterval time.Duration
+
+	// IdleTTL is how long a partition may go without receiving an item
+	// before it is evicted. Only partitions with an empty batch are
+	// evicted, so no data is lost; a later item for the same key simply
+	// creates a new partition. This keeps memory bounded when keys are
+	// short-lived.
+	//
+	// Idleness is checked when the FlushInterval deadline of a partition
+	// comes up, so a partition may outlive IdleTTL by up to FlushInterval.
+	//
+	// Default: 10 * FlushInterval.
+	IdleTTL time.Duration
+
+	// Name, see Config.Name.
+	Name string
+
+	// TracerProvider, see Config.TracerProvider. The "buffer.flush" span of
+	// a partition's batch has no attribute naming the key, to keep the
+	// cardinality of the key out of the traces.
+	TracerProvider trace.TracerProvider
+
+	// MeterProvider, see Config.MeterProvider. The metrics cover all
+	// partitions together.
+	MeterProvider metric.MeterProvider
+}
+
+func (c *PartitionConfig[K, T]) withDefaults() PartitionConfig[K, T] {
+	cfg := *c
+
+	if cfg.Capacity == 0 {
+		cfg.Capacity = 100
+	}
+	if cfg.ChanSize == 0 {
+		cfg.ChanSize = 2 * cfg.Capacity
+	}
+	if cfg.FlushInterval == 0 {
+		cfg.FlushInterval = 5 * time.Second
+	}
+	if cfg.IdleTTL == 0 {
+		cfg.IdleTTL = 10 * cfg.FlushInterval
+	}
+	if cfg.OnReject == nil {
+		cfg.OnReject = func(K, T) {}
+	}
+	if cfg.OnFlushError == nil {
+		cfg.OnFlushError = func(K, error, []T) {}
+	}
+
+	return cfg
+}
+
+// forKey builds the Config used for a single partition, binding the
+// key-aware callbacks to key.
+func (c *PartitionConfig[K, T]) forKey(key K) Config[T] {
+	cfg := Config[T]{
+		Flush: func(ctx context.Context, batch []T) error {
+			return c.Flush(ctx, key, batch)
+		},
+		WillOverflow: c.WillOverflow,
+		CanAdd:       c.CanAdd,
+		OnReject: func(item T) {
+			c.OnReject(key, item)
+		},
+		ShouldFlush: c.ShouldFlush,
+		OnFlushError: func(err error, batch []T) {
+			c.OnFlushError(key, err, batch)
+		},
+		Retry:         c.Retry,
+		DrainTimeout:  c.DrainTimeout,
+		Capacity:      c.Capacity,
+		ChanSize:      c.ChanSize,
+		FlushInterval: c.FlushInterval,
+	}
+	if c.DeadLetter != nil {
+		cfg.DeadLetter = func(ctx context.Context, batch []T) error {
+			return c.DeadLetter(ctx, key, batch)
+		}
+	}
+	return cfg.withDefaults()
+}
+
+type keyedItem[K comparable, T any] struct {
+	key  K
+	item T
+}
+
+// partition is the per-key state owned by PartitionedBuffer.Run.
+type partition[T any] struct {
+	cfg        Config[T]
+	batch      []T
+	flushAt    time.Time
+	lastActive time.Time
+}
+
+// deadline schedules a check of the partition key at the given time.
+type deadline[K comparable] struct {
+	at  time.Time
+	key K
+}
+
+// deadlines is a min-heap of deadline ordered by time, holding exactly one
+// entry per partition. Flushing a partition early only moves its flushAt;
+// the stale entry is pushed back to flushAt when it comes up.
+type deadlines[K comparable] []deadline[K]
+
+func (d deadlines[K]) Len() int           { return len(d) }
+func (d deadlines[K]) Less(i, j int) bool { return d[i].at.Before(d[j].at) }
+func (d deadlines[K]) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d *deadlines[K]) Push(x any)        { *d = append(*d, x.(deadline[K])) }
+
+func (d *deadlines[K]) Pop() any {
+	old := *d
+	x := old[len(old)-1]
+	*d = old[:len(old)-1]
+	return x
+}
+
+// PartitionedBuffer batches items per partition key, e.g. per tenant or per
+// destination collection, and flushes each partition on its own schedule.
+//
+// Its lifecycle is identical to Buffer: call Run once in its own goroutine,
+// Add from any number of producers, then Close once producers are done.
+type PartitionedBuffer[K comparable, T any] struct {
+	dataChan chan keyedItem[K, T]
+	cfg      PartitionConfig[K, T]
+	drain    drainClock
+
+	partitions atomic.Int64
+
+	// obs records Stats and emits the flush spans and metrics.
+	obs *observer
+
+	// started is claimed by Run, or by Close if Run was never called, so
+	// that exactly one of them runs the loop. done is closed when the loop
+	// returns.
+	started atomic.Bool
+	done    chan struct{}
+}
+
+// NewPartitionedBuffer returns a PartitionedBuffer for the given config.
+func NewPartitionedBuffer[K comparable, T any](cfg PartitionConfig[K, T]) (*PartitionedBuffer[K, T], error) {
+	if cfg.Flush == nil {
+		return nil, fmt.Errorf("buffer: Flush is required")
+	}
+	c := cfg.withDefaults()
+	b := &PartitionedBuffer[K, T]{
+		dataChan: make(chan keyedItem[K, T], c.ChanSize),
+		cfg:      c,
+		drain:    newDrainClock(),
+		done:     make(chan struct{}),
+	}
+	obs, err := newObserver(c.Name, c.TracerProvider, c.MeterProvider, b.ItemsInChannel)
+	if err != nil {
+		return nil, fmt.Errorf("buffer: metrics: %w", err)
+	}
+	b.obs = obs
+	return b, nil
+}
+
+// Add sends an item for partition key to the buffer. It behaves exactly
+// like Buffer.Add.
+func (b *PartitionedBuffer[K, T]) Add(ctx context.Context, key K, item T) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case b.dataChan <- keyedItem[K, T]{key: key, item: item}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close signals Run that no more items will be produced and, unlike
+// Buffer.Close, waits for it to flush every partition. If Run was never started, Close flushes the items
+// added so far itself. The same caller responsibilities as Buffer.Close
+// apply.
+func (b *PartitionedBuffer[K, T]) Close() {
+	b.drain.start(b.cfg.DrainTimeout)
+	close(b.dataChan)
+
+	if b.started.CompareAndSwap(false, true) {
+		b.run(context.Background())
+	}
+	<-b.done
+}
+
+// ItemsInChannel returns the number of items waiting to be picked up by Run.
+// Intended for monitoring and diagnostics only.
+func (b *PartitionedBuffer[K, T]) ItemsInChannel() int {
+	return len(b.dataChan)
+}
+
+// Partitions returns the number of partitions currently held in memory.
+// Intended for monitoring and diagnostics only.
+func (b *PartitionedBuffer[K, T]) Partitions() int {
+	return int(b.partitions.Load())
+}
+
+// Stats returns a snapshot of the counters and histograms of all
+// partitions together, see Buffer.Stats. BatchLimit and FlushInterval are
+// those of each partition.
+func (b *PartitionedBuffer[K, T]) Stats() Stats {
+	s := b.obs.snapshot()
+	s.ItemsInChannel = b.ItemsInChannel()
+	s.BatchLimit = b.cfg.Capacity
+	s.FlushInterval = b.cfg.FlushInterval
+	return s
+}
+
+// Run is the buffer's main processing loop. It has the same contract as
+// Buffer.Run: it must be called exactly once, cancelling ctx does not stop
+// it, and it returns nil once Close has been called and every partition has
+// been flushed.
+func (b *PartitionedBuffer[K, T]) Run(ctx context.Context) error {
+	if b.started.CompareAndSwap(false, true) {
+		b.run(ctx)
+	}
+	<-b.done
+	return nil
+}
+
+func (b *PartitionedBuffer[K, T]) run(ctx context.Context) {
+	defer close(b.done)
+
+	partitions := make(map[K]*partition[T])
+
+	// One timer follows the earliest deadline; timerC is nil while there
+	// are no partitions.
+	var queue deadlines[K]
+	var timer *time.Timer
+	var timerC <-chan time.Time
+	arm := func(now time.Time) {
+		if len(queue) == 0 {
+			timerC = nil
+			return
+		}
+		d := queue[0].at.Sub(now)
+		if timer == nil {
+			timer = time.NewTimer(d)
+		} else {
+			timer.Reset(d)
+		}
+		timerC = timer.C
+	}
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	flush := func(p *partition[T], now time.Time, trigger Trigger) {
+		p.flushAt = now.Add(p.cfg.FlushInterval)
+		if len(p.batch) == 0 {
+			return
+		}
+		flushWithRetry(ctx, &p.cfg, &b.drain, b.obs, trigger, p.batch)
+		p.batch = p.batch[:0]
+	}
+
+	processItem := func(ki keyedItem[K, T]) {
+		b.obs.added()
+		now := time.Now()
+		p, ok := partitions[ki.key]
+		if !ok {
+			cfg := b.cfg.forKey(ki.key)
+			p = &partition[T]{
+				cfg:     cfg,
+				batch:   make([]T, 0, cfg.Capacity),
+				flushAt: now.Add(cfg.FlushInterval),
+			}
+			partitions[ki.key] = p
+			b.partitions.Store(int64(len(partitions)))
+			// Every partition shares FlushInterval, so the new deadline
+			// is the latest one and only matters if the queue was empty.
+			heap.Push(&queue, deadline[K]{at: p.flushAt, key: ki.key})
+			if timerC == nil {
+				arm(now)
+			}
+		}
+		p.lastActive = now
+
+		if p.cfg.WillOverflow(p.batch, ki.item) {
+			if len(p.batch) > 0 {
+				flush(p, now, TriggerOverflow)
+			}
+		}
+
+		if !p.cfg.CanAdd(p.batch, ki.item) {
+			b.obs.rejected()
+			p.cfg.OnReject(ki.item)
+			return
+		}
+
+		p.batch = append(p.batch, ki.item)
+
+		if p.cfg.ShouldFlush(p.batch) {
+			flush(p, now, TriggerSize)
+		}
+	}
+
+	// expire flushes or evicts the partitions whose deadline has passed,
+	// and re-arms the timer.
+	expire := func(now time.Time) {
+		for len(queue) > 0 && !now.Before(queue[0].at) {
+			d := heap.Pop(&queue).(deadline[K])
+			p := partitions[d.key]
+			if now.Before(p.flushAt) {
+				// Flushed early since the deadline was set.
+				heap.Push(&queue, deadline[K]{at: p.flushAt, key: d.key})
+				continue
+			}
+			flush(p, now, TriggerInterval)
+			if now.Sub(p.lastActive) >= b.cfg.IdleTTL {
+				delete(partitions, d.key)
+				continue
+			}
+			heap.Push(&queue, deadline[K]{at: p.flushAt, key: d.key})
+		}
+		b.partitions.Store(int64(len(partitions)))
+		arm(now)
+	}
+
+	for {
+		select {
+		case ki, ok := <-b.dataChan:
+			if !ok {
+				now := time.Now()
+				for _, p := range partitions {
+					flush(p, now, TriggerClose)
+				}
+				b.obs.close()
+				return
+			}
+			processItem(ki)
+		case now := <-timerC:
+			expire(now)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func mustNewPartitionedBuffer[K comparable, T any](tb testing.TB, cfg PartitionConfig[K, T]) *PartitionedBuffer[K, T] {
+	tb.Helper()
+	buf, err := NewPartitionedBuffer(cfg)
+	if err != nil {
+		tb.Fatalf("failed to initialize partitioned buffer: %v", err)
+	}
+	return buf
+}
+
+// TestPartitionedBuffer_PerKeyBatches verifies that each key accumulates its
+// own batch and reaches Capacity independently of the others.
+func TestPartitionedBuffer_PerKeyBatches(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string][][]int)
+
+	cfg := PartitionConfig[string, int]{
+		Capacity: 2,
+		Flush: func(ctx context.Context, key string, batch []int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			received[key] = append(received[key], append([]int(nil), batch...))
+			return nil
+		},
+	}
+
+	buf := mustNewPartitionedBuffer(t, cfg)
+	go buf.Run(context.Background())
+
+	ctx := context.Background()
+	buf.Add(ctx, "a", 1)
+	buf.Add(ctx, "b", 10)
+	buf.Add(ctx, "a", 2)  // fills "a"
+	buf.Add(ctx, "b", 20) // fills "b"
+	buf.Add(ctx, "c", 100)
+
+	time.Sleep(20 * time.Millisecond)
+
+	mu.Lock()
+	if len(received["a"]) != 1 || len(received["a"][0]) != 2 {
+		t.Errorf("expected one full batch for a, got %v", received["a"])
+	}
+	if len(received["b"]) != 1 || received["b"][0][0] != 10 || received["b"][0][1] != 20 {
+		t.Errorf("expected [10 20] for b, got %v", received["b"])
+	}
+	if len(received["c"]) != 0 {
+		t.Errorf("expected c not to be flushed yet, got %v", received["c"])
+	}
+	mu.Unlock()
+
+	buf.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received["c"]) != 1 || received["c"][0][0] != 100 {
+		t.Errorf("expected final drain to flush c, got %v", received["c"])
+	}
+}
+
+// TestPartitionedBuffer_FlushInterval verifies that partitions are flushed by
+// time even if they never reach Capacity.
+func TestPartitionedBuffer_FlushInterval(t *testing.T) {
+	flushed := make(chan string, 2)
+
+	cfg := PartitionConfig[string, int]{
+		Capacity:      100,
+		FlushInterval: 40 * time.Millisecond,
+		Flush: func(ctx context.Context, key string, batch []int) error {
+			flushed <- key
+			return nil
+		},
+	}
+
+	buf := mustNewPartitionedBuffer(t, cfg)
+	go buf.Run(context.Background())
+	t.Cleanup(buf.Close)
+
+	buf.Add(context.Background(), "a", 1)
+	buf.Add(context.Background(), "b", 2)
+
+	got := make(map[string]bool)
+	for range 2 {
+		select {
+		case key := <-flushed:
+			got[key] = true
+		case <-time.After(200 * time.Millisecond):
+			t.Fatalf("expected interval flushes for both partitions, got %v", got)
+		}
+	}
+}
+
+// TestPartitionedBuffer_IdleEviction verifies that partitions which stop
+// receiving items are dropped after IdleTTL.
+func TestPartitionedBuffer_IdleEviction(t *testing.T) {
+	cfg := PartitionConfig[string, int]{
+		Capacity:      1,
+		FlushInterval: 20 * time.Millisecond,
+		IdleTTL:       40 * time.Millisecond,
+		Flush:         func(ctx context.Context, key string, batch []int) error { return nil },
+	}
+
+	buf := mustNewPartitionedBuffer(t, cfg)
+	go buf.Run(context.Background())
+	t.Cleanup(buf.Close)
+
+	buf.Add(context.Background(), "a", 1)
+	buf.Add(context.Background(), "b", 1)
+	time.Sleep(10 * time.Millisecond)
+
+	if n := buf.Partitions(); n != 2 {
+		t.Fatalf("expected 2 partitions, got %d", n)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if n := buf.Partitions(); n != 0 {
+		t.Errorf("expected idle partitions to be evicted, %d left", n)
+	}
+}
+
+// TestPartitionedBuffer_FlushErrorKey verifies that error callbacks are told
+// which partition failed.
+func TestPartitionedBuffer_FlushErrorKey(t *testing.T) {
+	var mu sync.Mutex
+	var failedKeys, deadLetterKeys []string
+
+	cfg := PartitionConfig[string, int]{
+		Capacity: 1,
+		Flush: func(ctx context.Context, key string, batch []int) error {
+			if key == "bad" {
+				return errors.New("write failed")
+			}
+			return nil
+		},
+		DeadLetter: func(ctx context.Context, key string, batch []int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			deadLetterKeys = append(deadLetterKeys, key)
+			return nil
+		},
+		OnFlushError: func(key string, err error, batch []int) {
+			mu.Lock()
+			defer mu.Unlock()
+			failedKeys = append(failedKeys, key)
+		},
+	}
+
+	buf := mustNewPartitionedBuffer(t, cfg)
+	go buf.Run(context.Background())
+
+	buf.Add(context.Background(), "good", 1)
+	buf.Add(context.Background(), "bad", 2)
+	buf.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failedKeys) != 1 || failedKeys[0] != "bad" {
+		t.Errorf("expected OnFlushError for bad only, got %v", failedKeys)
+	}
+	if len(deadLetterKeys) != 1 || deadLetterKeys[0] != "bad" {
+		t.Errorf("expected DeadLetter for bad only, got %v", deadLetterKeys)
+	}
+}
+
+// TestPartitionedBuffer_PerKeyDeadline verifies that each partition is
+// flushed FlushInterval after its own creation or last flush, not on a
+// schedule shared with the other partitions.
+func TestPartitionedBuffer_PerKeyDeadline(t *testing.T) {
+	type flush struct {
+		key string
+		at  time.Time
+	}
+	flushed := make(chan flush, 4)
+
+	cfg := PartitionConfig[string, int]{
+		Capacity:      100,
+		FlushInterval: 80 * time.Millisecond,
+		Flush: func(ctx context.Context, key string, batch []int) error {
+			flushed <- flush{key, time.Now()}
+			return nil
+		},
+	}
+
+	buf := mustNewPartitionedBuffer(t, cfg)
+	go buf.Run(context.Background())
+	t.Cleanup(buf.Close)
+
+	start := time.Now()
+	buf.Add(context.Background(), "a", 1)
+	time.Sleep(50 * time.Millisecond)
+	buf.Add(context.Background(), "b", 2)
+
+	for _, want := range []struct {
+		key   string
+		after time.Duration
+	}{{"a", 80 * time.Millisecond}, {"b", 130 * time.Millisecond}} {
+		select {
+		case f := <-flushed:
+			if f.key != want.key {
+				t.Fatalf("expected %s to be flushed next, got %s", want.key, f.key)
+			}
+			if d := f.at.Sub(start); d < want.after || d > want.after+40*time.Millisecond {
+				t.Errorf("%s flushed after %v, expected about %v", f.key, d, want.after)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected an interval flush of %s", want.key)
+		}
+	}
+}
+
+// TestPartitionedBuffer_Stats verifies that the counters cover every
+// partition.
+func TestPartitionedBuffer_Stats(t *testing.T) {
+	cfg := PartitionConfig[string, int]{
+		Capacity: 2,
+		CanAdd:   func(batch []int, item int) bool { return item >= 0 },
+		Flush:    func(ctx context.Context, key string, batch []int) error { return nil },
+	}
+
+	buf := mustNewPartitionedBuffer(t, cfg)
+	go buf.Run(context.Background())
+
+	ctx := context.Background()
+	buf.Add(ctx, "a", 1)
+	buf.Add(ctx, "a", 2) // fills "a"
+	buf.Add(ctx, "b", 3)
+	buf.Add(ctx, "b", -1) // rejected
+	buf.Close()
+
+	s := buf.Stats()
+	if s.ItemsAdded != 4 || s.ItemsRejected != 1 || s.ItemsFlushed != 3 {
+		t.Errorf("unexpected item counters: %+v", s)
+	}
+	if s.Flushes[TriggerSize] != 1 || s.Flushes[TriggerClose] != 1 {
+		t.Errorf("expected one size and one close flush, got %v", s.Flushes)
+	}
+	if s.BatchLimit != 2 {
+		t.Errorf("expected BatchLimit 2, got %d", s.BatchLimit)
+	}
+}
+
+// TestPartitionedBuffer_CloseWithoutRun verifies that Close flushes the
+// items added before it even if Run was never started, and that a late Run
+// returns at once.
+func TestPartitionedBuffer_CloseWithoutRun(t *testing.T) {
+	var mu sync.Mutex
+	var got []int
+
+	cfg := PartitionConfig[string, int]{
+		Flush: func(ctx context.Context, key string, batch []int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, batch...)
+			return nil
+		},
+	}
+
+	buf := mustNewPartitionedBuffer(t, cfg)
+	buf.Add(context.Background(), "a", 1)
+	buf.Add(context.Background(), "b", 2)
+
+	closed := make(chan struct{})
+	go func() {
+		buf.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked without Run")
+	}
+
+	mu.Lock()
+	if len(got) != 2 {
+		t.Errorf("expected both items to be flushed, got %v", got)
+	}
+	mu.Unlock()
+
+	if err := buf.Run(context.Background()); err != nil {
+		t.Errorf("Run after Close: %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy controls how the buffer re-attempts a Flush that returned an
+// error.
+//
+// The zero value disables retries: Flush is attempted exactly once, which is
+// the historical behaviour of the buffer.
+//
+// Example: up to 5 attempts, 200ms, 400ms, 800ms, 1.6s apart (±20%), only
+// retrying errors the backend reports as transient:
+//
+//	Retry: buffer.RetryPolicy{
+//	    MaxAttempts: 5,
+//	    BaseBackoff: 200 * time.Millisecond,
+//	    MaxBackoff:  5 * time.Second,
+//	    Jitter:      0.2,
+//	    Retryable: func(err error) bool {
+//	        return errors.Is(err, kinesis.ErrThrottled)
+//	    },
+//	},
+//
+// Retries happen synchronously inside the flush cycle, so while a batch is
+// being retried no new batch is formed and Add may start to block once
+// dataChan fills up. Keep MaxAttempts × MaxBackoff well below the time your
+// producers can tolerate being blocked.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of Flush calls made for a batch,
+	// including the first one.
+	//
+	// Default: 1 (no retries).
+	MaxAttempts int
+
+	// BaseBackoff is the wait before the first retry. Each subsequent
+	// retry doubles the previous wait, up to MaxBackoff.
+	//
+	// Default: 100 milliseconds.
+	BaseBackoff time.Duration
+
+	// MaxBackoff caps the wait between two attempts.
+	//
+	// Default: 10 seconds.
+	MaxBackoff time.Duration
+
+	// Jitter randomises each wait by up to this fraction of its value, so
+	// that many buffers failing at the same time don't retry in lockstep.
+	// Must be within [0, 1]; 0.2 means the wait is shortened by 0–20%.
+	//
+	// Default: 0 (no jitter).
+	Jitter float64
+
+	// Retryable reports whether a Flush error is worth retrying. Returning
+	// false sends the batch straight to DeadLetter / OnFlushError. Errors
+	// wrapped with Permanent are never retried, whatever Retryable says.
+	//
+	// Default: every error is retryable.
+	Retryable func(err error) bool
+}
+
+// Permanent wraps err so that the buffer never retries the Flush that
+// returned it, e.g. because part of the batch may already have been written
+// and writing it again is not safe. The batch goes straight to DeadLetter /
+// OnFlushError, which receive the error unchanged apart from the wrapping:
+// errors.Is and errors.As see through it. Permanent(nil) is nil.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// isPermanent reports whether err was wrapped with Permanent.
+func isPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	if p.BaseBackoff <= 0 {
+		p.BaseBackoff = 100 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 10 * time.Second
+	}
+	if p.MaxBackoff < p.BaseBackoff {
+		p.MaxBackoff = p.BaseBackoff
+	}
+	if p.Jitter < 0 {
+		p.Jitter = 0
+	}
+	if p.Jitter > 1 {
+		p.Jitter = 1
+	}
+	if p.Retryable == nil {
+		p.Retryable = func(error) bool { return true }
+	}
+	return p
+}
+
+// backoff returns the wait before the given retry. retry is 1 for the wait
+// between the first and the second attempt.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	wait := p.BaseBackoff
+	for i := 1; i < retry && wait < p.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		wait -= time.Duration(p.Jitter * rand.Float64() * float64(wait))
+	}
+	return wait
+}
+
+// flushWithRetry hands batch to cfg.Flush, retrying according to cfg.Retry.
+// A batch that cannot be flushed is given to DeadLetter (if set) and then
+// reported to OnFlushError. It returns true if either Flush or DeadLetter
+// accepted the batch, along with the error given to OnFlushError (nil if
+// Flush succeeded). cfg must have had its defaults applied; obs may be nil.
+func flushWithRetry[T any](ctx context.Context, cfg *Config[T], drain *drainClock, obs *observer, trigger Trigger, batch []T) (bool, error) {
+	ctx, span := obs.startFlush(ctx, trigger, len(batch))
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err = cfg.Flush(ctx, batch)
+		obs.attempt(ctx, span, attempt, time.Since(start), err)
+		if err == nil {
+			obs.endFlush(span, len(batch), nil)
+			return true, nil
+		}
+		if attempt >= cfg.Retry.MaxAttempts || isPermanent(err) || !cfg.Retry.Retryable(err) {
+			break
+		}
+		if !drain.wait(cfg.Retry.backoff(attempt)) {
+			break
+		}
+	}
+
+	taken := false
+	if cfg.DeadLetter != nil {
+		if dlErr := cfg.DeadLetter(ctx, batch); dlErr != nil {
+			err = errors.Join(err, fmt.Errorf("buffer: dead letter: %w", dlErr))
+		} else {
+			taken = true
+		}
+	}
+	obs.endFlush(span, len(batch), err)
+	cfg.OnFlushError(err, batch)
+	return taken, err
+}
+
+// drainClock tracks the DrainTimeout deadline that starts when a buffer is
+// closed, so that retry backoffs can give up once it has passed.
+type drainClock struct {
+	// closing is closed by start so that a backoff in progress can
+	// re-check itself against deadline.
+	closing chan struct{}
+	// deadline is the UnixNano time after which no more retries are
+	// started. Zero until start is called.
+	deadline atomic.Int64
+}
+
+func newDrainClock() drainClock {
+	return drainClock{closing: make(chan struct{})}
+}
+
+// start sets the deadline to timeout from now. It must be called at most
+// once.
+func (d *drainClock) start(timeout time.Duration) {
+	d.deadline.Store(time.Now().Add(timeout).UnixNano())
+	close(d.closing)
+}
+
+// wait sleeps for dur before the next retry. It returns false, without
+// sleeping the full duration, if the retry would start after the drain
+// deadline.
+func (d *drainClock) wait(dur time.Duration) bool {
+	start := time.Now()
+	if d.past(start.Add(dur)) {
+		return false
+	}
+
+	timer := time.NewTimer(dur)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-d.closing:
+	}
+
+	// The drain started while we were waiting: only carry on if the
+	// retry still fits inside the deadline.
+	if d.past(start.Add(dur)) {
+		return false
+	}
+	<-timer.C
+	return true
+}
+
+func (d *drainClock) past(t time.Time) bool {
+	deadline := d.deadline.Load()
+	return deadline != 0 && t.UnixNano() > deadline
+}
@@ -0,0 +1,290 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errUnavailable = errors.New("backend unavailable")
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{
+		BaseBackoff: 10 * time.Millisecond,
+		MaxBackoff:  50 * time.Millisecond,
+	}.withDefaults()
+
+	want := []time.Duration{
+		10 * time.Millisecond,
+		20 * time.Millisecond,
+		40 * time.Millisecond,
+		50 * time.Millisecond, // capped
+		50 * time.Millisecond,
+	}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := range 100 {
+		got := p.backoff(2)
+		if got < 10*time.Millisecond || got > 20*time.Millisecond {
+			t.Fatalf("iteration %d: jittered backoff %v outside [10ms, 20ms]", i, got)
+		}
+	}
+}
+
+// TestBuffer_RetrySucceeds verifies that a transient error is retried and the
+// batch is not reported as failed once a later attempt succeeds.
+func TestBuffer_RetrySucceeds(t *testing.T) {
+	var attempts atomic.Int64
+	var failures atomic.Int64
+
+	cfg := Config[int]{
+		Capacity: 2,
+		Retry: RetryPolicy{
+			MaxAttempts: 3,
+			BaseBackoff: time.Millisecond,
+		},
+		Flush: func(ctx context.Context, batch []int) error {
+			if attempts.Add(1) < 3 {
+				return errUnavailable
+			}
+			return nil
+		},
+		OnFlushError: func(err error, batch []int) {
+			failures.Add(1)
+		},
+	}
+
+	buf := mustNewBuffer(t, cfg)
+	done := make(chan struct{})
+	go func() {
+		buf.Run(context.Background())
+		close(done)
+	}()
+
+	buf.Add(context.Background(), 1)
+	buf.Add(context.Background(), 2)
+	buf.Shutdown(context.Background())
+	<-done
+
+	if attempts.Load() != 3 {
+		t.Errorf("expected 3 flush attempts, got %d", attempts.Load())
+	}
+	if failures.Load() != 0 {
+		t.Errorf("expected no OnFlushError calls, got %d", failures.Load())
+	}
+}
+
+// TestBuffer_DeadLetter verifies that a batch which exhausts its retries is
+// handed to DeadLetter and then reported to OnFlushError exactly once.
+func TestBuffer_DeadLetter(t *testing.T) {
+	var mu sync.Mutex
+	var attempts int
+	var deadLettered []int
+	var reported []error
+
+	cfg := Config[int]{
+		Capacity: 2,
+		Retry: RetryPolicy{
+			MaxAttempts: 3,
+			BaseBackoff: time.Millisecond,
+		},
+		Flush: func(ctx context.Context, batch []int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts++
+			return errUnavailable
+		},
+		DeadLetter: func(ctx context.Context, batch []int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			deadLettered = append(deadLettered, batch...)
+			return nil
+		},
+		OnFlushError: func(err error, batch []int) {
+			mu.Lock()
+			defer mu.Unlock()
+			reported = append(reported, err)
+		},
+	}
+
+	buf := mustNewBuffer(t, cfg)
+	done := make(chan struct{})
+	go func() {
+		buf.Run(context.Background())
+		close(done)
+	}()
+
+	buf.Add(context.Background(), 1)
+	buf.Add(context.Background(), 2)
+	buf.Shutdown(context.Background())
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 3 {
+		t.Errorf("expected 3 flush attempts, got %d", attempts)
+	}
+	if len(deadLettered) != 2 {
+		t.Errorf("expected dead letter to receive 2 items, got %v", deadLettered)
+	}
+	if len(reported) != 1 || !errors.Is(reported[0], errUnavailable) {
+		t.Errorf("expected a single OnFlushError with the flush error, got %v", reported)
+	}
+}
+
+// TestBuffer_DeadLetterError verifies that a failing DeadLetter is surfaced
+// alongside the original flush error.
+func TestBuffer_DeadLetterError(t *testing.T) {
+	errParked := errors.New("dead letter topic unavailable")
+	reported := make(chan error, 1)
+
+	cfg := Config[int]{
+		Capacity: 1,
+		Flush: func(ctx context.Context, batch []int) error {
+			return errUnavailable
+		},
+		DeadLetter: func(ctx context.Context, batch []int) error {
+			return errParked
+		},
+		OnFlushError: func(err error, batch []int) {
+			reported <- err
+		},
+	}
+
+	buf := mustNewBuffer(t, cfg)
+	go buf.Run(context.Background())
+	buf.Add(context.Background(), 1)
+
+	select {
+	case err := <-reported:
+		if !errors.Is(err, errUnavailable) || !errors.Is(err, errParked) {
+			t.Errorf("expected both errors to be reported, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnFlushError was not called")
+	}
+}
+
+// TestBuffer_NonRetryable verifies that the Retryable classifier stops
+// retries for permanent errors.
+func TestBuffer_NonRetryable(t *testing.T) {
+	errInvalid := errors.New("invalid payload")
+	var attempts atomic.Int64
+
+	cfg := Config[int]{
+		Capacity: 1,
+		Retry: RetryPolicy{
+			MaxAttempts: 5,
+			BaseBackoff: time.Millisecond,
+			Retryable: func(err error) bool {
+				return !errors.Is(err, errInvalid)
+			},
+		},
+		Flush: func(ctx context.Context, batch []int) error {
+			attempts.Add(1)
+			return errInvalid
+		},
+	}
+
+	buf := mustNewBuffer(t, cfg)
+	done := make(chan struct{})
+	go func() {
+		buf.Run(context.Background())
+		close(done)
+	}()
+
+	buf.Add(context.Background(), 1)
+	buf.Shutdown(context.Background())
+	<-done
+
+	if attempts.Load() != 1 {
+		t.Errorf("expected a single attempt for a non-retryable error, got %d", attempts.Load())
+	}
+}
+
+// TestBuffer_Permanent verifies that errors wrapped with Permanent are not
+// retried even though Retryable accepts them, and reach OnFlushError
+// unchanged.
+func TestBuffer_Permanent(t *testing.T) {
+	var attempts atomic.Int64
+	var reported error
+
+	cfg := Config[int]{
+		Capacity: 1,
+		Retry:    RetryPolicy{MaxAttempts: 5, BaseBackoff: time.Millisecond},
+		Flush: func(ctx context.Context, batch []int) error {
+			attempts.Add(1)
+			return Permanent(errUnavailable)
+		},
+		OnFlushError: func(err error, batch []int) {
+			reported = err
+		},
+	}
+
+	buf := mustNewBuffer(t, cfg)
+	go buf.Run(context.Background())
+	buf.Add(context.Background(), 1)
+	buf.Shutdown(context.Background())
+
+	if attempts.Load() != 1 {
+		t.Errorf("expected a single attempt for a permanent error, got %d", attempts.Load())
+	}
+	if !errors.Is(reported, errUnavailable) {
+		t.Errorf("expected OnFlushError to see the flush error, got %v", reported)
+	}
+	if Permanent(nil) != nil {
+		t.Error("expected Permanent(nil) to be nil")
+	}
+}
+
+// TestBuffer_RetryHonoursDrainTimeout verifies that the final drain gives up
+// retrying once DrainTimeout has passed instead of sleeping through the whole
+// backoff schedule.
+func TestBuffer_RetryHonoursDrainTimeout(t *testing.T) {
+	var deadLettered atomic.Bool
+
+	cfg := Config[int]{
+		Capacity:     10,
+		DrainTimeout: 50 * time.Millisecond,
+		Retry: RetryPolicy{
+			MaxAttempts: 10,
+			BaseBackoff: 20 * time.Millisecond,
+			MaxBackoff:  time.Second,
+		},
+		Flush: func(ctx context.Context, batch []int) error {
+			return errUnavailable
+		},
+		DeadLetter: func(ctx context.Context, batch []int) error {
+			deadLettered.Store(true)
+			return nil
+		},
+	}
+
+	buf := mustNewBuffer(t, cfg)
+	done := make(chan struct{})
+	go func() {
+		buf.Run(context.Background())
+		close(done)
+	}()
+
+	buf.Add(context.Background(), 1)
+
+	start := time.Now()
+	buf.Shutdown(context.Background())
+	<-done
+
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("expected drain to give up within the drain timeout, took %v", elapsed)
+	}
+	if !deadLettered.Load() {
+		t.Error("expected the abandoned batch to be dead-lettered")
+	}
+}
@@ -0,0 +1,101 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrClosed is returned by FlushNow once Run has returned.
+var ErrClosed = errors.New("buffer: closed")
+
+// FlushNow flushes the current batch right away, without waiting for
+// ShouldFlush or FlushInterval, and returns once the batch has been
+// delivered. Items that were added before FlushNow was called are part of
+// the flush.
+//
+// It returns the error that OnFlushError received for the batch, i.e. the
+// last Flush error after retries, joined with the DeadLetter error if that
+// failed too. It returns nil if the batch was flushed or was empty.
+//
+// A typical use is flushing on a signal, or before a health check reports
+// that the process is ready to be terminated:
+//
+//	sig := make(chan os.Signal, 1)
+//	signal.Notify(sig, syscall.SIGUSR1)
+//	go func() {
+//	    for range sig {
+//	        if err := buf.FlushNow(ctx); err != nil {
+//	            log.Printf("manual flush failed: %v", err)
+//	        }
+//	    }
+//	}()
+//
+// The flush runs inside Run, even with FlushConcurrency above 1, so no
+// items are taken off dataChan until it is done. FlushNow waits for Run to
+// pick up the request, so it blocks until ctx is done if Run has not been
+// started. If ctx is done after Run picked up the request, FlushNow returns
+// ctx.Err() but the flush still completes. Once Run has returned, FlushNow
+// returns ErrClosed.
+func (b *Buffer[T]) FlushNow(ctx context.Context) error {
+	reply := make(chan error, 1)
+
+	select {
+	case b.flushReq <- reply:
+	case <-b.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown is Close with a deadline: it signals Run that no more items will
+// be produced and waits for Run to drain and flush everything, but gives up
+// when ctx is done.
+//
+// If Run finishes in time, Shutdown returns nil. Otherwise it returns an
+// error wrapping ctx.Err() that reports how many accepted items had not been
+// through a flush yet — still in the channel, in the current batch or in a
+// flush that was under way:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+//	defer cancel()
+//	if err := buf.Shutdown(ctx); err != nil {
+//	    log.Printf("buffer did not drain: %v", err)
+//	}
+//
+// Run keeps going in the background after Shutdown has given up. To stop
+// failed flushes from being retried past the deadline, the DrainTimeout
+// clock is started with the sooner of DrainTimeout and the ctx deadline.
+//
+// The caller responsibilities described on Close apply: Shutdown must only
+// be called once all producers have finished calling Add, and only once.
+func (b *Buffer[T]) Shutdown(ctx context.Context) error {
+	timeout := b.cfg.DrainTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = min(timeout, time.Until(deadline))
+	}
+	b.close(timeout)
+
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	select {
+	case <-b.done:
+		// Run finished at the same time.
+		return nil
+	default:
+	}
+	return fmt.Errorf("buffer: shutdown: %d items left unflushed: %w", b.pending.Load(), ctx.Err())
+}
@@ -0,0 +1,115 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestBuffer_FlushNow verifies that FlushNow flushes a partial batch,
+// including items still waiting in the channel, and returns its error.
+func TestBuffer_FlushNow(t *testing.T) {
+	var mu sync.Mutex
+	var received []int
+	fail := false
+	buf := mustNewBuffer(t, Config[int]{
+		Capacity:      100,
+		FlushInterval: time.Hour,
+		Flush: func(ctx context.Context, batch []int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if fail {
+				return errors.New("backend down")
+			}
+			received = append(received, batch...)
+			return nil
+		},
+	})
+	go buf.Run(context.Background())
+
+	buf.Add(context.Background(), 1)
+	buf.Add(context.Background(), 2)
+	if err := buf.FlushNow(context.Background()); err != nil {
+		t.Fatalf("expected FlushNow to succeed, got %v", err)
+	}
+
+	mu.Lock()
+	if len(received) != 2 {
+		t.Errorf("expected both items to be flushed by FlushNow, got %v", received)
+	}
+	fail = true
+	mu.Unlock()
+
+	buf.Add(context.Background(), 3)
+	if err := buf.FlushNow(context.Background()); err == nil {
+		t.Error("expected FlushNow to return the flush error")
+	}
+	if err := buf.FlushNow(context.Background()); err != nil {
+		t.Errorf("expected FlushNow on an empty batch to return nil, got %v", err)
+	}
+
+	buf.Shutdown(context.Background())
+	if err := buf.FlushNow(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed after Shutdown, got %v", err)
+	}
+	if s := buf.Stats(); s.Flushes[TriggerManual] != 2 {
+		t.Errorf("expected 2 manual flushes, got %d", s.Flushes[TriggerManual])
+	}
+}
+
+// TestBuffer_ShutdownDeadline verifies that Shutdown gives up when its
+// context is done and reports the items that were not flushed.
+func TestBuffer_ShutdownDeadline(t *testing.T) {
+	release := make(chan struct{})
+	buf := mustNewBuffer(t, Config[int]{
+		Capacity: 2,
+		Flush: func(ctx context.Context, batch []int) error {
+			<-release
+			return nil
+		},
+	})
+	go buf.Run(context.Background())
+	for i := range 5 {
+		buf.Add(context.Background(), i)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := buf.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "5 items left unflushed") {
+		t.Errorf("expected the error to report 5 unflushed items, got %q", err)
+	}
+
+	close(release)
+	<-buf.done
+	if n := buf.pending.Load(); n != 0 {
+		t.Errorf("expected nothing pending once Run returned, got %d", n)
+	}
+}
+
+func TestBuffer_Shutdown(t *testing.T) {
+	var received []int
+	buf := mustNewBuffer(t, Config[int]{
+		Flush: func(ctx context.Context, batch []int) error {
+			received = append(received, batch...)
+			return nil
+		},
+	})
+	go buf.Run(context.Background())
+	buf.Add(context.Background(), 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := buf.Shutdown(ctx); err != nil {
+		t.Fatalf("expected Shutdown to succeed, got %v", err)
+	}
+	if len(received) != 1 {
+		t.Errorf("expected the item to be flushed before Shutdown returned, got %v", received)
+	}
+}
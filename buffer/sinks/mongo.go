@@ -0,0 +1,56 @@
+package sinks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/CloudStuffTech/go-utils/buffer"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoInsertMany returns a FlushFunc that writes each batch to coll with a
+// single unordered InsertMany, so one bad document does not stop the rest
+// of the batch from being inserted.
+//
+// If some documents fail (e.g. a validation error), it returns a
+// *PartialError with only those documents. If the whole batch fails, or the
+// write concern could not be satisfied, in which case it is unknown what
+// was written, the driver error is returned as is.
+//
+// Documents without an _id get a new one on every call, so retrying a
+// batch after a timeout may insert duplicates; set the _id before Add if
+// that matters.
+func MongoInsertMany[T any](coll *mongo.Collection, opts Options) buffer.FlushFunc[T] {
+	opts = opts.withDefaults()
+	insertOpts := options.InsertMany().SetOrdered(false)
+
+	return func(ctx context.Context, batch []T) error {
+		ctx, cancel := opts.flushContext(ctx)
+		defer cancel()
+
+		docs := make([]interface{}, len(batch))
+		for i := range batch {
+			docs[i] = batch[i]
+		}
+
+		_, err := coll.InsertMany(ctx, docs, insertOpts)
+		if err == nil {
+			return nil
+		}
+
+		var bwe mongo.BulkWriteException
+		if !errors.As(err, &bwe) || bwe.WriteConcernError != nil || len(bwe.WriteErrors) == 0 {
+			return fmt.Errorf("sinks: mongo insert: %w", err)
+		}
+
+		errs := make([]error, len(batch))
+		for _, we := range bwe.WriteErrors {
+			if we.Index >= 0 && we.Index < len(errs) {
+				errs[we.Index] = fmt.Errorf("sinks: mongo insert: %w", we)
+			}
+		}
+		return collectFailed(batch, errs)
+	}
+}
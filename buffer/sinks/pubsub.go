@@ -0,0 +1,54 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CloudStuffTech/go-utils/buffer"
+	"github.com/CloudStuffTech/go-utils/messaging"
+)
+
+// PubSub returns a FlushFunc that publishes every item of a batch as one
+// message through m, encoded with encode, and waits for the service to
+// acknowledge them.
+//
+// Items that could not be encoded or published are returned in a
+// *PartialError; if none of them made it, the first error is returned as
+// is.
+//
+//	Flush: sinks.PubSub(topic, func(e Event) ([]byte, error) {
+//	    return json.Marshal(e)
+//	}, sinks.Options{Timeout: 10 * time.Second}),
+func PubSub[T any](m *messaging.Message, encode func(item T) ([]byte, error), opts Options) buffer.FlushFunc[T] {
+	opts = opts.withDefaults()
+
+	return func(ctx context.Context, batch []T) error {
+		errs := make([]error, len(batch))
+
+		// Only publish what could be encoded; index maps each message
+		// back to its item.
+		msgs := make([][]byte, 0, len(batch))
+		index := make([]int, 0, len(batch))
+		for i, item := range batch {
+			data, err := encode(item)
+			if err != nil {
+				errs[i] = fmt.Errorf("sinks: pubsub encode: %w", err)
+				continue
+			}
+			msgs = append(msgs, data)
+			index = append(index, i)
+		}
+
+		if len(msgs) > 0 {
+			ctx, cancel := opts.flushContext(ctx)
+			defer cancel()
+
+			for j, err := range m.SendBatch(ctx, msgs) {
+				if err != nil {
+					errs[index[j]] = fmt.Errorf("sinks: pubsub publish: %w", err)
+				}
+			}
+		}
+		return collectFailed(batch, errs)
+	}
+}
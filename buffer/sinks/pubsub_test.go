@@ -0,0 +1,97 @@
+package sinks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"github.com/CloudStuffTech/go-utils/messaging"
+	"google.golang.org/grpc"
+)
+
+// fakePublisher is a Pub/Sub publisher service that accepts every message
+// and keeps its data.
+type fakePublisher struct {
+	pubsubpb.UnimplementedPublisherServer
+
+	mu   sync.Mutex
+	data []string
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, req *pubsubpb.PublishRequest) (*pubsubpb.PublishResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids := make([]string, len(req.Messages))
+	for i, m := range req.Messages {
+		p.data = append(p.data, string(m.Data))
+		ids[i] = strconv.Itoa(len(p.data))
+	}
+	return &pubsubpb.PublishResponse{MessageIds: ids}, nil
+}
+
+func (p *fakePublisher) published() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.data...)
+}
+
+// newTopic returns a messaging.Message publishing to the emulator at addr.
+func newTopic(t *testing.T, addr string) *messaging.Message {
+	t.Helper()
+	t.Setenv("PUBSUB_EMULATOR_HOST", addr)
+	m, err := messaging.NewPubSub("project", "topic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Stop)
+	return m
+}
+
+func startPublisher(t *testing.T) (*fakePublisher, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakePublisher{}
+	srv := grpc.NewServer()
+	pubsubpb.RegisterPublisherServer(srv, p)
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+	return p, l.Addr().String()
+}
+
+var errOdd = errors.New("odd")
+
+// encodeEven fails to encode odd numbers.
+func encodeEven(n int) ([]byte, error) {
+	if n%2 == 1 {
+		return nil, errOdd
+	}
+	return []byte(strconv.Itoa(n)), nil
+}
+
+// TestPubSub_EncodeErrors verifies that items that cannot be encoded are
+// reported on their own while the others are published.
+func TestPubSub_EncodeErrors(t *testing.T) {
+	p, addr := startPublisher(t)
+	flush := PubSub(newTopic(t, addr), encodeEven, Options{Timeout: 5 * time.Second})
+
+	err := flush(context.Background(), []int{1, 2, 3, 4})
+
+	failed, ok := FailedItems[int](err)
+	if !ok || len(failed) != 2 || failed[0] != 1 || failed[1] != 3 {
+		t.Fatalf("expected [1 3] to fail, got %v (%v)", failed, err)
+	}
+	if !errors.Is(err, errOdd) {
+		t.Errorf("expected the encode error to be wrapped, got %v", err)
+	}
+	if got := p.published(); len(got) != 2 || got[0] != "2" || got[1] != "4" {
+		t.Errorf("expected 2 and 4 to be published, got %v", got)
+	}
+}
@@ -0,0 +1,66 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CloudStuffTech/go-utils/buffer"
+	"github.com/CloudStuffTech/go-utils/redis"
+)
+
+// HashIncr is one hash field increment, as produced by the mapping function
+// given to RedisHIncrBy.
+type HashIncr struct {
+	Key   string
+	Field string
+	By    int64
+}
+
+// RedisHIncrBy returns a FlushFunc that applies the HINCRBY of every item of
+// a batch in a single pipeline on c.
+//
+// It pairs well with buffer.Config.KeyFunc and Merge, which fold increments
+// of the same field into one before the flush:
+//
+//	buf, err := buffer.NewBuffer(buffer.Config[sinks.HashIncr]{
+//	    KeyFunc: func(i sinks.HashIncr) string { return i.Key + "\x00" + i.Field },
+//	    Merge: func(existing, incoming sinks.HashIncr) sinks.HashIncr {
+//	        existing.By += incoming.By
+//	        return existing
+//	    },
+//	    Flush: sinks.RedisHIncrBy(client, func(i sinks.HashIncr) sinks.HashIncr { return i }, sinks.Options{}),
+//	})
+//
+// Increments are not idempotent, so every error is wrapped with
+// buffer.Permanent: the buffer does not retry the batch and hands it to
+// DeadLetter / OnFlushError instead. When only some increments failed,
+// they are returned in a *PartialError, see FailedItems. When the pipeline
+// fails as a whole, e.g. on a connection error, every increment gets the
+// same error and it is unknown which of them were applied.
+func RedisHIncrBy[T any](c *redis.Client, incr func(item T) HashIncr, opts Options) buffer.FlushFunc[T] {
+	opts = opts.withDefaults()
+
+	return func(ctx context.Context, batch []T) error {
+		ctx, cancel := opts.flushContext(ctx)
+		defer cancel()
+
+		pipe := c.GetUniversalConn().Pipeline()
+		for _, item := range batch {
+			i := incr(item)
+			pipe.HIncrBy(ctx, i.Key, i.Field, i.By)
+		}
+
+		cmds, err := pipe.Exec(ctx)
+		if len(cmds) != len(batch) {
+			return buffer.Permanent(fmt.Errorf("sinks: redis pipeline: %w", err))
+		}
+
+		errs := make([]error, len(batch))
+		for i, cmd := range cmds {
+			if cmdErr := cmd.Err(); cmdErr != nil {
+				errs[i] = fmt.Errorf("sinks: redis hincrby: %w", cmdErr)
+			}
+		}
+		return buffer.Permanent(collectFailed(batch, errs))
+	}
+}
@@ -0,0 +1,206 @@
+package sinks
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CloudStuffTech/go-utils/buffer"
+	"github.com/CloudStuffTech/go-utils/redis"
+)
+
+// fakeRedis is a minimal RESP server that understands HINCRBY. Hashes
+// under the key "wrongtype" reply with a WRONGTYPE error, and once down is
+// set every connection is closed as soon as a command arrives.
+type fakeRedis struct {
+	addr     net.Addr
+	down     atomic.Bool
+	commands atomic.Int64
+
+	mu     sync.Mutex
+	fields map[string]int64
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	f := &fakeRedis{addr: l.Addr(), fields: make(map[string]int64)}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+		args := make([]string, n)
+		for i := range args {
+			if _, err := r.ReadString('\n'); err != nil { // $len
+				return
+			}
+			arg, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			args[i] = strings.TrimSuffix(arg, "\r\n")
+		}
+		if n == 0 {
+			continue
+		}
+		if strings.ToUpper(args[0]) == "HINCRBY" {
+			f.commands.Add(1)
+			if f.down.Load() {
+				return
+			}
+		}
+		c.Write([]byte(f.reply(args)))
+	}
+}
+
+func (f *fakeRedis) reply(args []string) string {
+	if strings.ToUpper(args[0]) != "HINCRBY" || len(args) != 4 {
+		return "-ERR unknown command '" + args[0] + "'\r\n"
+	}
+	if args[1] == "wrongtype" {
+		return "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+	}
+	by, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return "-ERR value is not an integer or out of range\r\n"
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fields[args[1]+"/"+args[2]] += by
+	return fmt.Sprintf(":%d\r\n", f.fields[args[1]+"/"+args[2]])
+}
+
+func (f *fakeRedis) field(key, field string) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fields[key+"/"+field]
+}
+
+func (f *fakeRedis) client() *redis.Client {
+	host, port, _ := net.SplitHostPort(f.addr.String())
+	return redis.NewClient(&redis.ClientOptions{Host: host, Port: port, MaxRetries: -1})
+}
+
+func identity(i HashIncr) HashIncr { return i }
+
+func TestRedisHIncrBy(t *testing.T) {
+	f := newFakeRedis(t)
+	flush := RedisHIncrBy(f.client(), identity, Options{Timeout: time.Second})
+
+	err := flush(context.Background(), []HashIncr{
+		{Key: "stats", Field: "clicks", By: 2},
+		{Key: "stats", Field: "clicks", By: 3},
+		{Key: "stats", Field: "convs", By: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := f.field("stats", "clicks"); n != 5 {
+		t.Errorf("expected clicks to be 5, got %d", n)
+	}
+	if n := f.field("stats", "convs"); n != 1 {
+		t.Errorf("expected convs to be 1, got %d", n)
+	}
+}
+
+// TestRedisHIncrBy_Partial verifies that only the failed increments are
+// reported, and that the error is not retryable.
+func TestRedisHIncrBy_Partial(t *testing.T) {
+	f := newFakeRedis(t)
+	flush := RedisHIncrBy(f.client(), identity, Options{Timeout: time.Second})
+
+	bad := HashIncr{Key: "wrongtype", Field: "clicks", By: 1}
+	err := flush(context.Background(), []HashIncr{{Key: "stats", Field: "clicks", By: 1}, bad})
+
+	failed, ok := FailedItems[HashIncr](err)
+	if !ok || len(failed) != 1 || failed[0] != bad {
+		t.Fatalf("expected only %v to fail, got %v (%v)", bad, failed, err)
+	}
+	if Retryable(err) {
+		t.Error("expected a partial failure not to be retryable")
+	}
+	if n := f.field("stats", "clicks"); n != 1 {
+		t.Errorf("expected the other increment to be applied once, got %d", n)
+	}
+}
+
+// TestRedisHIncrBy_ConnectionFailure verifies that a batch whose pipeline
+// failed as a whole is dead-lettered instead of retried, since some of its
+// increments may have been applied.
+func TestRedisHIncrBy_ConnectionFailure(t *testing.T) {
+	f := newFakeRedis(t)
+	f.down.Store(true)
+
+	var deadLettered []HashIncr
+	buf, err := buffer.NewBuffer(buffer.Config[HashIncr]{
+		Capacity: 10,
+		Flush:    RedisHIncrBy(f.client(), identity, Options{Timeout: time.Second}),
+		Retry:    buffer.RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond},
+		DeadLetter: func(ctx context.Context, batch []HashIncr) error {
+			deadLettered = append(deadLettered, batch...)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go buf.Run(context.Background())
+
+	buf.Add(context.Background(), HashIncr{Key: "stats", Field: "clicks", By: 1})
+	buf.Add(context.Background(), HashIncr{Key: "stats", Field: "convs", By: 1})
+	buf.Shutdown(context.Background())
+
+	if n := f.commands.Load(); n != 1 {
+		t.Errorf("expected a single attempt to reach redis, got %d commands", n)
+	}
+	if len(deadLettered) != 2 {
+		t.Errorf("expected the whole batch to be dead-lettered, got %v", deadLettered)
+	}
+}
+
+func TestRedisHIncrBy_AllFailed(t *testing.T) {
+	f := newFakeRedis(t)
+	flush := RedisHIncrBy(f.client(), identity, Options{Timeout: time.Second})
+
+	err := flush(context.Background(), []HashIncr{{Key: "wrongtype", Field: "a", By: 1}})
+	if err == nil || !strings.Contains(err.Error(), "WRONGTYPE") {
+		t.Fatalf("expected the WRONGTYPE error, got %v", err)
+	}
+	if _, ok := FailedItems[HashIncr](err); ok {
+		t.Error("expected a complete failure not to be a PartialError")
+	}
+	var redisErr interface{ RedisError() }
+	if !errors.As(err, &redisErr) {
+		t.Errorf("expected the redis error to be wrapped, got %T", err)
+	}
+}
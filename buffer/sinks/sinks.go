@@ -0,0 +1,117 @@
+// Package sinks provides ready-made buffer.FlushFunc constructors for the
+// backends most services flush to: Mongo, Pub/Sub and Redis.
+//
+// Every sink shields the context it is given from cancellation and bounds
+// the backend call with Options.Timeout, as recommended by
+// buffer.FlushFunc, so the final drain on shutdown still reaches the
+// backend:
+//
+//	buf, err := buffer.NewBuffer(buffer.Config[Click]{
+//	    Capacity: 1000,
+//	    Flush:    sinks.MongoInsertMany[Click](db.Collection("clicks"), sinks.Options{}),
+//	    OnFlushError: func(err error, batch []Click) {
+//	        if failed, ok := sinks.FailedItems[Click](err); ok {
+//	            batch = failed // only part of the batch was lost
+//	        }
+//	        log.Printf("lost %d clicks: %v", len(batch), err)
+//	    },
+//	})
+//
+// When a backend accepts only part of a batch, the sink returns a
+// *PartialError holding just the items that were not written. Retrying the
+// whole batch would write the other items a second time, so use Retryable
+// as the buffer's RetryPolicy.Retryable to only retry complete failures.
+// Sinks whose writes are not idempotent, like RedisHIncrBy, wrap all their
+// errors with buffer.Permanent so that they are never retried.
+package sinks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Options holds the settings shared by all sinks.
+type Options struct {
+	// Timeout bounds each flush, i.e. one backend call (or pipeline) per
+	// batch. It applies to a context detached from the caller's, so it is
+	// the only limit on how long a flush may take.
+	//
+	// Default: 5 seconds.
+	Timeout time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Second
+	}
+	return o
+}
+
+// flushContext detaches ctx from its parent's cancellation and applies the
+// sink timeout.
+func (o Options) flushContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), o.Timeout)
+}
+
+// PartialError is returned by a sink when only some items of a batch could
+// not be written. Failed holds those items, in batch order; it is a copy,
+// so it may be retained after the flush. Err is the error reported for the
+// first of them.
+type PartialError[T any] struct {
+	Failed []T
+	Err    error
+}
+
+func (e *PartialError[T]) Error() string {
+	return fmt.Sprintf("sinks: %d items failed: %v", len(e.Failed), e.Err)
+}
+
+func (e *PartialError[T]) Unwrap() error {
+	return e.Err
+}
+
+func (e *PartialError[T]) partial() {}
+
+// FailedItems returns the items a sink could not write if err is, or wraps,
+// a *PartialError[T]. It returns false for any other error, in which case
+// the whole batch failed.
+func FailedItems[T any](err error) ([]T, bool) {
+	var p *PartialError[T]
+	if errors.As(err, &p) {
+		return p.Failed, true
+	}
+	return nil, false
+}
+
+// Retryable is meant for buffer.RetryPolicy.Retryable: it reports false for
+// partial failures, which must not be retried as a whole, and true for
+// every other error.
+func Retryable(err error) bool {
+	var p interface{ partial() }
+	return !errors.As(err, &p)
+}
+
+// collectFailed returns a PartialError for the items of batch whose entry
+// in errs is not nil, or nil if there are none. If every item failed, the
+// first error is returned as is, since the batch can be retried safely.
+func collectFailed[T any](batch []T, errs []error) error {
+	var failed []T
+	var first error
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, batch[i])
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	switch len(failed) {
+	case 0:
+		return nil
+	case len(batch):
+		return first
+	}
+	return &PartialError[T]{Failed: failed, Err: first}
+}
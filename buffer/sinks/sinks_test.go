@@ -0,0 +1,36 @@
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCollectFailed(t *testing.T) {
+	boom := errors.New("boom")
+	batch := []string{"a", "b", "c"}
+
+	if err := collectFailed(batch, make([]error, 3)); err != nil {
+		t.Errorf("expected nil when nothing failed, got %v", err)
+	}
+
+	err := collectFailed(batch, []error{nil, boom, nil})
+	failed, ok := FailedItems[string](fmt.Errorf("flush: %w", err))
+	if !ok || len(failed) != 1 || failed[0] != "b" {
+		t.Errorf("expected [b] to be reported as failed, got %v (ok=%v)", failed, ok)
+	}
+	if !errors.Is(err, boom) {
+		t.Errorf("expected the partial error to wrap the item error, got %v", err)
+	}
+	if Retryable(err) {
+		t.Error("expected a partial failure not to be retryable")
+	}
+
+	err = collectFailed(batch, []error{boom, boom, boom})
+	if _, ok := FailedItems[string](err); ok {
+		t.Error("expected a complete failure not to be a PartialError")
+	}
+	if !Retryable(err) {
+		t.Error("expected a complete failure to be retryable")
+	}
+}
@@ -0,0 +1,355 @@
+package buffer
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/CloudStuffTech/go-utils/internal/histogram"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/CloudStuffTech/go-utils/buffer"
+
+// Trigger is the reason a batch was flushed.
+type Trigger int
+
+const (
+	// TriggerSize means ShouldFlush returned true after an append.
+	TriggerSize Trigger = iota
+	// TriggerOverflow means WillOverflow returned true for the next item.
+	TriggerOverflow
+	// TriggerInterval means FlushInterval elapsed.
+	TriggerInterval
+	// TriggerClose means the buffer was closed and drained.
+	TriggerClose
+	// TriggerManual means the flush was requested through FlushNow.
+	TriggerManual
+
+	numTriggers
+)
+
+func (t Trigger) String() string {
+	switch t {
+	case TriggerSize:
+		return "size"
+	case TriggerOverflow:
+		return "overflow"
+	case TriggerInterval:
+		return "interval"
+	case TriggerClose:
+		return "close"
+	case TriggerManual:
+		return "manual"
+	}
+	return "unknown"
+}
+
+// Stats is a point-in-time snapshot of a Buffer's counters, as returned by
+// Buffer.Stats. All counters are cumulative since NewBuffer.
+type Stats struct {
+	// ItemsAdded is the number of items Run has taken in, including items
+	// replayed from the WAL.
+	ItemsAdded uint64
+	// ItemsRejected is the number of items CanAdd refused.
+	ItemsRejected uint64
+	// ItemsDropped is the number of items discarded by the overflow
+	// policy because the buffer was full.
+	ItemsDropped uint64
+	// ItemsSpilled is the number of items handed to Spill.
+	ItemsSpilled uint64
+	// ItemsMerged is the number of items coalesced into an item with the
+	// same key by Config.Merge.
+	ItemsMerged uint64
+	// ItemsFlushed is the number of items in batches Flush accepted.
+	ItemsFlushed uint64
+	// ItemsInChannel is the number of items waiting in dataChan.
+	ItemsInChannel int
+
+	// BatchLimit and FlushInterval are the batch size and flush interval
+	// currently in use: Capacity and Config.FlushInterval, or the values
+	// picked by Config.Adaptive.
+	BatchLimit    int
+	FlushInterval time.Duration
+
+	// Flushes is the number of batches flushed, by trigger.
+	Flushes map[Trigger]uint64
+	// FlushRetries is the number of Flush calls that were retries of a
+	// previously failed attempt.
+	FlushRetries uint64
+	// FlushErrors is the number of batches reported to OnFlushError.
+	FlushErrors uint64
+
+	// FlushLatency is the distribution of individual Flush call durations,
+	// in milliseconds.
+	FlushLatency Histogram
+	// BatchSize is the distribution of the number of items per flushed
+	// batch.
+	BatchSize Histogram
+}
+
+// Histogram is a snapshot of a fixed-bucket histogram. Counts[i] is the
+// number of observations v with Bounds[i-1] < v <= Bounds[i]; the last
+// entry of Counts counts observations above the last bound.
+type Histogram = histogram.Histogram
+
+var (
+	latencyBounds   = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+	batchSizeBounds = []float64{1, 10, 50, 100, 250, 500, 1000, 5000}
+)
+
+// observer records the counters behind Stats and, when configured, exports
+// them through OpenTelemetry. A nil *observer records nothing, so code
+// paths shared with PartitionedBuffer need not check for it.
+type observer struct {
+	itemsAdded    atomic.Uint64
+	itemsRejected atomic.Uint64
+	itemsDropped  atomic.Uint64
+	itemsSpilled  atomic.Uint64
+	itemsMerged   atomic.Uint64
+	itemsFlushed  atomic.Uint64
+	flushes       [numTriggers]atomic.Uint64
+	flushRetries  atomic.Uint64
+	flushErrors   atomic.Uint64
+	latency       *histogram.Recorder
+	batchSize     *histogram.Recorder
+
+	tracer trace.Tracer
+
+	// The OpenTelemetry instruments are nil unless Config.MeterProvider
+	// is set.
+	attrs         metric.MeasurementOption
+	otelLatency   metric.Float64Histogram
+	otelBatchSize metric.Int64Histogram
+	registration  metric.Registration
+}
+
+// newObserver builds the observer for a buffer. inChannel reports the
+// current length of dataChan for the exported gauge.
+func newObserver(name string, tp trace.TracerProvider, mp metric.MeterProvider, inChannel func() int) (*observer, error) {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	o := &observer{
+		latency:   histogram.New(latencyBounds),
+		batchSize: histogram.New(batchSizeBounds),
+		tracer:    tp.Tracer(instrumentationName),
+	}
+	if mp == nil {
+		return o, nil
+	}
+
+	meter := mp.Meter(instrumentationName)
+	bufferAttr := attribute.String("buffer.name", name)
+	o.attrs = metric.WithAttributes(bufferAttr)
+
+	var err error
+	if o.otelLatency, err = meter.Float64Histogram("buffer.flush.duration",
+		metric.WithDescription("Duration of individual Flush calls."),
+		metric.WithUnit("ms"),
+		metric.WithExplicitBucketBoundaries(latencyBounds...),
+	); err != nil {
+		return nil, err
+	}
+	if o.otelBatchSize, err = meter.Int64Histogram("buffer.batch.size",
+		metric.WithDescription("Number of items per flushed batch."),
+		metric.WithUnit("{item}"),
+		metric.WithExplicitBucketBoundaries(batchSizeBounds...),
+	); err != nil {
+		return nil, err
+	}
+
+	added, err := meter.Int64ObservableCounter("buffer.items.added",
+		metric.WithDescription("Items taken in by the buffer."), metric.WithUnit("{item}"))
+	if err != nil {
+		return nil, err
+	}
+	rejected, err := meter.Int64ObservableCounter("buffer.items.rejected",
+		metric.WithDescription("Items refused by CanAdd."), metric.WithUnit("{item}"))
+	if err != nil {
+		return nil, err
+	}
+	dropped, err := meter.Int64ObservableCounter("buffer.items.dropped",
+		metric.WithDescription("Items discarded because the buffer was full."), metric.WithUnit("{item}"))
+	if err != nil {
+		return nil, err
+	}
+	spilled, err := meter.Int64ObservableCounter("buffer.items.spilled",
+		metric.WithDescription("Items handed to Spill because the buffer was full."), metric.WithUnit("{item}"))
+	if err != nil {
+		return nil, err
+	}
+	merged, err := meter.Int64ObservableCounter("buffer.items.merged",
+		metric.WithDescription("Items coalesced into an item with the same key."), metric.WithUnit("{item}"))
+	if err != nil {
+		return nil, err
+	}
+	flushed, err := meter.Int64ObservableCounter("buffer.items.flushed",
+		metric.WithDescription("Items in batches accepted by Flush."), metric.WithUnit("{item}"))
+	if err != nil {
+		return nil, err
+	}
+	flushes, err := meter.Int64ObservableCounter("buffer.flushes",
+		metric.WithDescription("Batches flushed, by trigger."), metric.WithUnit("{batch}"))
+	if err != nil {
+		return nil, err
+	}
+	retries, err := meter.Int64ObservableCounter("buffer.flush.retries",
+		metric.WithDescription("Flush calls retrying a failed attempt."), metric.WithUnit("{call}"))
+	if err != nil {
+		return nil, err
+	}
+	flushErrors, err := meter.Int64ObservableCounter("buffer.flush.errors",
+		metric.WithDescription("Batches that could not be flushed."), metric.WithUnit("{batch}"))
+	if err != nil {
+		return nil, err
+	}
+	queued, err := meter.Int64ObservableGauge("buffer.channel.items",
+		metric.WithDescription("Items waiting in the buffer's channel."), metric.WithUnit("{item}"))
+	if err != nil {
+		return nil, err
+	}
+
+	triggerAttrs := make([]metric.ObserveOption, numTriggers)
+	for t := range numTriggers {
+		triggerAttrs[t] = metric.WithAttributes(bufferAttr, attribute.String("buffer.trigger", t.String()))
+	}
+	obsAttrs := metric.WithAttributes(bufferAttr)
+
+	o.registration, err = meter.RegisterCallback(func(_ context.Context, ob metric.Observer) error {
+		ob.ObserveInt64(added, int64(o.itemsAdded.Load()), obsAttrs)
+		ob.ObserveInt64(rejected, int64(o.itemsRejected.Load()), obsAttrs)
+		ob.ObserveInt64(dropped, int64(o.itemsDropped.Load()), obsAttrs)
+		ob.ObserveInt64(spilled, int64(o.itemsSpilled.Load()), obsAttrs)
+		ob.ObserveInt64(merged, int64(o.itemsMerged.Load()), obsAttrs)
+		ob.ObserveInt64(flushed, int64(o.itemsFlushed.Load()), obsAttrs)
+		for t := range numTriggers {
+			ob.ObserveInt64(flushes, int64(o.flushes[t].Load()), triggerAttrs[t])
+		}
+		ob.ObserveInt64(retries, int64(o.flushRetries.Load()), obsAttrs)
+		ob.ObserveInt64(flushErrors, int64(o.flushErrors.Load()), obsAttrs)
+		ob.ObserveInt64(queued, int64(inChannel()), obsAttrs)
+		return nil
+	}, added, rejected, dropped, spilled, merged, flushed, flushes, retries, flushErrors, queued)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *observer) added() {
+	if o != nil {
+		o.itemsAdded.Add(1)
+	}
+}
+
+func (o *observer) rejected() {
+	if o != nil {
+		o.itemsRejected.Add(1)
+	}
+}
+
+func (o *observer) dropped() {
+	if o != nil {
+		o.itemsDropped.Add(1)
+	}
+}
+
+func (o *observer) spilled() {
+	if o != nil {
+		o.itemsSpilled.Add(1)
+	}
+}
+
+func (o *observer) merged() {
+	if o != nil {
+		o.itemsMerged.Add(1)
+	}
+}
+
+// startFlush opens the span covering the delivery of one batch and counts
+// the flush. The returned context carries the span and is what Flush
+// receives.
+func (o *observer) startFlush(ctx context.Context, trigger Trigger, size int) (context.Context, trace.Span) {
+	if o == nil {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	o.flushes[trigger].Add(1)
+	o.batchSize.Observe(float64(size))
+	if o.otelBatchSize != nil {
+		o.otelBatchSize.Record(ctx, int64(size), o.attrs)
+	}
+	return o.tracer.Start(ctx, "buffer.flush", trace.WithAttributes(
+		attribute.String("buffer.trigger", trigger.String()),
+		attribute.Int("buffer.batch_size", size),
+	))
+}
+
+// attempt records one Flush call that took d and returned err.
+func (o *observer) attempt(ctx context.Context, span trace.Span, attempt int, d time.Duration, err error) {
+	if o == nil {
+		return
+	}
+	if attempt > 1 {
+		o.flushRetries.Add(1)
+	}
+	ms := float64(d) / float64(time.Millisecond)
+	o.latency.Observe(ms)
+	if o.otelLatency != nil {
+		o.otelLatency.Record(ctx, ms, o.attrs)
+	}
+	if err != nil {
+		span.AddEvent("flush attempt failed", trace.WithAttributes(
+			attribute.Int("buffer.attempt", attempt),
+			attribute.String("error", err.Error()),
+		))
+	}
+}
+
+// endFlush closes the span opened by startFlush. err is nil if the batch
+// was flushed.
+func (o *observer) endFlush(span trace.Span, size int, err error) {
+	if o == nil {
+		return
+	}
+	if err != nil {
+		o.flushErrors.Add(1)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "flush failed")
+	} else {
+		o.itemsFlushed.Add(uint64(size))
+	}
+	span.End()
+}
+
+// close stops exporting the observable metrics once the buffer has shut
+// down. Stats keeps working.
+func (o *observer) close() {
+	if o != nil && o.registration != nil {
+		o.registration.Unregister()
+	}
+}
+
+func (o *observer) snapshot() Stats {
+	s := Stats{
+		ItemsAdded:    o.itemsAdded.Load(),
+		ItemsRejected: o.itemsRejected.Load(),
+		ItemsDropped:  o.itemsDropped.Load(),
+		ItemsSpilled:  o.itemsSpilled.Load(),
+		ItemsMerged:   o.itemsMerged.Load(),
+		ItemsFlushed:  o.itemsFlushed.Load(),
+		Flushes:       make(map[Trigger]uint64, numTriggers),
+		FlushRetries:  o.flushRetries.Load(),
+		FlushErrors:   o.flushErrors.Load(),
+		FlushLatency:  o.latency.Snapshot(),
+		BatchSize:     o.batchSize.Snapshot(),
+	}
+	for t := range numTriggers {
+		s.Flushes[t] = o.flushes[t].Load()
+	}
+	return s
+}
@@ -0,0 +1,130 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestBuffer_Stats verifies the counters and histograms exposed by Stats.
+func TestBuffer_Stats(t *testing.T) {
+	cfg := Config[int]{
+		Capacity: 2,
+		CanAdd: func(batch []int, item int) bool {
+			return item != 99
+		},
+		Flush: func(ctx context.Context, batch []int) error {
+			return nil
+		},
+		// Exercise the OpenTelemetry registration path as well.
+		MeterProvider: noop.NewMeterProvider(),
+	}
+
+	buf := mustNewBuffer(t, cfg)
+	go buf.Run(context.Background())
+
+	for _, i := range []int{1, 2, 99, 3} {
+		buf.Add(context.Background(), i)
+	}
+	buf.Shutdown(context.Background())
+
+	s := buf.Stats()
+	if s.ItemsAdded != 4 {
+		t.Errorf("expected 4 items added, got %d", s.ItemsAdded)
+	}
+	if s.ItemsRejected != 1 {
+		t.Errorf("expected 1 item rejected, got %d", s.ItemsRejected)
+	}
+	if s.ItemsFlushed != 3 {
+		t.Errorf("expected 3 items flushed, got %d", s.ItemsFlushed)
+	}
+	if s.Flushes[TriggerSize] != 1 || s.Flushes[TriggerClose] != 1 {
+		t.Errorf("expected one size and one close flush, got %v", s.Flushes)
+	}
+	if s.BatchSize.Count != 2 || s.BatchSize.Sum != 3 {
+		t.Errorf("expected 2 batches totalling 3 items, got count %d sum %v", s.BatchSize.Count, s.BatchSize.Sum)
+	}
+	if s.FlushLatency.Count != 2 {
+		t.Errorf("expected 2 latency observations, got %d", s.FlushLatency.Count)
+	}
+}
+
+// TestBuffer_StatsErrors verifies that retries and failed batches are counted.
+func TestBuffer_StatsErrors(t *testing.T) {
+	cfg := Config[int]{
+		Capacity: 1,
+		Retry:    RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond},
+		Flush: func(ctx context.Context, batch []int) error {
+			return errors.New("backend down")
+		},
+	}
+
+	buf := mustNewBuffer(t, cfg)
+	go buf.Run(context.Background())
+	buf.Add(context.Background(), 1)
+	buf.Shutdown(context.Background())
+
+	s := buf.Stats()
+	if s.FlushRetries != 2 {
+		t.Errorf("expected 2 retries, got %d", s.FlushRetries)
+	}
+	if s.FlushErrors != 1 {
+		t.Errorf("expected 1 flush error, got %d", s.FlushErrors)
+	}
+	if s.ItemsFlushed != 0 {
+		t.Errorf("expected no items flushed, got %d", s.ItemsFlushed)
+	}
+}
+
+// TestBuffer_FlushSpan verifies that each batch gets a span which Flush can
+// see in its context, with failed attempts recorded as events.
+func TestBuffer_FlushSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	var attempts int
+	var sawSpan bool
+	cfg := Config[int]{
+		Capacity:       1,
+		TracerProvider: tp,
+		Retry:          RetryPolicy{MaxAttempts: 2, BaseBackoff: time.Millisecond},
+		Flush: func(ctx context.Context, batch []int) error {
+			sawSpan = trace.SpanFromContext(ctx).SpanContext().IsValid()
+			attempts++
+			if attempts == 1 {
+				return errors.New("throttled")
+			}
+			return nil
+		},
+	}
+
+	buf := mustNewBuffer(t, cfg)
+	go buf.Run(context.Background())
+	buf.Add(context.Background(), 1)
+	buf.Shutdown(context.Background())
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "buffer.flush" {
+		t.Errorf("unexpected span name %q", span.Name())
+	}
+	if len(span.Events()) != 1 {
+		t.Errorf("expected the failed attempt to be recorded as an event, got %d events", len(span.Events()))
+	}
+	if span.Status().Code == codes.Error {
+		t.Error("expected the span not to be marked as failed after a successful retry")
+	}
+	if !sawSpan {
+		t.Error("expected Flush to receive a context carrying the span")
+	}
+}
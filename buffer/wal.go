@@ -0,0 +1,397 @@
+package buffer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Codec converts items to and from the bytes stored in the write-ahead log.
+type Codec[T any] interface {
+	Marshal(item T) ([]byte, error)
+	Unmarshal(data []byte) (T, error)
+}
+
+// JSONCodec is a Codec that stores items as JSON. It is the default codec
+// of WALConfig.
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Marshal(item T) ([]byte, error) {
+	return json.Marshal(item)
+}
+
+func (JSONCodec[T]) Unmarshal(data []byte) (T, error) {
+	var item T
+	err := json.Unmarshal(data, &item)
+	return item, err
+}
+
+// WALConfig enables the durable, disk-backed mode of a Buffer.
+//
+// Every item accepted by Add is first appended to a write-ahead log in Dir,
+// so that items still sitting in dataChan or in the in-memory batch survive
+// a crash or an OOM kill. The log is split into segment files; a segment is
+// deleted once every item in it has been flushed successfully (or accepted
+// by DeadLetter).
+//
+// On startup, NewBuffer reads back any segments left behind by a previous
+// process and Run processes those items before anything passed to Add.
+//
+// Delivery is at-least-once: items that were flushed shortly before a crash
+// may be flushed again after the restart, and so may items that share a
+// segment with a batch that could not be flushed at all. Flush should be
+// idempotent, or the backend should de-duplicate.
+//
+//	buf, err := buffer.NewBuffer(buffer.Config[Click]{
+//	    Flush: insertClicks,
+//	    WAL: &buffer.WALConfig[Click]{
+//	        Dir: "/var/lib/clicks/wal",
+//	    },
+//	})
+//
+// Dir must be used by a single Buffer at a time.
+type WALConfig[T any] struct {
+	// Dir is the directory holding the segment files. It is created if it
+	// does not exist. Required.
+	Dir string
+
+	// Codec encodes items for the log.
+	//
+	// Default: JSONCodec.
+	Codec Codec[T]
+
+	// SegmentSize is the size in bytes after which a new segment file is
+	// started. Smaller segments are deleted sooner after their items have
+	// been flushed; larger ones mean fewer files.
+	//
+	// Default: 16 MiB.
+	SegmentSize int64
+
+	// SyncEveryWrite makes Add fsync the segment after every item. Without
+	// it, items survive a process crash but may be lost if the machine
+	// itself goes down. Syncing is expensive; only enable it if you need
+	// that guarantee.
+	//
+	// Default: false.
+	SyncEveryWrite bool
+
+	// OnCorrupt is called for every record that cannot be read back or
+	// decoded during replay. Such records are skipped.
+	//
+	// Default: no-op.
+	OnCorrupt func(err error)
+}
+
+func (c *WALConfig[T]) withDefaults() *WALConfig[T] {
+	cfg := *c
+	if cfg.Codec == nil {
+		cfg.Codec = JSONCodec[T]{}
+	}
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = 16 << 20
+	}
+	if cfg.OnCorrupt == nil {
+		cfg.OnCorrupt = func(error) {}
+	}
+	return &cfg
+}
+
+const walExt = ".wal"
+
+// recordHeaderSize is the length prefix plus the CRC32 of each record.
+const recordHeaderSize = 8
+
+var errCorruptRecord = errors.New("buffer: wal: corrupt record")
+
+// walEntry is an item recovered from the log together with its sequence
+// number.
+type walEntry[T any] struct {
+	seq  uint64
+	item T
+}
+
+// segment is one file of the write-ahead log. Records in a segment carry
+// the sequence numbers firstSeq to firstSeq+count-1, in file order.
+type segment struct {
+	index    uint64
+	path     string
+	firstSeq uint64
+	count    uint64
+	acked    uint64
+}
+
+// wal is an append-only, segmented log of encoded items. Every record is
+// identified by a sequence number that increases by one per append, which
+// Run mirrors by counting the items it receives in order.
+type wal struct {
+	mu sync.Mutex
+
+	dir         string
+	segmentSize int64
+	sync        bool
+
+	// segments holds every segment still on disk, oldest first. The last
+	// one is the active segment that appends go to.
+	segments   []*segment
+	active     *os.File
+	activeSize int64
+	lastSize   int64
+	nextSeq    uint64
+}
+
+// openWAL opens the log in dir, returning the payloads of every record left
+// behind by a previous process. Those records keep sequence numbers
+// 0..len(payloads)-1 and new appends continue after them.
+func openWAL(dir string, segmentSize int64, syncEveryWrite bool, onCorrupt func(error)) (*wal, [][]byte, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, fmt.Errorf("buffer: wal: %w", err)
+	}
+
+	indexes, err := listSegments(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w := &wal{dir: dir, segmentSize: segmentSize, sync: syncEveryWrite}
+
+	var payloads [][]byte
+	var nextIndex uint64
+	for _, index := range indexes {
+		path := w.segmentPath(index)
+		records, err := readSegment(path, onCorrupt)
+		if err != nil {
+			return nil, nil, err
+		}
+		nextIndex = index + 1
+
+		if len(records) == 0 {
+			os.Remove(path)
+			continue
+		}
+		w.segments = append(w.segments, &segment{
+			index:    index,
+			path:     path,
+			firstSeq: w.nextSeq,
+			count:    uint64(len(records)),
+		})
+		w.nextSeq += uint64(len(records))
+		payloads = append(payloads, records...)
+	}
+
+	if err := w.openSegment(nextIndex); err != nil {
+		return nil, nil, err
+	}
+	return w, payloads, nil
+}
+
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("buffer: wal: %w", err)
+	}
+
+	var indexes []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, walExt) {
+			continue
+		}
+		index, err := strconv.ParseUint(strings.TrimSuffix(name, walExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	return indexes, nil
+}
+
+// readSegment returns the payloads of all intact records in path. A torn
+// or corrupt record ends the segment: everything after it is reported to
+// onCorrupt and dropped.
+func readSegment(path string, onCorrupt func(error)) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("buffer: wal: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("buffer: wal: %w", err)
+	}
+
+	r := bufio.NewReader(f)
+	var records [][]byte
+	header := make([]byte, recordHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err != io.EOF {
+				onCorrupt(fmt.Errorf("%w: %s: truncated header", errCorruptRecord, path))
+			}
+			return records, nil
+		}
+		size := binary.BigEndian.Uint32(header[:4])
+		sum := binary.BigEndian.Uint32(header[4:])
+		if int64(size) > info.Size() {
+			onCorrupt(fmt.Errorf("%w: %s: invalid record length", errCorruptRecord, path))
+			return records, nil
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			onCorrupt(fmt.Errorf("%w: %s: truncated payload", errCorruptRecord, path))
+			return records, nil
+		}
+		if crc32.ChecksumIEEE(payload) != sum {
+			onCorrupt(fmt.Errorf("%w: %s: checksum mismatch", errCorruptRecord, path))
+			return records, nil
+		}
+		records = append(records, payload)
+	}
+}
+
+func (w *wal) segmentPath(index uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%020d%s", index, walExt))
+}
+
+// openSegment starts a new active segment. Callers must hold mu or have
+// exclusive access to w.
+func (w *wal) openSegment(index uint64) error {
+	path := w.segmentPath(index)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("buffer: wal: %w", err)
+	}
+	w.active = f
+	w.activeSize = 0
+	w.segments = append(w.segments, &segment{index: index, path: path, firstSeq: w.nextSeq})
+	return nil
+}
+
+// append writes a record for payload and returns its sequence number.
+func (w *wal) append(payload []byte) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.activeSize >= w.segmentSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderSize:], payload)
+
+	if _, err := w.active.Write(record); err != nil {
+		// Drop whatever part of the record made it to disk so the next
+		// append does not land behind a torn record.
+		w.active.Truncate(w.activeSize)
+		w.active.Seek(w.activeSize, io.SeekStart)
+		return 0, fmt.Errorf("buffer: wal: %w", err)
+	}
+	if w.sync {
+		if err := w.active.Sync(); err != nil {
+			return 0, fmt.Errorf("buffer: wal: %w", err)
+		}
+	}
+
+	w.activeSize += int64(len(record))
+	w.lastSize = int64(len(record))
+	seq := w.nextSeq
+	w.nextSeq++
+	w.segments[len(w.segments)-1].count++
+	return seq, nil
+}
+
+// undo removes the record written by the last append. It is used when the
+// item could not be handed to Run after all, so that it is neither replayed
+// nor expected to be acknowledged. It must be called before any other
+// append.
+func (w *wal) undo() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.activeSize -= w.lastSize
+	w.lastSize = 0
+	w.active.Truncate(w.activeSize)
+	w.active.Seek(w.activeSize, io.SeekStart)
+	w.nextSeq--
+	w.segments[len(w.segments)-1].count--
+}
+
+// rotate seals the active segment and starts a new one. Callers must hold
+// mu.
+func (w *wal) rotate() error {
+	if err := w.active.Close(); err != nil {
+		return fmt.Errorf("buffer: wal: %w", err)
+	}
+	sealed := w.segments[len(w.segments)-1]
+	if err := w.openSegment(sealed.index + 1); err != nil {
+		return err
+	}
+	if sealed.acked == sealed.count {
+		w.remove(len(w.segments) - 2)
+	}
+	return nil
+}
+
+// ack marks the records with the given sequence numbers as flushed and
+// deletes every sealed segment whose records have all been acknowledged.
+func (w *wal) ack(seqs []uint64) {
+	if len(seqs) == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, seq := range seqs {
+		i := sort.Search(len(w.segments), func(i int) bool {
+			return w.segments[i].firstSeq > seq
+		}) - 1
+		if i < 0 {
+			continue
+		}
+		seg := w.segments[i]
+		seg.acked++
+		if seg.acked == seg.count && i < len(w.segments)-1 {
+			w.remove(i)
+		}
+	}
+}
+
+// remove deletes the sealed segment at position i. Callers must hold mu.
+func (w *wal) remove(i int) {
+	os.Remove(w.segments[i].path)
+	w.segments = append(w.segments[:i], w.segments[i+1:]...)
+}
+
+// close closes the active segment, deleting it if everything in it has been
+// acknowledged.
+func (w *wal) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.active.Close()
+	active := w.segments[len(w.segments)-1]
+	if active.acked == active.count {
+		os.Remove(active.path)
+	}
+	if err != nil {
+		return fmt.Errorf("buffer: wal: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func walFiles(tb testing.TB, dir string) []string {
+	tb.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+walExt))
+	if err != nil {
+		tb.Fatalf("failed to list wal files: %v", err)
+	}
+	return files
+}
+
+// TestBuffer_WALReplay verifies that items accepted by a buffer that never
+// got to flush them are replayed by the next buffer using the same dir.
+func TestBuffer_WALReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	// First process: items are accepted but Run never flushes them, as if
+	// the process had been killed.
+	crashed := mustNewBuffer(t, Config[int]{
+		Capacity: 100,
+		Flush:    func(ctx context.Context, batch []int) error { return nil },
+		WAL:      &WALConfig[int]{Dir: dir},
+	})
+	for i := 1; i <= 3; i++ {
+		if err := crashed.Add(context.Background(), i); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	crashed.wal.active.Close()
+
+	// Second process: replays and flushes them.
+	var mu sync.Mutex
+	var received []int
+	buf := mustNewBuffer(t, Config[int]{
+		Capacity: 100,
+		Flush: func(ctx context.Context, batch []int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			received = append(received, batch...)
+			return nil
+		},
+		WAL: &WALConfig[int]{Dir: dir},
+	})
+	go buf.Run(context.Background())
+	buf.Add(context.Background(), 4)
+	buf.Shutdown(context.Background())
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 4 || received[0] != 1 || received[3] != 4 {
+		t.Errorf("expected replayed items followed by new ones [1 2 3 4], got %v", received)
+	}
+	if files := walFiles(t, dir); len(files) != 0 {
+		t.Errorf("expected every segment to be deleted after a clean flush, got %v", files)
+	}
+}
+
+// TestBuffer_WALKeepsFailedBatches verifies that a batch that could not be
+// flushed stays in the log and is replayed on the next start.
+func TestBuffer_WALKeepsFailedBatches(t *testing.T) {
+	dir := t.TempDir()
+
+	failing := mustNewBuffer(t, Config[int]{
+		Capacity: 2,
+		Flush: func(ctx context.Context, batch []int) error {
+			return errors.New("backend down")
+		},
+		WAL: &WALConfig[int]{Dir: dir},
+	})
+	go failing.Run(context.Background())
+	failing.Add(context.Background(), 1)
+	failing.Add(context.Background(), 2)
+	failing.Shutdown(context.Background())
+
+	if files := walFiles(t, dir); len(files) == 0 {
+		t.Fatal("expected the failed batch to be kept on disk")
+	}
+
+	var received []int
+	buf := mustNewBuffer(t, Config[int]{
+		Capacity: 2,
+		Flush: func(ctx context.Context, batch []int) error {
+			received = append(received, batch...)
+			return nil
+		},
+		WAL: &WALConfig[int]{Dir: dir},
+	})
+	go buf.Run(context.Background())
+	buf.Shutdown(context.Background())
+
+	sort.Ints(received)
+	if len(received) != 2 || received[0] != 1 || received[1] != 2 {
+		t.Errorf("expected the failed batch to be replayed, got %v", received)
+	}
+	if files := walFiles(t, dir); len(files) != 0 {
+		t.Errorf("expected the log to be empty after replay, got %v", files)
+	}
+}
+
+// TestBuffer_WALSegmentRotation verifies that the log is split into segments
+// and that flushed segments are removed while the buffer is running.
+func TestBuffer_WALSegmentRotation(t *testing.T) {
+	dir := t.TempDir()
+	flushed := make(chan struct{}, 100)
+
+	buf := mustNewBuffer(t, Config[int]{
+		Capacity: 5,
+		Flush: func(ctx context.Context, batch []int) error {
+			flushed <- struct{}{}
+			return nil
+		},
+		// Each record is a little over 8 bytes, so every few items start
+		// a new segment.
+		WAL: &WALConfig[int]{Dir: dir, SegmentSize: 32},
+	})
+	go buf.Run(context.Background())
+
+	for i := range 50 {
+		buf.Add(context.Background(), i)
+	}
+	for range 10 {
+		<-flushed
+	}
+
+	// Only the active segment may be left around.
+	if files := walFiles(t, dir); len(files) > 1 {
+		t.Errorf("expected flushed segments to be deleted, got %d files", len(files))
+	}
+
+	buf.Shutdown(context.Background())
+	if files := walFiles(t, dir); len(files) != 0 {
+		t.Errorf("expected no segments after Shutdown, got %v", files)
+	}
+}
+
+// TestBuffer_WALCorruptTail verifies that a torn record at the end of a
+// segment is reported and skipped while earlier records are still replayed.
+func TestBuffer_WALCorruptTail(t *testing.T) {
+	dir := t.TempDir()
+
+	crashed := mustNewBuffer(t, Config[int]{
+		Flush: func(ctx context.Context, batch []int) error { return nil },
+		WAL:   &WALConfig[int]{Dir: dir},
+	})
+	crashed.Add(context.Background(), 7)
+	crashed.Add(context.Background(), 8)
+	crashed.wal.active.Close()
+
+	files := walFiles(t, dir)
+	if len(files) != 1 {
+		t.Fatalf("expected a single segment, got %v", files)
+	}
+	f, err := os.OpenFile(files[0], os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 9, 1, 2}) // torn header
+	f.Close()
+
+	var corrupt int
+	var received []int
+	buf := mustNewBuffer(t, Config[int]{
+		Flush: func(ctx context.Context, batch []int) error {
+			received = append(received, batch...)
+			return nil
+		},
+		WAL: &WALConfig[int]{
+			Dir:       dir,
+			OnCorrupt: func(err error) { corrupt++ },
+		},
+	})
+	go buf.Run(context.Background())
+	buf.Shutdown(context.Background())
+
+	if corrupt != 1 {
+		t.Errorf("expected 1 corrupt record to be reported, got %d", corrupt)
+	}
+	if len(received) != 2 || received[0] != 7 || received[1] != 8 {
+		t.Errorf("expected intact records [7 8] to be replayed, got %v", received)
+	}
+}
+
+// TestBuffer_WALAddCancelled verifies that an item whose Add was cancelled
+// is removed from the log again, so it is not replayed later.
+func TestBuffer_WALAddCancelled(t *testing.T) {
+	dir := t.TempDir()
+
+	crashed := mustNewBuffer(t, Config[int]{
+		ChanSize: 1,
+		Flush:    func(ctx context.Context, batch []int) error { return nil },
+		WAL:      &WALConfig[int]{Dir: dir},
+	})
+	crashed.Add(context.Background(), 1) // fills dataChan
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := crashed.addDurable(ctx, 2, true); err == nil {
+		t.Fatal("expected Add to fail on a full channel with a cancelled context")
+	}
+	crashed.wal.active.Close()
+
+	var received []int
+	buf := mustNewBuffer(t, Config[int]{
+		Flush: func(ctx context.Context, batch []int) error {
+			received = append(received, batch...)
+			return nil
+		},
+		WAL: &WALConfig[int]{Dir: dir},
+	})
+	go buf.Run(context.Background())
+	buf.Shutdown(context.Background())
+
+	if len(received) != 1 || received[0] != 1 {
+		t.Errorf("expected only the accepted item to be replayed, got %v", received)
+	}
+}
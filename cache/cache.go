@@ -1,20 +1,29 @@
 package cache
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/patrickmn/go-cache"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/sync/singleflight"
 )
 
 var mu sync.Mutex
 
 type Client struct {
 	prefix string
-	client *cache.Cache
+	local  localStore
+
+	// tagMu serializes the updates of tag versions, see SetWithTags
+	tagMu sync.Mutex
 }
 
 type Config struct {
@@ -23,41 +32,124 @@ type Config struct {
 	CacheTime int
 	MaxConns  int
 	Timeout   time.Duration
+
+	// Remote is the shared tier to use instead of the memcache server
+	// given by MCServer, e.g. a RedisStore. MCServer, MaxConns and Timeout
+	// are ignored when it is set
+	Remote RemoteStore
+
+	// Bus, if set, evicts keys set or deleted by other instances from the
+	// in-memory tier, see InvalidationBus. Call Close to stop listening
+	Bus InvalidationBus
+
+	// BusFallbackTTL bounds how long entries stay in the in-memory tier
+	// while the client is not subscribed to Bus, since invalidations are
+	// missed then. Defaults to 30 seconds
+	BusFallbackTTL time.Duration
+
+	// BusTimeout bounds each invalidation published on Bus, which the
+	// writes wait for. Defaults to 1 second
+	BusTimeout time.Duration
+
+	// OnBusError, if set, is called with the invalidations that could not
+	// be published, whose keys other instances keep serving until they
+	// expire, and with the errors that end a subscription to Bus
+	OnBusError func(err error)
+
+	// MaxEntries and MaxBytes, if either is set, bound the in-memory tier
+	// to that many entries and approximately that many bytes, evicting
+	// entries as per Eviction, see BoundedStore. The in-memory tier is
+	// unbounded by default
+	MaxEntries int
+	MaxBytes   int64
+	Eviction   EvictionPolicy
+
+	// MeterProvider, if set, exports the counters behind Stats, the shared
+	// tier latencies and the size of the in-memory tier as OpenTelemetry
+	// metrics named "cache.*", with the prefix as "cache.prefix" attribute
+	MeterProvider metric.MeterProvider
 }
 
 type MultiClient struct {
 	prefix     string
 	expiration int32
-	client     *cache.Cache
-	mc         *memcache.Client
+	local      localStore
+	remote     RemoteStore
+
+	// client is the go-cache instance behind local, nil when the
+	// in-memory tier is bounded
+	client *cache.Cache
+
+	// store is the shared tier as given, remote wraps it to record the
+	// latency of every call
+	store RemoteStore
+	obs   *observer
+
+	// mc is the memcache client behind remote, nil when remote is not a
+	// memcache server created by the constructors
+	mc *memcache.Client
+
+	// loads collapses concurrent GetOrLoad misses, for all the Tiered
+	// views of this client
+	loads singleflight.Group
+
+	// inv is nil unless Config.Bus is set, busUp tells whether the client
+	// is currently subscribed to it
+	inv   *invalidation
+	busUp atomic.Bool
 }
 
 func NewClient(prefix string, defCacheTime int) *Client {
 	var cacheTime = time.Duration(defCacheTime) * time.Minute
 	c := cache.New(cacheTime, 5*time.Minute)
 
-	var cc = &Client{client: c, prefix: prefix}
+	var cc = &Client{local: c, prefix: prefix}
 	return cc
 }
 
+// NewClientV2 method will return a pointer to Client object, using the
+// Prefix, CacheTime and in-memory bounds of opts
+func NewClientV2(opts *Config) *Client {
+	local, _ := newLocalStore(opts)
+	return &Client{local: local, prefix: opts.Prefix}
+}
+
 func (cc *Client) getKeyName(key string) string {
 	return cc.prefix + "_" + key
 }
 
 func (cc *Client) Set(key string, val interface{}) {
-	cc.client.SetDefault(cc.getKeyName(key), val)
+	cc.local.Set(cc.getKeyName(key), val, cache.DefaultExpiration)
 }
 
 func (cc *Client) SetWithExpire(key string, val interface{}, duration time.Duration) {
-	cc.client.Set(cc.getKeyName(key), val, duration)
+	cc.local.Set(cc.getKeyName(key), val, duration)
 }
 
 func (cc *Client) Get(key string) (interface{}, bool) {
-	return cc.client.Get(cc.getKeyName(key))
+	raw, found := cc.local.Get(cc.getKeyName(key))
+	e, ok := raw.(anyEntry)
+	if !found || !ok {
+		return raw, found
+	}
+	val, _, tags := e.unwrap()
+	names := make([]string, len(tags))
+	for i, tv := range tags {
+		names[i] = tv.tag
+	}
+	if !slices.Equal(tags, cc.tagVersions(names)) {
+		return nil, false
+	}
+	return val, true
 }
 
 func (cc *Client) Delete(key string) {
-	cc.client.Delete(cc.getKeyName(key))
+	cc.local.Delete(cc.getKeyName(key))
+}
+
+// LocalStats method will return the stats of the memory cache
+func (cc *Client) LocalStats() LocalStats {
+	return localStats(cc.local)
 }
 
 // NewMultiClient method will return a pointer to MultiClient object
@@ -68,117 +160,191 @@ func NewMultiClient(prefix, mcServer string, defCacheTime int) *MultiClient {
 	mc.Timeout = 20 * time.Millisecond
 	mc.MaxIdleConns = 1024
 
-	var cc = &MultiClient{client: c, mc: mc, prefix: prefix, expiration: int32(defCacheTime * 36)}
+	var cc = &MultiClient{client: c, local: c, mc: mc, prefix: prefix, expiration: int32(defCacheTime * 36)}
+	cc.setRemote(NewMemcacheStore(mc), nil)
 	return cc
 }
 
-// NewMultiClientV2 method will return a pointer to MultiClient object
+// NewMultiClientV2 method will return a pointer to MultiClient object. The
+// shared tier is opts.Remote if set, else the memcache server opts.MCServer
 func NewMultiClientV2(opts *Config) *MultiClient {
-	var cacheTime = time.Duration(opts.CacheTime) * time.Minute
-	c := cache.New(cacheTime, 5*time.Minute)
-	mc := memcache.New(opts.MCServer)
-	mc.Timeout = 20 * time.Millisecond
-	mc.MaxIdleConns = opts.MaxConns
-	if opts.Timeout > 0 {
-		mc.Timeout = opts.Timeout
+	local, c := newLocalStore(opts)
+
+	var cc = &MultiClient{client: c, local: local, prefix: opts.Prefix, expiration: int32(opts.CacheTime * 36)}
+	store := opts.Remote
+	if store == nil {
+		mc := memcache.New(opts.MCServer)
+		mc.Timeout = 20 * time.Millisecond
+		mc.MaxIdleConns = opts.MaxConns
+		if opts.Timeout > 0 {
+			mc.Timeout = opts.Timeout
+		}
+		cc.mc = mc
+		store = NewMemcacheStore(mc)
+	}
+	cc.setRemote(store, opts.MeterProvider)
+	if opts.Bus != nil {
+		cc.startInvalidation(opts)
 	}
-
-	var cc = &MultiClient{client: c, mc: mc, prefix: opts.Prefix, expiration: int32(opts.CacheTime * 36)}
 	return cc
 }
 
-// GetInternalClient method will return the pointer to internal memory cache client
+// GetInternalClient method will return the pointer to internal memory cache
+// client, or nil if the memory cache is bounded, see Config.MaxEntries
 func (cc *MultiClient) GetInternalClient() *cache.Cache {
 	return cc.client
 }
 
+// LocalStats method will return the stats of the memory cache
+func (cc *MultiClient) LocalStats() LocalStats {
+	return localStats(cc.local)
+}
+
+// GetMemcacheClient method will return the memcache client, or nil if the
+// shared tier is not a memcache server, see GetRemoteStore
 func (cc *MultiClient) GetMemcacheClient() *memcache.Client {
 	return cc.mc
 }
 
+// GetRemoteStore method will return the shared tier of the client
+func (cc *MultiClient) GetRemoteStore() RemoteStore {
+	return cc.store
+}
+
+// setRemote makes store the shared tier, recording its latencies. Metrics
+// that cannot be registered with mp are only available through Stats, and
+// the error goes to the OpenTelemetry error handler, see otel.Handle.
+func (cc *MultiClient) setRemote(store RemoteStore, mp metric.MeterProvider) {
+	obs, err := newObserver(cc.prefix, mp, cc.LocalStats)
+	if err != nil {
+		otel.Handle(fmt.Errorf("cache: register metrics of %q: %w", cc.prefix, err))
+		obs, _ = newObserver(cc.prefix, nil, nil)
+	}
+	cc.store = store
+	cc.obs = obs
+	cc.remote = observedStore{store: store, obs: obs}
+}
+
+// Stats method will return the counters of the client
+func (cc *MultiClient) Stats() Stats {
+	stats := cc.obs.snapshot()
+	stats.Prefix = cc.prefix
+	stats.Local = cc.LocalStats()
+	return stats
+}
+
+// Close method stops listening to the invalidation bus, if any, and stops
+// exporting metrics through Config.MeterProvider. From then on the
+// in-process tier keeps entries for at most Config.BusFallbackTTL
+func (cc *MultiClient) Close() {
+	if cc.inv != nil {
+		cc.inv.stop()
+		<-cc.inv.done
+	}
+	cc.obs.close()
+}
+
 func (cc *MultiClient) getKeyName(key string) string {
 	return cc.prefix + "_" + key
 }
 
+func (cc *MultiClient) remoteTTL() time.Duration {
+	return time.Duration(cc.expiration) * time.Second
+}
+
 // Set method will set the object in both memory cache and memcache
 func (cc *MultiClient) Set(key string, val interface{}) {
 	k := cc.getKeyName(key)
-	cc.client.SetDefault(k, val)
+	cc.setLocal(k, val, cache.DefaultExpiration)
 
 	result, err := json.Marshal(val)
 	if err == nil {
-		cc.mc.Set(&memcache.Item{
-			Key:        k,
-			Value:      result,
-			Expiration: cc.expiration,
-		})
+		cc.remote.Set(context.Background(), k, result, cc.remoteTTL())
 	}
+	cc.invalidate(context.Background(), k)
 }
 
 // SetInMemory method will set the object in memory cache
 func (cc *MultiClient) SetInMemory(key string, val interface{}) {
 	k := cc.getKeyName(key)
-	cc.client.Set(k, val, time.Duration(cc.expiration)*time.Second)
+	cc.setLocal(k, val, cc.remoteTTL())
 }
 
 // DelFromMemory method will delete the object from memory
 func (cc *MultiClient) DelFromMemory(key string) {
 	k := cc.getKeyName(key)
-	cc.client.Delete(k)
+	cc.local.Delete(k)
 }
 
 // SetWithExpire method will set the object in both memory cache and memcache
 func (cc *MultiClient) SetWithExpire(key string, val interface{}, secs int) {
 	k := cc.getKeyName(key)
-	cc.client.Set(k, val, time.Duration(secs)*time.Second)
+	cc.setLocal(k, val, time.Duration(secs)*time.Second)
 
 	result, err := json.Marshal(val)
 	if err == nil {
-		cc.mc.Set(&memcache.Item{
-			Key:        k,
-			Value:      result,
-			Expiration: int32(secs),
-		})
+		cc.remote.Set(context.Background(), k, result, time.Duration(secs)*time.Second)
 	}
+	cc.invalidate(context.Background(), k)
 }
 
 // Get method tires to find the key from memory cache then check memcache
 func (cc *MultiClient) Get(key string) (interface{}, bool) {
 	k := cc.getKeyName(key)
-	result, found := cc.client.Get(k)
+	result, found := cc.localValue(k)
 	if found {
 		return result, found
 	}
-	item, err := cc.mc.Get(k)
+	value, err := cc.remoteValue(k)
 	if err == nil {
 		var cacheObj interface{}
-		err = json.Unmarshal(item.Value, cacheObj)
+		err = json.Unmarshal(value, &cacheObj)
 		if err == nil {
+			cc.obs.remoteHit()
 			return cacheObj, true
 		}
+		cc.obs.decodeError()
 	}
 
 	return nil, false
 }
 
+// GetMulti method looks the keys up like Get, querying the shared tier once
+// for all the keys missing from memory cache, and stores what it finds there
+// in memory cache. It returns the values found and the keys found nowhere,
+// in the order of keys. Values read back from the shared tier are decoded as
+// generic JSON, use Tiered.GetMulti to get typed values
+func (cc *MultiClient) GetMulti(keys []string) (map[string]interface{}, []string) {
+	values, missing, _ := NewTiered[interface{}](cc, TieredOptions[interface{}]{}).GetMulti(context.Background(), keys)
+	return values, missing
+}
+
+// SetMulti method will set the objects in both memory cache and the shared
+// tier, like Set
+func (cc *MultiClient) SetMulti(values map[string]interface{}) {
+	NewTiered[interface{}](cc, TieredOptions[interface{}]{}).SetMulti(context.Background(), values)
+}
+
 // GetWithSet method tries to get the key from program memory cache and if
 // it fails then tries memcache and if the item is found in memcache then it
 // is set in program memory for faster lookup
 func (cc *MultiClient) GetWithSet(key string, resultObj interface{}) (interface{}, bool) {
 	k := cc.getKeyName(key)
-	result, found := cc.client.Get(k)
+	result, found := cc.localValue(k)
 	if found {
 		return result, found
 	}
-	item, err := cc.mc.Get(k)
+	value, err := cc.remoteValue(k)
 	if err == nil {
 		mu.Lock()
-		err = json.Unmarshal(item.Value, resultObj)
+		err = json.Unmarshal(value, resultObj)
 		mu.Unlock()
 		if err == nil {
-			cc.client.Set(k, resultObj, time.Duration(cc.expiration)*time.Second)
+			cc.obs.remoteHit()
+			cc.setLocal(k, resultObj, cc.remoteTTL())
 			return resultObj, true
 		}
+		cc.obs.decodeError()
 	}
 
 	return nil, false
@@ -189,13 +355,14 @@ func (cc *MultiClient) GetWithSet(key string, resultObj interface{}) (interface{
 // is set in program memory for faster lookup
 func (cc *MultiClient) GetSliceOrBytes(key string) (interface{}, bool) {
 	k := cc.getKeyName(key)
-	result, found := cc.client.Get(k)
+	result, found := cc.localValue(k)
 	if found {
 		return result, found
 	}
-	item, err := cc.mc.Get(k)
+	value, err := cc.remoteValue(k)
 	if err == nil {
-		return item.Value, true
+		cc.obs.remoteHit()
+		return value, true
 	}
 
 	return nil, false
@@ -206,59 +373,65 @@ func (cc *MultiClient) GetSliceOrBytes(key string) (interface{}, bool) {
 // is set in program memory for faster lookup
 func (cc *MultiClient) GetIntWithSet(key string, resultObj int64) (int64, bool) {
 	k := cc.getKeyName(key)
-	result, found := cc.client.Get(k)
+	result, found := cc.localValue(k)
 	if found {
 		r := result.(int64)
 		return r, found
 	}
-	item, err := cc.mc.Get(k)
+	value, err := cc.remoteValue(k)
 	if err == nil {
 		mu.Lock()
-		err = json.Unmarshal(item.Value, &resultObj)
+		err = json.Unmarshal(value, &resultObj)
 		mu.Unlock()
 		if err == nil {
-			cc.client.Set(k, resultObj, 5*time.Minute)
+			cc.obs.remoteHit()
+			cc.setLocal(k, resultObj, 5*time.Minute)
 			return resultObj, true
 		}
+		cc.obs.decodeError()
 	}
 
 	return 0, false
 }
 
+// GetOrLoad method returns the value cached for the key or calls loader to
+// produce it, collapsing concurrent misses into a single loader call. See
+// Tiered.GetOrLoad for the details; values read back from memcache are
+// decoded as generic JSON (maps, slices, float64...), so use a Tiered cache
+// to get typed values
+func (cc *MultiClient) GetOrLoad(ctx context.Context, key string, loader func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	return NewTiered[interface{}](cc, TieredOptions[interface{}]{}).GetOrLoad(ctx, key, loader)
+}
+
 // Delete method will remove the key from both memory cache and memcache
 func (cc *MultiClient) Delete(key string) {
 	k := cc.getKeyName(key)
-	cc.client.Delete(k)
-	cc.mc.Delete(k)
+	cc.local.Delete(k)
+	cc.remote.Delete(context.Background(), k)
+	cc.invalidate(context.Background(), k)
 }
 
+// IncrementKey method will increment the counter stored in the shared tier,
+// creating it with val if it does not exist. It returns 0 on error, use Incr
+// to get the error
 func (cc *MultiClient) IncrementKey(key string, val uint64) uint64 {
-	if cc.mc == nil {
-		return 0
-	}
 	k := cc.getKeyName(key)
-	_, err := cc.mc.Get(k)
-	if err == nil {
-		newValue, _ := cc.mc.Increment(k, val)
-		return newValue
+	newValue, err := cc.remote.Incr(context.Background(), k, int64(val), cc.remoteTTL())
+	if err != nil {
+		return 0
 	}
-	cc.mc.Set(&memcache.Item{
-		Key:        k,
-		Value:      []byte(fmt.Sprintf("%d", val)),
-		Expiration: cc.expiration,
-	})
-	return val
+	return uint64(newValue)
 }
 
+// DecrementKey method will atomically decrement the counter stored in the
+// shared tier, creating it with 0 if it does not exist. The result is at
+// least 0, even on a shared tier whose counters go below it. It returns 0 on
+// error, use Decr to get the error
 func (cc *MultiClient) DecrementKey(key string, val uint64) uint64 {
-	if cc.mc == nil {
-		return 0
-	}
 	k := cc.getKeyName(key)
-	_, err := cc.mc.Get(k)
-	if err == nil {
-		newValue, _ := cc.mc.Decrement(k, val)
-		return newValue
+	newValue, err := cc.remote.Incr(context.Background(), k, -int64(val), cc.remoteTTL())
+	if err != nil {
+		return 0
 	}
-	return 0
+	return uint64(max(newValue, 0))
 }
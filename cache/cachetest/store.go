@@ -0,0 +1,332 @@
+// Package cachetest provides an in-memory stand-in for memcache, so that
+// code using cache.MultiClient can be tested without a memcached server.
+//
+//	mc, store := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+//	mc.Set("campaign", c)
+//	mc.DelFromMemory("campaign") // the next Get goes to the fake memcache
+//	store.Advance(time.Hour)     // and now the value has expired
+//
+// Code that calls MultiClient.GetMemcacheClient gets nil with the fake: use
+// GetRemoteStore instead.
+package cachetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CloudStuffTech/go-utils/cache"
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+const (
+	// MaxKeyLen is the longest key memcache accepts.
+	MaxKeyLen = 250
+
+	// MaxValueLen is the largest value memcache stores by default.
+	MaxValueLen = 1 << 20
+
+	// maxRelativeExpiration is the longest expiration memcache reads as a
+	// number of seconds; larger ones are unix timestamps.
+	maxRelativeExpiration = 30 * 24 * time.Hour
+)
+
+// ErrValueTooLarge is returned when storing a value over MaxValueLen, like
+// memcache's "object too large for cache" server error.
+var ErrValueTooLarge = errors.New("cachetest: value too large for cache")
+
+// Store is an in-memory cache.RemoteStore that behaves like memcache:
+//   - ttls are rounded up to the second, and those over 30 days are turned
+//     into a unix timestamp, as memcache.Client would send them;
+//   - keys over MaxKeyLen bytes or holding spaces or control characters are
+//     rejected with memcache.ErrMalformedKey, values over MaxValueLen with
+//     ErrValueTooLarge;
+//   - counters are unsigned decimal strings, decrementing stops at 0;
+//   - Add fails with cache.ErrNotStored if the item exists;
+//   - every write gives the item a new CAS id, see GetCAS.
+//
+// Its clock only moves forward through Advance, on top of the real clock,
+// so tests can expire items without sleeping. It is safe for concurrent
+// use.
+type Store struct {
+	mu     sync.Mutex
+	items  map[string]item
+	offset time.Duration
+	nextID uint64
+	calls  int
+}
+
+type item struct {
+	value   []byte
+	expires time.Time
+	cas     uint64
+}
+
+// NewStore returns an empty Store
+func NewStore() *Store {
+	return &Store{items: make(map[string]item)}
+}
+
+// NewMultiClient returns a MultiClient configured by opts, whose shared tier
+// is a new Store. opts may be nil; opts.Remote is overwritten
+func NewMultiClient(opts *cache.Config) (*cache.MultiClient, *Store) {
+	var cfg cache.Config
+	if opts != nil {
+		cfg = *opts
+	}
+	if cfg.CacheTime == 0 {
+		cfg.CacheTime = 10
+	}
+	store := NewStore()
+	cfg.Remote = store
+	return cache.NewMultiClientV2(&cfg), store
+}
+
+// Advance moves the clock of the store forward by d.
+func (s *Store) Advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+}
+
+// Calls returns the number of calls made to the store so far, e.g. to
+// check that a value was served by the in-memory tier.
+func (s *Store) Calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+// Len returns the number of items that have not expired.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for k := range s.items {
+		if _, found := s.get(k); found {
+			n++
+		}
+	}
+	return n
+}
+
+// FlushAll removes every item, like memcache's flush_all.
+func (s *Store) FlushAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.items)
+}
+
+func (s *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	value, _, err := s.GetCAS(ctx, key)
+	return value, err
+}
+
+func (s *Store) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.begin(ctx, keys...); err != nil {
+		return nil, err
+	}
+	values := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		if it, found := s.get(k); found {
+			values[k] = clone(it.value)
+		}
+	}
+	return values, nil
+}
+
+func (s *Store) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.begin(ctx, key); err != nil {
+		return err
+	}
+	return s.set(key, value, ttl)
+}
+
+func (s *Store) SetMulti(ctx context.Context, items map[string][]byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.begin(ctx); err != nil {
+		return err
+	}
+	// memcache has no multi-set: each item succeeds or fails on its own.
+	var first error
+	for k, value := range items {
+		err := checkKey(k)
+		if err == nil {
+			err = s.set(k, value, ttl)
+		}
+		if err != nil && first == nil {
+			first = fmt.Errorf("cachetest: set %q: %w", k, err)
+		}
+	}
+	return first
+}
+
+func (s *Store) Add(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.begin(ctx, key); err != nil {
+		return err
+	}
+	if _, found := s.get(key); found {
+		return cache.ErrNotStored
+	}
+	return s.set(key, value, ttl)
+}
+
+func (s *Store) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.begin(ctx, key); err != nil {
+		return err
+	}
+	delete(s.items, key)
+	return nil
+}
+
+func (s *Store) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.begin(ctx, key); err != nil {
+		return 0, err
+	}
+
+	it, found := s.get(key)
+	if !found {
+		n := max(delta, 0)
+		return n, s.set(key, []byte(strconv.FormatInt(n, 10)), ttl)
+	}
+	n, err := strconv.ParseUint(string(it.value), 10, 64)
+	if err != nil {
+		return 0, errors.New("memcache: client error: cannot increment or decrement non-numeric value")
+	}
+	if delta >= 0 {
+		n += uint64(delta)
+	} else {
+		n -= min(n, uint64(-delta))
+	}
+	it.value = []byte(strconv.FormatUint(n, 10))
+	it.cas = s.casID()
+	s.items[key] = it
+	return int64(n), nil
+}
+
+// GetCAS returns the value stored for key along with its CAS id, for
+// CompareAndSwap.
+func (s *Store) GetCAS(ctx context.Context, key string) ([]byte, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.begin(ctx, key); err != nil {
+		return nil, 0, err
+	}
+	it, found := s.get(key)
+	if !found {
+		return nil, 0, cache.ErrCacheMiss
+	}
+	return clone(it.value), it.cas, nil
+}
+
+// CompareAndSwap stores value for key only if the item has not been
+// written since GetCAS returned cas. It returns memcache.ErrCASConflict if
+// it has, and cache.ErrNotStored if the item no longer exists.
+func (s *Store) CompareAndSwap(ctx context.Context, key string, value []byte, cas uint64, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.begin(ctx, key); err != nil {
+		return err
+	}
+	it, found := s.get(key)
+	if !found {
+		return cache.ErrNotStored
+	}
+	if it.cas != cas {
+		return memcache.ErrCASConflict
+	}
+	return s.set(key, value, ttl)
+}
+
+// begin counts a call and checks what memcache.Client checks before
+// sending a command. s.mu must be held.
+func (s *Store) begin(ctx context.Context, keys ...string) error {
+	s.calls++
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := checkKey(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get returns the item stored for key, dropping it if it has expired.
+// s.mu must be held.
+func (s *Store) get(key string) (item, bool) {
+	it, found := s.items[key]
+	if !found {
+		return item{}, false
+	}
+	if !it.expires.IsZero() && !s.now().Before(it.expires) {
+		delete(s.items, key)
+		return item{}, false
+	}
+	return it, true
+}
+
+// set stores value for key. s.mu must be held.
+func (s *Store) set(key string, value []byte, ttl time.Duration) error {
+	if len(value) > MaxValueLen {
+		return ErrValueTooLarge
+	}
+	s.items[key] = item{value: clone(value), expires: s.expires(ttl), cas: s.casID()}
+	return nil
+}
+
+// expires returns when an item stored with ttl expires, going through the
+// expiration memcache would receive.
+func (s *Store) expires(ttl time.Duration) time.Time {
+	now := s.now()
+	switch {
+	case ttl <= 0:
+		return time.Time{}
+	case ttl > maxRelativeExpiration:
+		// A unix timestamp, precise to the second.
+		return time.Unix(now.Add(ttl).Unix(), 0)
+	}
+	secs := (ttl + time.Second - 1) / time.Second
+	return now.Add(secs * time.Second)
+}
+
+func (s *Store) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+func (s *Store) casID() uint64 {
+	s.nextID++
+	return s.nextID
+}
+
+// checkKey rejects the keys memcache.Client refuses to send.
+func checkKey(key string) error {
+	if len(key) > MaxKeyLen {
+		return memcache.ErrMalformedKey
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return memcache.ErrMalformedKey
+		}
+	}
+	return nil
+}
+
+func clone(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
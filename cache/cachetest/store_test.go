@@ -0,0 +1,156 @@
+package cachetest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CloudStuffTech/go-utils/cache"
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+func TestStore_Expiry(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore()
+
+	s.Set(ctx, "short", []byte("v"), 1500*time.Millisecond) // rounded up to 2s
+	s.Set(ctx, "long", []byte("v"), 60*24*time.Hour)        // a unix timestamp
+	s.Set(ctx, "forever", []byte("v"), 0)
+
+	s.Advance(1500 * time.Millisecond)
+	if _, err := s.Get(ctx, "short"); err != nil {
+		t.Fatalf("short expired before its rounded ttl: %v", err)
+	}
+	s.Advance(time.Second)
+	if _, err := s.Get(ctx, "short"); !errors.Is(err, cache.ErrCacheMiss) {
+		t.Fatalf("short: expected a miss, got %v", err)
+	}
+
+	s.Advance(59 * 24 * time.Hour)
+	if _, err := s.Get(ctx, "long"); err != nil {
+		t.Fatalf("long expired early: %v", err)
+	}
+	s.Advance(24 * time.Hour)
+	if _, err := s.Get(ctx, "long"); !errors.Is(err, cache.ErrCacheMiss) {
+		t.Fatalf("long: expected a miss, got %v", err)
+	}
+	if _, err := s.Get(ctx, "forever"); err != nil {
+		t.Fatalf("forever expired: %v", err)
+	}
+}
+
+func TestStore_Limits(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore()
+
+	for _, key := range []string{strings.Repeat("k", MaxKeyLen+1), "with space", "new\nline"} {
+		if err := s.Set(ctx, key, []byte("v"), 0); !errors.Is(err, memcache.ErrMalformedKey) {
+			t.Errorf("Set(%q): expected ErrMalformedKey, got %v", key, err)
+		}
+	}
+	if err := s.Set(ctx, strings.Repeat("k", MaxKeyLen), []byte("v"), 0); err != nil {
+		t.Errorf("Set with a %d byte key: %v", MaxKeyLen, err)
+	}
+
+	if err := s.Set(ctx, "big", make([]byte, MaxValueLen+1), 0); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("expected ErrValueTooLarge, got %v", err)
+	}
+	err := s.SetMulti(ctx, map[string][]byte{"ok": []byte("v"), "big": make([]byte, MaxValueLen+1)}, 0)
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("SetMulti: expected ErrValueTooLarge, got %v", err)
+	}
+	if _, err := s.Get(ctx, "ok"); err != nil {
+		t.Errorf("SetMulti did not store the valid item: %v", err)
+	}
+}
+
+func TestStore_CAS(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore()
+
+	s.Set(ctx, "k", []byte("a"), 0)
+	_, cas, err := s.GetCAS(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CompareAndSwap(ctx, "k", []byte("b"), cas, 0); err != nil {
+		t.Fatalf("first swap: %v", err)
+	}
+	if err := s.CompareAndSwap(ctx, "k", []byte("c"), cas, 0); !errors.Is(err, memcache.ErrCASConflict) {
+		t.Fatalf("stale swap: expected ErrCASConflict, got %v", err)
+	}
+	s.Delete(ctx, "k")
+	if err := s.CompareAndSwap(ctx, "k", []byte("c"), cas, 0); !errors.Is(err, cache.ErrNotStored) {
+		t.Fatalf("swap after delete: expected ErrNotStored, got %v", err)
+	}
+}
+
+func TestStore_Incr(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore()
+
+	if n, _ := s.Incr(ctx, "n", 5, 0); n != 5 {
+		t.Fatalf("expected 5, got %d", n)
+	}
+	if n, _ := s.Incr(ctx, "n", -10, 0); n != 0 {
+		t.Fatalf("decrementing should stop at 0, got %d", n)
+	}
+	s.Set(ctx, "s", []byte("abc"), 0)
+	if _, err := s.Incr(ctx, "s", 1, 0); err == nil {
+		t.Fatal("expected an error incrementing a non-numeric value")
+	}
+}
+
+func TestStore_Add(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore()
+
+	if err := s.Add(ctx, "k", []byte("1"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(ctx, "k", []byte("2"), time.Minute); !errors.Is(err, cache.ErrNotStored) {
+		t.Fatalf("expected ErrNotStored, got %v", err)
+	}
+	if v, _ := s.Get(ctx, "k"); string(v) != "1" {
+		t.Errorf("expected the first value to be kept, got %q", v)
+	}
+
+	// An expired item is replaced.
+	s.Advance(2 * time.Minute)
+	if err := s.Add(ctx, "k", []byte("3"), 0); err != nil {
+		t.Errorf("expected the expired item to be replaced, got %v", err)
+	}
+}
+
+func TestNewMultiClient_TwoTiers(t *testing.T) {
+	mc, store := NewMultiClient(&cache.Config{Prefix: "test", CacheTime: 1})
+
+	mc.Set("key", "value")
+	calls := store.Calls()
+	if v, found := mc.Get("key"); !found || v != "value" {
+		t.Fatalf("expected a local hit, got %v, %v", v, found)
+	}
+	if store.Calls() != calls {
+		t.Fatal("a local hit reached the shared tier")
+	}
+
+	// Served by the shared tier once evicted from memory.
+	mc.DelFromMemory("key")
+	if v, found := mc.Get("key"); !found || v != "value" {
+		t.Fatalf("expected a remote hit, got %v, %v", v, found)
+	}
+
+	// CacheTime of 1 minute is 36 seconds in memcache.
+	mc.DelFromMemory("key")
+	store.Advance(37 * time.Second)
+	if _, found := mc.Get("key"); found {
+		t.Fatal("expected the remote value to have expired")
+	}
+
+	stats := mc.Stats()
+	if stats.LocalHits != 1 || stats.RemoteHits != 1 || stats.Misses != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,73 @@
+package cache_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/CloudStuffTech/go-utils/cache"
+)
+
+type campaign struct {
+	ID      string            `json:"id"`
+	Budget  float64           `json:"budget"`
+	Active  bool              `json:"active"`
+	Tags    []string          `json:"tags"`
+	Payouts map[string]int64  `json:"payouts"`
+	Parent  *campaign         `json:"parent,omitempty"`
+	Extra   map[string]string `json:"extra,omitempty"`
+}
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	in := campaign{
+		ID:      "c1",
+		Budget:  12.5,
+		Active:  true,
+		Tags:    []string{"cpa", "mobile"},
+		Payouts: map[string]int64{"US": 120, "FR": 80},
+		Parent:  &campaign{ID: "root"},
+	}
+
+	codecs := map[string]cache.Codec[campaign]{
+		"json":    cache.JSONCodec[campaign]{},
+		"gob":     cache.GobCodec[campaign]{},
+		"msgpack": cache.MsgpackCodec[campaign]{},
+	}
+	for name, codec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			data, err := codec.Marshal(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := codec.Unmarshal(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(in, out) {
+				t.Errorf("round trip changed the value:\n got %+v\nwant %+v", out, in)
+			}
+
+			if _, err := codec.Unmarshal([]byte{0xff, 0x00, 0x13}); err == nil {
+				t.Error("expected an error decoding garbage")
+			}
+		})
+	}
+}
+
+// TestMsgpackCodec_JSONTags verifies that msgpack falls back to json tags,
+// so a value encoded by msgpack decodes into a type with only json tags.
+func TestMsgpackCodec_JSONTags(t *testing.T) {
+	type tagged struct {
+		Name string `json:"n"`
+	}
+	type renamed struct {
+		Other string `json:"n"`
+	}
+	data, err := cache.MsgpackCodec[tagged]{}.Marshal(tagged{Name: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := cache.MsgpackCodec[renamed]{}.Unmarshal(data)
+	if err != nil || out.Other != "x" {
+		t.Fatalf("expected the json tag to name the field, got %+v, %v", out, err)
+	}
+}
@@ -0,0 +1,141 @@
+package cache_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CloudStuffTech/go-utils/cache"
+	"github.com/CloudStuffTech/go-utils/cache/cachetest"
+)
+
+func TestMultiClient_IncrementKey(t *testing.T) {
+	mc, _ := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+
+	if n := mc.IncrementKey("n", 5); n != 5 {
+		t.Errorf("expected a new counter at 5, got %d", n)
+	}
+	if n := mc.IncrementKey("n", 2); n != 7 {
+		t.Errorf("expected 7, got %d", n)
+	}
+	if n := mc.DecrementKey("n", 3); n != 4 {
+		t.Errorf("expected 4, got %d", n)
+	}
+	if n := mc.DecrementKey("n", 10); n != 0 {
+		t.Errorf("expected the counter to stop at 0, got %d", n)
+	}
+	if n := mc.DecrementKey("missing", 1); n != 0 {
+		t.Errorf("expected a missing counter to be 0, got %d", n)
+	}
+
+	mc.Set("text", "abc")
+	if n := mc.IncrementKey("text", 1); n != 0 {
+		t.Errorf("expected 0 on error, got %d", n)
+	}
+}
+
+func TestMultiClient_Incr(t *testing.T) {
+	ctx := context.Background()
+	mc, store := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+
+	if n, err := mc.Incr(ctx, "n", 3); err != nil || n != 3 {
+		t.Fatalf("expected 3, got %d, %v", n, err)
+	}
+	if n, err := mc.Decr(ctx, "n", 1); err != nil || n != 2 {
+		t.Fatalf("expected 2, got %d, %v", n, err)
+	}
+
+	mc.Set("text", "abc")
+	if _, err := mc.Incr(ctx, "text", 1); err == nil {
+		t.Error("expected an error incrementing a non-numeric value")
+	}
+
+	// The ttl is given on creation only.
+	mc.IncrWithTTL(ctx, "window", 1, time.Minute)
+	store.Advance(50 * time.Second)
+	mc.IncrWithTTL(ctx, "window", 1, time.Minute)
+	store.Advance(20 * time.Second)
+	if n, _ := mc.IncrWithTTL(ctx, "window", 1, time.Minute); n != 1 {
+		t.Errorf("expected the counter to have expired with its first ttl, got %d", n)
+	}
+}
+
+// TestCounters verifies that increments are added up locally and applied
+// with one Incr per counter.
+func TestCounters(t *testing.T) {
+	ctx := context.Background()
+	mc, store := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+	counters, err := mc.NewCounters(cache.CounterOptions{FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		counters.Add(ctx, "clicks", 1)
+	}
+	counters.Add(ctx, "convs", 2)
+	counters.Add(ctx, "zero", 0)
+	if store.Len() != 0 {
+		t.Fatal("expected the increments to wait for the flush")
+	}
+
+	calls := store.Calls()
+	if err := counters.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := store.Calls() - calls; n != 2 {
+		t.Errorf("expected 2 calls to the shared tier, got %d", n)
+	}
+	if n, _ := mc.Incr(ctx, "clicks", 0); n != 10 {
+		t.Errorf("expected 10 clicks, got %d", n)
+	}
+	if n, _ := mc.Incr(ctx, "convs", 0); n != 2 {
+		t.Errorf("expected 2 convs, got %d", n)
+	}
+
+	// Close applies what is left.
+	counters.Add(ctx, "clicks", 5)
+	if err := counters.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := mc.Incr(ctx, "clicks", 0); n != 15 {
+		t.Errorf("expected 15 clicks after Close, got %d", n)
+	}
+}
+
+func TestCounters_OnError(t *testing.T) {
+	ctx := context.Background()
+	mc, _ := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+	mc.Set("text", "abc")
+
+	var mu sync.Mutex
+	failed := make(map[string]int64)
+	counters, err := mc.NewCounters(cache.CounterOptions{
+		FlushInterval: time.Hour,
+		OnError: func(err error, key string, delta int64) {
+			mu.Lock()
+			failed[key] = delta
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counters.Add(ctx, "text", 1)
+	counters.Add(ctx, "text", 2)
+	counters.Add(ctx, "clicks", 1)
+	if err := counters.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failed) != 1 || failed["text"] != 3 {
+		t.Errorf("expected the 3 increments of text to fail, got %v", failed)
+	}
+	if n, _ := mc.Incr(ctx, "clicks", 0); n != 1 {
+		t.Errorf("expected the other counter to be applied, got %d", n)
+	}
+}
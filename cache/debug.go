@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+)
+
+// DebugHandler method returns an http.Handler to inspect the client on a
+// running instance. Mount it behind authentication, it exposes cached
+// values:
+//
+//	mux.Handle("/debug/cache/", http.StripPrefix("/debug/cache", mc.DebugHandler()))
+//
+// It serves:
+//
+//	GET    /           the Stats of the client, as JSON
+//	GET    /key?key=k  what each tier holds for the key k (without prefix)
+//	DELETE /key?key=k  deletes k from both tiers and from the in-memory tier
+//	                   of the other instances, like Delete; with &local=1,
+//	                   from the in-memory tier of this instance only
+func (cc *MultiClient) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, cc.Stats())
+	})
+	mux.HandleFunc("GET /key", cc.debugLookup)
+	mux.HandleFunc("DELETE /key", cc.debugEvict)
+	return mux
+}
+
+// debugKey is the response of GET /key.
+type debugKey struct {
+	Key string `json:"key"`
+
+	Local      bool        `json:"local"`
+	LocalValue interface{} `json:"localValue,omitempty"`
+
+	Remote bool `json:"remote"`
+	// RemoteValue is the stored value if it is valid UTF-8, RemoteBytes
+	// is its size in any case.
+	RemoteValue string `json:"remoteValue,omitempty"`
+	RemoteBytes int    `json:"remoteBytes,omitempty"`
+	RemoteError string `json:"remoteError,omitempty"`
+}
+
+func (cc *MultiClient) debugLookup(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "missing key parameter", http.StatusBadRequest)
+		return
+	}
+	k := cc.getKeyName(key)
+	res := debugKey{Key: k}
+
+	// Read the tiers directly, so that looking a key up does not count as
+	// a hit or back-fill the in-memory tier.
+	if raw, found := cc.local.Get(k); found {
+		res.Local = true
+		if e, ok := raw.(anyEntry); ok {
+			raw, _, _ = e.unwrap()
+		}
+		if _, err := json.Marshal(raw); err != nil {
+			raw = fmt.Sprintf("%+v", raw)
+		}
+		res.LocalValue = raw
+	}
+	data, err := cc.store.Get(r.Context(), k)
+	switch {
+	case err == nil:
+		res.Remote = true
+		res.RemoteBytes = len(data)
+		if payload, _, ok := decodeEnvelope(data); ok {
+			data = payload
+		}
+		if utf8.Valid(data) {
+			res.RemoteValue = string(data)
+		}
+	case !errors.Is(err, ErrCacheMiss):
+		res.RemoteError = err.Error()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (cc *MultiClient) debugEvict(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "missing key parameter", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("local") == "1" {
+		cc.DelFromMemory(key)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	k := cc.getKeyName(key)
+	cc.local.Delete(k)
+	err := cc.remote.Delete(r.Context(), k)
+	cc.invalidate(r.Context(), k)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
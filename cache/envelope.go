@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// Values stored by Tiered that carry more than the encoded value (a soft
+// expiry, a cached ErrNotFound or tags) are wrapped in an envelope:
+//
+//	[magic 2][flags 1][soft expiry, unix nanos 8][tags][payload]
+//
+// tags is only present with the envelopeTags flag: the number of tags, then
+// for each the length of its name, the name and its version, all varints.
+//
+// The magic bytes cannot start a JSON, gob or msgpack value, so values
+// written without an envelope, e.g. by MultiClient.Set, are still read as
+// they are.
+var envelopeMagic = []byte{0x00, 0xc1}
+
+const (
+	envelopeHeaderLen = 11
+
+	envelopeNegative byte = 1 << 0
+	envelopeTags     byte = 1 << 1
+)
+
+// envelope is what an envelope holds besides the payload.
+type envelope struct {
+	negative   bool
+	softExpiry time.Time
+	tags       []tagVersion
+}
+
+func encodeEnvelope(payload []byte, env envelope) []byte {
+	data := make([]byte, envelopeHeaderLen, envelopeHeaderLen+len(payload))
+	copy(data, envelopeMagic)
+	if env.negative {
+		data[2] |= envelopeNegative
+	}
+	if !env.softExpiry.IsZero() {
+		binary.BigEndian.PutUint64(data[3:], uint64(env.softExpiry.UnixNano()))
+	}
+	if len(env.tags) > 0 {
+		data[2] |= envelopeTags
+		data = binary.AppendUvarint(data, uint64(len(env.tags)))
+		for _, tv := range env.tags {
+			data = binary.AppendUvarint(data, uint64(len(tv.tag)))
+			data = append(data, tv.tag...)
+			data = binary.AppendVarint(data, tv.version)
+		}
+	}
+	return append(data, payload...)
+}
+
+// decodeEnvelope unwraps data. ok is false if data is not an envelope.
+func decodeEnvelope(data []byte) (payload []byte, env envelope, ok bool) {
+	if len(data) < envelopeHeaderLen || !bytes.HasPrefix(data, envelopeMagic) {
+		return nil, envelope{}, false
+	}
+	flags := data[2]
+	env.negative = flags&envelopeNegative != 0
+	if nanos := binary.BigEndian.Uint64(data[3:]); nanos != 0 {
+		env.softExpiry = time.Unix(0, int64(nanos))
+	}
+	data = data[envelopeHeaderLen:]
+
+	if flags&envelopeTags != 0 {
+		count, n := binary.Uvarint(data)
+		if n <= 0 || count > uint64(len(data)) {
+			return nil, envelope{}, false
+		}
+		data = data[n:]
+		env.tags = make([]tagVersion, count)
+		for i := range env.tags {
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return nil, envelope{}, false
+			}
+			env.tags[i].tag = string(data[n : n+int(size)])
+			data = data[n+int(size):]
+
+			version, n := binary.Varint(data)
+			if n <= 0 {
+				return nil, envelope{}, false
+			}
+			env.tags[i].version = version
+			data = data[n:]
+		}
+	}
+	return data, env, true
+}
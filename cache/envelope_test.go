@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEnvelope_RoundTrip(t *testing.T) {
+	soft := time.Unix(1700000000, 123456789)
+	tests := []struct {
+		name    string
+		payload []byte
+		env     envelope
+	}{
+		{"negative", nil, envelope{negative: true}},
+		{"soft expiry", []byte(`{"a":1}`), envelope{softExpiry: soft}},
+		{"both", []byte("x"), envelope{negative: true, softExpiry: soft}},
+		{"tags", []byte("x"), envelope{tags: []tagVersion{{"a", 1}, {"campaigns::queries", -5}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, env, ok := decodeEnvelope(encodeEnvelope(tt.payload, tt.env))
+			if !ok {
+				t.Fatal("not read back as an envelope")
+			}
+			if !bytes.Equal(payload, tt.payload) {
+				t.Errorf("payload: got %q, want %q", payload, tt.payload)
+			}
+			if env.negative != tt.env.negative || !env.softExpiry.Equal(tt.env.softExpiry) || !slices.Equal(env.tags, tt.env.tags) {
+				t.Errorf("envelope: got %+v, want %+v", env, tt.env)
+			}
+		})
+	}
+}
+
+// TestEnvelope_Plain verifies that values written without an envelope are
+// not mistaken for one.
+func TestEnvelope_Plain(t *testing.T) {
+	for _, data := range [][]byte{
+		[]byte(`{"id":"c1","budget":12.5}`),
+		[]byte(`"short"`),
+		nil,
+		{0x00, 0xc1, 0x00}, // the magic, but too short for a header
+	} {
+		if _, _, ok := decodeEnvelope(data); ok {
+			t.Errorf("%q read as an envelope", data)
+		}
+	}
+}
+
+// TestEnvelope_CorruptTags verifies that an envelope whose tags are cut
+// short or hold impossible lengths is rejected rather than read past.
+func TestEnvelope_CorruptTags(t *testing.T) {
+	data := encodeEnvelope(nil, envelope{tags: []tagVersion{{"a", 1}, {"tag", 1 << 40}}})
+	for n := envelopeHeaderLen; n < len(data); n++ {
+		if _, _, ok := decodeEnvelope(data[:n]); ok {
+			t.Errorf("envelope cut at %d of %d bytes read back", n, len(data))
+		}
+	}
+
+	header := encodeEnvelope(nil, envelope{})
+	header[2] |= envelopeTags
+	for name, tags := range map[string][]byte{
+		"no count":          nil,
+		"count over data":   {0xff, 0xff, 0x03},
+		"name over data":    {0x01, 0x10, 'a'},
+		"name length huge":  {0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 'a'},
+		"no version":        {0x01, 0x01, 'a'},
+		"unterminated vint": {0x01, 0x01, 'a', 0x80},
+	} {
+		if _, _, ok := decodeEnvelope(append(slices.Clone(header), tags...)); ok {
+			t.Errorf("%s: read back as an envelope", name)
+		}
+	}
+}
+
+// TestTiered_EnvelopeCodecError verifies that an envelope whose payload the
+// codec cannot decode is reported as such.
+func TestTiered_EnvelopeCodecError(t *testing.T) {
+	tiered := &Tiered[int]{codec: JSONCodec[int]{}}
+	data := encodeEnvelope([]byte("not a number"), envelope{softExpiry: time.Now()})
+	if _, err := tiered.decode(data); err == nil {
+		t.Error("expected a decode error")
+	}
+	e, err := tiered.decode(encodeEnvelope([]byte("42"), envelope{softExpiry: time.Now()}))
+	if err != nil || e.val != 42 || e.softExpiry.IsZero() {
+		t.Errorf("got %+v, %v", e, err)
+	}
+}
@@ -0,0 +1,265 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/CloudStuffTech/go-utils/messaging"
+	"github.com/patrickmn/go-cache"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// InvalidationBus carries invalidation messages between the MultiClients
+// of all instances, so that a key set or deleted on one instance is evicted
+// from the in-process tier of the others.
+//
+// Delivery is best effort: a MultiClient only relies on the bus while it is
+// subscribed, see Config.Bus.
+type InvalidationBus interface {
+	// Publish sends msg to every subscriber, including the sender.
+	Publish(ctx context.Context, msg string) error
+
+	// Subscribe receives messages until ctx is done or the subscription
+	// fails. It calls onReady once messages are being received, then
+	// onMessage for each of them. It returns ctx.Err() or nil once ctx is
+	// done, and the error otherwise.
+	Subscribe(ctx context.Context, onReady func(), onMessage func(msg string)) error
+}
+
+// RedisBus is an InvalidationBus on a Redis pub/sub channel.
+type RedisBus struct {
+	client  goredis.UniversalClient
+	channel string
+}
+
+// NewRedisBus returns an InvalidationBus publishing on the given Redis
+// channel. All the instances sharing a cache must use the same channel
+func NewRedisBus(client goredis.UniversalClient, channel string) *RedisBus {
+	return &RedisBus{client: client, channel: channel}
+}
+
+func (b *RedisBus) Publish(ctx context.Context, msg string) error {
+	return b.client.Publish(ctx, b.channel, msg).Err()
+}
+
+func (b *RedisBus) Subscribe(ctx context.Context, onReady func(), onMessage func(msg string)) error {
+	ps := b.client.Subscribe(ctx, b.channel)
+	defer ps.Close()
+	if _, err := ps.Receive(ctx); err != nil {
+		return err
+	}
+	onReady()
+
+	// ReceiveMessage does not watch ctx; closing the subscription makes
+	// it return.
+	stop := context.AfterFunc(ctx, func() { ps.Close() })
+	defer stop()
+
+	for {
+		msg, err := ps.ReceiveMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Unlike the Channel API, this does not reconnect silently,
+			// so the MultiClient learns that messages may have been lost.
+			return err
+		}
+		onMessage(msg.Payload)
+	}
+}
+
+// PubSubBus is an InvalidationBus on a Google Cloud Pub/Sub topic.
+type PubSubBus struct {
+	topic *messaging.Message
+	sub   *messaging.Message
+}
+
+// NewPubSubBus returns an InvalidationBus publishing through topic (see
+// messaging.NewPubSub) and receiving from sub (see
+// messaging.NewSubscription). Every instance needs a subscription of its own
+// on the topic, otherwise each message only reaches one of them
+func NewPubSubBus(topic, sub *messaging.Message) *PubSubBus {
+	return &PubSubBus{topic: topic, sub: sub}
+}
+
+func (b *PubSubBus) Publish(ctx context.Context, msg string) error {
+	return b.topic.SendBatch(ctx, [][]byte{[]byte(msg)})[0]
+}
+
+// Subscribe receives from the subscription, and calls onReady once a probe
+// message it published on the topic has come back through it, which shows
+// that the subscription is receiving. The probe is published again every
+// pubSubProbeInterval until then. Probes are not passed to onMessage.
+func (b *PubSubBus) Subscribe(ctx context.Context, onReady func(), onMessage func(msg string)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	probe := pubSubProbe + newInstanceID()
+	ready := make(chan struct{})
+	go func() {
+		for {
+			// A failed probe is simply published again.
+			b.Publish(ctx, probe)
+			select {
+			case <-ready:
+				return
+			case <-ctx.Done():
+				return
+			case <-time.After(pubSubProbeInterval):
+			}
+		}
+	}()
+
+	var readyOnce sync.Once
+	return b.sub.ReceiveContext(ctx, func(_ context.Context, m *pubsub.Message) {
+		m.Ack()
+		msg := string(m.Data)
+		if !strings.HasPrefix(msg, pubSubProbe) {
+			onMessage(msg)
+			return
+		}
+		// The probes of other instances are ignored.
+		if msg == probe {
+			readyOnce.Do(func() {
+				close(ready)
+				onReady()
+			})
+		}
+	})
+}
+
+// pubSubProbe starts the probe messages of PubSubBus, which cannot be
+// mistaken for invalidations since those start with an instance id.
+const (
+	pubSubProbe         = "\x00probe "
+	pubSubProbeInterval = 5 * time.Second
+)
+
+// Defaults of Config.BusFallbackTTL and Config.BusTimeout.
+const (
+	defaultBusFallbackTTL = 30 * time.Second
+	defaultBusTimeout     = time.Second
+)
+
+// invalidation is the state of a MultiClient using an InvalidationBus.
+type invalidation struct {
+	bus         InvalidationBus
+	fallbackTTL time.Duration
+	timeout     time.Duration
+	onError     func(err error)
+
+	// id tells the messages of this client apart from the others, so
+	// that its own writes do not evict what it has just stored.
+	id   string
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func newInstanceID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// startInvalidation subscribes cc to opts.Bus until Close is called.
+func (cc *MultiClient) startInvalidation(opts *Config) {
+	ctx, stop := context.WithCancel(context.Background())
+	cc.inv = &invalidation{
+		bus:         opts.Bus,
+		fallbackTTL: opts.BusFallbackTTL,
+		timeout:     opts.BusTimeout,
+		onError:     opts.OnBusError,
+		id:          newInstanceID(),
+		stop:        stop,
+		done:        make(chan struct{}),
+	}
+	if cc.inv.fallbackTTL <= 0 {
+		cc.inv.fallbackTTL = defaultBusFallbackTTL
+	}
+	if cc.inv.timeout <= 0 {
+		cc.inv.timeout = defaultBusTimeout
+	}
+	if cc.inv.onError == nil {
+		cc.inv.onError = func(error) {}
+	}
+	go cc.listen(ctx)
+}
+
+// listen keeps cc subscribed to the bus, resubscribing with a growing delay
+// whenever the subscription fails.
+//
+// Invalidations published while cc is not subscribed are lost. So the
+// in-process tier is flushed whenever the subscription goes up or down, and
+// while it is down entries are kept for at most the fallback TTL.
+func (cc *MultiClient) listen(ctx context.Context) {
+	defer close(cc.inv.done)
+
+	const maxDelay = 30 * time.Second
+	delay := time.Second
+	for {
+		// Either way the subscription is gone and has to be made again.
+		err := cc.inv.bus.Subscribe(ctx, func() {
+			cc.local.Flush()
+			cc.busUp.Store(true)
+			delay = time.Second
+		}, cc.onInvalidation)
+		if ctx.Err() == nil {
+			if err == nil {
+				err = errors.New("subscription ended")
+			}
+			cc.inv.onError(fmt.Errorf("cache: invalidation bus: subscribe: %w", err))
+		}
+		if cc.busUp.Swap(false) {
+			cc.local.Flush()
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay = min(2*delay, maxDelay)
+	}
+}
+
+func (cc *MultiClient) onInvalidation(msg string) {
+	id, k, ok := strings.Cut(msg, " ")
+	if !ok || id == cc.inv.id {
+		return
+	}
+	cc.local.Delete(k)
+}
+
+// invalidate tells the other instances to evict the given key, which has
+// already been prefixed. It waits for at most the bus timeout, even if ctx
+// is done since the write it follows has been made. Failures only go to
+// OnBusError: the other instances will serve their copy until it expires.
+func (cc *MultiClient) invalidate(ctx context.Context, k string) {
+	if cc.inv == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cc.inv.timeout)
+	defer cancel()
+	if err := cc.inv.bus.Publish(ctx, cc.inv.id+" "+k); err != nil {
+		cc.inv.onError(fmt.Errorf("cache: invalidation bus: publish %q: %w", k, err))
+	}
+}
+
+// setLocal stores the value in the in-process tier, for at most the
+// fallback TTL while the invalidation bus is down.
+func (cc *MultiClient) setLocal(k string, val interface{}, ttl time.Duration) {
+	if cc.inv != nil && !cc.busUp.Load() {
+		if ttl == cache.DefaultExpiration || ttl > cc.inv.fallbackTTL {
+			ttl = cc.inv.fallbackTTL
+		}
+	}
+	cc.local.Set(k, val, ttl)
+}
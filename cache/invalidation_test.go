@@ -0,0 +1,176 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CloudStuffTech/go-utils/cache"
+	"github.com/CloudStuffTech/go-utils/cache/cachetest"
+)
+
+// memBus is an in-memory InvalidationBus. Publish fails with err if set,
+// and blocks until its context is done if block is set.
+type memBus struct {
+	mu    sync.Mutex
+	subs  map[*func(string)]struct{}
+	err   error
+	block bool
+
+	ready chan struct{}
+}
+
+func newMemBus() *memBus {
+	return &memBus{subs: make(map[*func(string)]struct{}), ready: make(chan struct{}, 16)}
+}
+
+func (b *memBus) Publish(ctx context.Context, msg string) error {
+	b.mu.Lock()
+	err, block := b.err, b.block
+	subs := make([]func(string), 0, len(b.subs))
+	for f := range b.subs {
+		subs = append(subs, *f)
+	}
+	b.mu.Unlock()
+
+	if block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	for _, f := range subs {
+		f(msg)
+	}
+	return nil
+}
+
+func (b *memBus) Subscribe(ctx context.Context, onReady func(), onMessage func(msg string)) error {
+	b.mu.Lock()
+	b.subs[&onMessage] = struct{}{}
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.subs, &onMessage)
+		b.mu.Unlock()
+	}()
+
+	onReady()
+	b.ready <- struct{}{}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// waitReady waits for n subscriptions to be ready.
+func (b *memBus) waitReady(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-b.ready:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the subscriptions")
+		}
+	}
+}
+
+// newInstances returns two MultiClients sharing store and bus, like the
+// clients of two instances of a service.
+func newInstances(t *testing.T, bus *memBus, opts cache.Config) (*cache.MultiClient, *cache.MultiClient, *cachetest.Store) {
+	t.Helper()
+	store := cachetest.NewStore()
+	opts.Prefix = "test"
+	opts.CacheTime = 60
+	opts.Remote = store
+	opts.Bus = bus
+	a := cache.NewMultiClientV2(&opts)
+	b := cache.NewMultiClientV2(&opts)
+	t.Cleanup(a.Close)
+	t.Cleanup(b.Close)
+	bus.waitReady(t, 2)
+	return a, b, store
+}
+
+// getString reads key from mc, keeping it in memory cache.
+func getString(mc *cache.MultiClient, key string) (string, bool) {
+	v, found := mc.GetWithSet(key, new(string))
+	if !found {
+		return "", false
+	}
+	return *v.(*string), true
+}
+
+func TestInvalidation(t *testing.T) {
+	a, b, store := newInstances(t, newMemBus(), cache.Config{})
+
+	a.Set("k", "v1")
+	if v, found := getString(b, "k"); !found || v != "v1" {
+		t.Fatalf("expected v1, got %q, %v", v, found)
+	}
+	calls := store.Calls()
+	getString(b, "k")
+	if store.Calls() != calls {
+		t.Fatal("expected the other instance to keep a local copy")
+	}
+
+	// The write of a evicts the copy of b, but not its own.
+	a.Set("k", "v2")
+	calls = store.Calls()
+	if v, found := a.Get("k"); !found || v != "v2" || store.Calls() != calls {
+		t.Errorf("expected a local hit on v2, got %v, %v", v, found)
+	}
+	if v, found := getString(b, "k"); !found || v != "v2" {
+		t.Errorf("expected v2 after the invalidation, got %q, %v", v, found)
+	}
+
+	a.Delete("k")
+	if v, found := getString(b, "k"); found {
+		t.Errorf("expected a miss after Delete, got %q", v)
+	}
+}
+
+// TestInvalidation_PublishError verifies that failed invalidations are
+// reported, and do not hold the writes up for longer than BusTimeout.
+func TestInvalidation_PublishError(t *testing.T) {
+	bus := newMemBus()
+	var mu sync.Mutex
+	var errs []error
+	a, _, _ := newInstances(t, bus, cache.Config{
+		BusTimeout: 50 * time.Millisecond,
+		OnBusError: func(err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		},
+	})
+
+	errDown := errors.New("bus down")
+	bus.mu.Lock()
+	bus.err = errDown
+	bus.mu.Unlock()
+	a.Set("k", "v")
+
+	bus.mu.Lock()
+	bus.block = true
+	bus.mu.Unlock()
+	start := time.Now()
+	a.Delete("k")
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected Delete to give up after BusTimeout, took %v", d)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+	if !errors.Is(errs[0], errDown) || !strings.Contains(errs[0].Error(), "test_k") {
+		t.Errorf("expected the publish error for test_k, got %v", errs[0])
+	}
+	if !errors.Is(errs[1], context.DeadlineExceeded) {
+		t.Errorf("expected a timeout, got %v", errs[1])
+	}
+}
@@ -0,0 +1,428 @@
+package cache
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// localStore is the in-process tier: go-cache by default, a BoundedStore
+// when Config.MaxEntries or Config.MaxBytes is set. ttl follows go-cache:
+// cache.DefaultExpiration for the store default, cache.NoExpiration for no
+// expiry.
+type localStore interface {
+	Get(k string) (interface{}, bool)
+	Set(k string, val interface{}, ttl time.Duration)
+	Delete(k string)
+	Flush()
+}
+
+// localStats returns the stats of store.
+func localStats(store localStore) LocalStats {
+	switch s := store.(type) {
+	case *BoundedStore:
+		return s.Stats()
+	case *cache.Cache:
+		return LocalStats{Entries: s.ItemCount()}
+	}
+	return LocalStats{}
+}
+
+// newLocalStore returns the in-process tier described by opts, and the
+// go-cache instance behind it if that is what it is.
+func newLocalStore(opts *Config) (localStore, *cache.Cache) {
+	var cacheTime = time.Duration(opts.CacheTime) * time.Minute
+	if opts.MaxEntries > 0 || opts.MaxBytes > 0 {
+		return NewBoundedStore(BoundedOptions{
+			MaxEntries: opts.MaxEntries,
+			MaxBytes:   opts.MaxBytes,
+			Eviction:   opts.Eviction,
+			DefaultTTL: cacheTime,
+		}), nil
+	}
+	c := cache.New(cacheTime, 5*time.Minute)
+	return c, c
+}
+
+// EvictionPolicy decides which entries a BoundedStore keeps once it is full.
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the least recently used entries to make room for
+	// every new one.
+	EvictLRU EvictionPolicy = iota
+
+	// EvictTinyLFU only lets a new entry in if its key has been read more
+	// often lately than each of the least recently used entries it would
+	// push out, which are evicted then. Reads are counted by Get, hits and
+	// misses alike, so a key loaded after a miss counts once. A burst of
+	// keys read once does not push out the hot ones.
+	EvictTinyLFU
+)
+
+// BoundedOptions holds the settings of a BoundedStore. At least one of
+// MaxEntries and MaxBytes must be set.
+type BoundedOptions struct {
+	// MaxEntries caps the number of entries. 0 means no cap.
+	MaxEntries int
+
+	// MaxBytes caps the approximate memory used by the entries: the size
+	// of keys and values, as reported by Sizer, plus a fixed overhead per
+	// entry. 0 means no cap.
+	MaxBytes int64
+
+	// Eviction is the policy applied once a cap is reached.
+	// Default: EvictLRU.
+	Eviction EvictionPolicy
+
+	// DefaultTTL is the ttl of entries set with cache.DefaultExpiration.
+	// Default: no expiry.
+	DefaultTTL time.Duration
+
+	// Sizer estimates the size in bytes of a value. It is only called
+	// when MaxBytes is set.
+	// Default: an estimate of the memory the value points to, walking
+	// through pointers, slices, maps and structs.
+	Sizer func(val interface{}) int64
+}
+
+// LocalStats reports the activity of the in-process tier. Only Entries is
+// tracked by the default, unbounded, tier.
+type LocalStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+
+	// Rejections counts the values that were not stored: larger than
+	// MaxBytes on their own, or turned down by EvictTinyLFU.
+	Rejections uint64
+
+	// Bytes is only tracked when MaxBytes is set.
+	Entries int
+	Bytes   int64
+}
+
+// entryOverhead is the approximate memory used by a BoundedStore entry on
+// top of its key and value: list element, map slot and bookkeeping.
+const entryOverhead = 128
+
+// BoundedStore is an in-process cache holding at most MaxEntries entries
+// and MaxBytes bytes. It is safe for concurrent use. Expired entries are
+// dropped when they are read or evicted.
+type BoundedStore struct {
+	opts  BoundedOptions
+	sizer func(val interface{}) int64
+
+	mu     sync.Mutex
+	ll     *list.List // front is most recently used
+	items  map[string]*list.Element
+	bytes  int64
+	stats  LocalStats
+	sketch *frequencySketch
+}
+
+type boundedEntry struct {
+	key     string
+	val     interface{}
+	size    int64
+	expires time.Time
+}
+
+// NewBoundedStore returns an empty BoundedStore
+func NewBoundedStore(opts BoundedOptions) *BoundedStore {
+	s := &BoundedStore{
+		opts:  opts,
+		sizer: opts.Sizer,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+	if s.sizer == nil {
+		s.sizer = approxSize
+	}
+	if opts.Eviction == EvictTinyLFU {
+		width := opts.MaxEntries
+		if width <= 0 {
+			width = int(opts.MaxBytes / 1024)
+		}
+		s.sketch = newFrequencySketch(max(width, 64))
+	}
+	return s
+}
+
+// Get returns the value stored for k, if it has not expired.
+func (s *BoundedStore) Get(k string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sketch != nil {
+		s.sketch.increment(k)
+	}
+	el, found := s.items[k]
+	if !found {
+		s.stats.Misses++
+		return nil, false
+	}
+	e := el.Value.(*boundedEntry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		s.remove(el)
+		s.stats.Misses++
+		return nil, false
+	}
+	s.ll.MoveToFront(el)
+	s.stats.Hits++
+	return e.val, true
+}
+
+// Set stores val for k, evicting entries if needed. With EvictTinyLFU, a
+// new key may be turned down, in which case Set is a no-op.
+func (s *BoundedStore) Set(k string, val interface{}, ttl time.Duration) {
+	e := &boundedEntry{key: k, val: val, size: int64(len(k)) + entryOverhead}
+	if s.opts.MaxBytes > 0 {
+		e.size += s.sizer(val)
+	}
+	if ttl == cache.DefaultExpiration {
+		ttl = s.opts.DefaultTTL
+	}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.opts.MaxBytes > 0 && e.size > s.opts.MaxBytes {
+		// Too large to ever fit; the previous value, if any, is outdated.
+		if el, found := s.items[k]; found {
+			s.remove(el)
+		}
+		s.stats.Rejections++
+		return
+	}
+	if el, found := s.items[k]; found {
+		s.bytes += e.size - el.Value.(*boundedEntry).size
+		el.Value = e
+		s.ll.MoveToFront(el)
+		s.evict(nil)
+		return
+	}
+
+	if s.sketch != nil {
+		if !s.admit(k, e.size) {
+			s.stats.Rejections++
+			return
+		}
+	}
+	el := s.ll.PushFront(e)
+	s.items[k] = el
+	s.bytes += e.size
+	s.evict(el)
+}
+
+// Delete removes k.
+func (s *BoundedStore) Delete(k string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, found := s.items[k]; found {
+		s.remove(el)
+	}
+}
+
+// Flush removes every entry.
+func (s *BoundedStore) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ll.Init()
+	clear(s.items)
+	s.bytes = 0
+}
+
+// Stats returns the counters of the store since it was created.
+func (s *BoundedStore) Stats() LocalStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Entries = len(s.items)
+	stats.Bytes = s.bytes
+	return stats
+}
+
+// over reports whether entries entries of bytes bytes go over a cap.
+func (s *BoundedStore) over(entries int, bytes int64) bool {
+	return (s.opts.MaxEntries > 0 && entries > s.opts.MaxEntries) ||
+		(s.opts.MaxBytes > 0 && bytes > s.opts.MaxBytes)
+}
+
+// admit reports whether the new key k, of size bytes, has been seen more
+// often than every entry evict would remove to make room for it.
+func (s *BoundedStore) admit(k string, size int64) bool {
+	freq := s.sketch.estimate(k)
+	entries, bytes := len(s.items)+1, s.bytes+size
+	for el := s.ll.Back(); el != nil && s.over(entries, bytes); el = el.Prev() {
+		victim := el.Value.(*boundedEntry)
+		if freq <= s.sketch.estimate(victim.key) {
+			return false
+		}
+		entries--
+		bytes -= victim.size
+	}
+	return true
+}
+
+// evict removes the least recently used entries, except keep, until the
+// store is within its caps.
+func (s *BoundedStore) evict(keep *list.Element) {
+	for s.over(len(s.items), s.bytes) {
+		el := s.ll.Back()
+		if el == keep {
+			el = el.Prev()
+		}
+		if el == nil {
+			return
+		}
+		s.remove(el)
+		s.stats.Evictions++
+	}
+}
+
+func (s *BoundedStore) remove(el *list.Element) {
+	e := s.ll.Remove(el).(*boundedEntry)
+	delete(s.items, e.key)
+	s.bytes -= e.size
+}
+
+// frequencySketch is a count-min sketch of 4 rows of saturating 8-bit
+// counters, estimating how often each key was read recently. Counters are
+// halved every 10 * width increments, so that old popularity fades.
+type frequencySketch struct {
+	rows      [4][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+func newFrequencySketch(width int) *frequencySketch {
+	size := 1
+	for size < width {
+		size <<= 1
+	}
+	f := &frequencySketch{mask: uint64(size - 1), resetAt: 10 * size}
+	for i := range f.rows {
+		f.rows[i] = make([]uint8, size)
+	}
+	return f
+}
+
+// seeds are odd constants mixing the key hash into a different slot of each
+// row.
+var seeds = [4]uint64{0x9e3779b97f4a7c15, 0xbf58476d1ce4e5b9, 0x94d049bb133111eb, 0xd6e8feb86659fd93}
+
+func (f *frequencySketch) slot(h uint64, row int) uint64 {
+	h *= seeds[row]
+	return (h ^ h>>32) & f.mask
+}
+
+func (f *frequencySketch) increment(k string) {
+	h := hashKey(k)
+	for i := range f.rows {
+		if c := &f.rows[i][f.slot(h, i)]; *c < 255 {
+			*c++
+		}
+	}
+	f.additions++
+	if f.additions >= f.resetAt {
+		for i := range f.rows {
+			for j := range f.rows[i] {
+				f.rows[i][j] >>= 1
+			}
+		}
+		f.additions /= 2
+	}
+}
+
+func (f *frequencySketch) estimate(k string) uint8 {
+	h := hashKey(k)
+	est := uint8(255)
+	for i := range f.rows {
+		est = min(est, f.rows[i][f.slot(h, i)])
+	}
+	return est
+}
+
+// hashKey is FNV-1a.
+func hashKey(k string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(k); i++ {
+		h ^= uint64(k[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// approxSize estimates the memory held by val. Pointers seen twice are
+// counted once.
+func approxSize(val interface{}) int64 {
+	if val == nil {
+		return 0
+	}
+	seen := make(map[uintptr]bool)
+	return sizeOf(reflect.ValueOf(val), seen)
+}
+
+func sizeOf(v reflect.Value, seen map[uintptr]bool) int64 {
+	switch v.Kind() {
+	case reflect.String:
+		return int64(v.Type().Size()) + int64(v.Len())
+	case reflect.Pointer:
+		if v.IsNil() || seen[v.Pointer()] {
+			return int64(v.Type().Size())
+		}
+		seen[v.Pointer()] = true
+		return int64(v.Type().Size()) + sizeOf(v.Elem(), seen)
+	case reflect.Interface:
+		if v.IsNil() {
+			return int64(v.Type().Size())
+		}
+		return int64(v.Type().Size()) + sizeOf(v.Elem(), seen)
+	case reflect.Slice:
+		if v.IsNil() || seen[v.Pointer()] {
+			return int64(v.Type().Size())
+		}
+		seen[v.Pointer()] = true
+		n := int64(v.Type().Size())
+		if elem := v.Type().Elem(); elem.Kind() <= reflect.Complex128 {
+			return n + int64(v.Cap())*int64(elem.Size())
+		}
+		for i := range v.Len() {
+			n += sizeOf(v.Index(i), seen)
+		}
+		return n
+	case reflect.Array:
+		n := int64(0)
+		for i := range v.Len() {
+			n += sizeOf(v.Index(i), seen)
+		}
+		return n
+	case reflect.Map:
+		if v.IsNil() || seen[v.Pointer()] {
+			return int64(v.Type().Size())
+		}
+		seen[v.Pointer()] = true
+		n := int64(v.Type().Size())
+		iter := v.MapRange()
+		for iter.Next() {
+			n += sizeOf(iter.Key(), seen) + sizeOf(iter.Value(), seen)
+		}
+		return n
+	case reflect.Struct:
+		n := int64(0)
+		for i := range v.NumField() {
+			n += sizeOf(v.Field(i), seen)
+		}
+		return n
+	default:
+		return int64(v.Type().Size())
+	}
+}
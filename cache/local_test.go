@@ -0,0 +1,164 @@
+package cache_test
+
+import (
+	"testing"
+
+	"github.com/CloudStuffTech/go-utils/cache"
+	gocache "github.com/patrickmn/go-cache"
+)
+
+// intSize makes int values weigh their value in bytes.
+func intSize(val interface{}) int64 {
+	return int64(val.(int))
+}
+
+func TestBoundedStore_LRU(t *testing.T) {
+	s := cache.NewBoundedStore(cache.BoundedOptions{MaxEntries: 3})
+	s.Set("a", 1, gocache.DefaultExpiration)
+	s.Set("b", 2, gocache.DefaultExpiration)
+	s.Set("c", 3, gocache.DefaultExpiration)
+	s.Get("a")
+	s.Set("d", 4, gocache.DefaultExpiration)
+
+	if _, found := s.Get("b"); found {
+		t.Error("expected b, the least recently used, to be evicted")
+	}
+	for _, k := range []string{"a", "c", "d"} {
+		if _, found := s.Get(k); !found {
+			t.Errorf("expected %s to be kept", k)
+		}
+	}
+	if stats := s.Stats(); stats.Entries != 3 || stats.Evictions != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBoundedStore_MaxBytes(t *testing.T) {
+	// Each entry takes its value, its key and 128 bytes of overhead.
+	s := cache.NewBoundedStore(cache.BoundedOptions{MaxBytes: 3 * 229, Sizer: intSize})
+	s.Set("a", 100, gocache.DefaultExpiration)
+	s.Set("b", 100, gocache.DefaultExpiration)
+	s.Set("c", 100, gocache.DefaultExpiration)
+	s.Set("d", 200, gocache.DefaultExpiration)
+
+	for k, want := range map[string]bool{"a": false, "b": false, "c": true, "d": true} {
+		if _, found := s.Get(k); found != want {
+			t.Errorf("expected %s found to be %v", k, want)
+		}
+	}
+	if stats := s.Stats(); stats.Bytes != 229+329 {
+		t.Errorf("expected %d bytes, got %d", 229+329, stats.Bytes)
+	}
+
+	// A value that cannot fit replaces nothing, and drops the old value.
+	s.Set("c", 1000, gocache.DefaultExpiration)
+	if _, found := s.Get("c"); found {
+		t.Error("expected the outdated value of c to be dropped")
+	}
+	if stats := s.Stats(); stats.Rejections != 1 {
+		t.Errorf("expected 1 rejection, got %d", stats.Rejections)
+	}
+}
+
+// TestBoundedStore_NoSizer verifies that values are not sized without
+// MaxBytes.
+func TestBoundedStore_NoSizer(t *testing.T) {
+	s := cache.NewBoundedStore(cache.BoundedOptions{
+		MaxEntries: 1,
+		Sizer:      func(interface{}) int64 { panic("sized") },
+	})
+	s.Set("a", 1, gocache.DefaultExpiration)
+	s.Set("b", 2, gocache.DefaultExpiration)
+	if _, found := s.Get("b"); !found {
+		t.Error("expected b to be stored")
+	}
+}
+
+func TestBoundedStore_TinyLFU(t *testing.T) {
+	s := cache.NewBoundedStore(cache.BoundedOptions{MaxEntries: 2, Eviction: cache.EvictTinyLFU})
+	s.Set("a", 1, gocache.DefaultExpiration)
+	s.Set("b", 2, gocache.DefaultExpiration)
+	for i := 0; i < 5; i++ {
+		s.Get("a")
+		s.Get("b")
+	}
+
+	// Seen once, c does not push out the hot keys.
+	s.Set("c", 3, gocache.DefaultExpiration)
+	if _, found := s.Get("c"); found {
+		t.Error("expected c to be turned down")
+	}
+	if stats := s.Stats(); stats.Rejections != 1 || stats.Evictions != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// Once hotter than the least recently used entry, it replaces it.
+	for i := 0; i < 10; i++ {
+		s.Get("c")
+	}
+	s.Set("c", 3, gocache.DefaultExpiration)
+	if _, found := s.Get("c"); !found {
+		t.Error("expected c to be let in")
+	}
+	if _, found := s.Get("a"); found {
+		t.Error("expected a, the least recently used, to be evicted")
+	}
+}
+
+// TestBoundedStore_TinyLFUCountsReads verifies that only reads make a key
+// hot: writing a key over and over does not get it let in.
+func TestBoundedStore_TinyLFUCountsReads(t *testing.T) {
+	s := cache.NewBoundedStore(cache.BoundedOptions{MaxEntries: 1, Eviction: cache.EvictTinyLFU})
+	s.Set("a", 1, gocache.DefaultExpiration)
+	s.Get("a")
+	s.Get("a")
+
+	for i := 0; i < 5; i++ {
+		s.Set("b", 2, gocache.DefaultExpiration)
+	}
+	if stats := s.Stats(); stats.Rejections != 5 || stats.Evictions != 0 {
+		t.Fatalf("expected b to be turned down every time, got %+v", stats)
+	}
+
+	// A miss and the Set that follows it count once.
+	for i := 0; i < 2; i++ {
+		s.Get("b")
+	}
+	s.Set("b", 2, gocache.DefaultExpiration)
+	if _, found := s.Get("a"); !found {
+		t.Error("expected b, as hot as a, not to replace it")
+	}
+}
+
+// TestBoundedStore_TinyLFUEveryVictim verifies that an entry that needs
+// several entries evicted is compared with each of them.
+func TestBoundedStore_TinyLFUEveryVictim(t *testing.T) {
+	s := cache.NewBoundedStore(cache.BoundedOptions{MaxBytes: 3 * 229, Sizer: intSize, Eviction: cache.EvictTinyLFU})
+	s.Set("c", 100, gocache.DefaultExpiration) // cold
+	s.Set("h", 100, gocache.DefaultExpiration) // hot
+	for i := 0; i < 5; i++ {
+		s.Get("h")
+	}
+	s.Set("x", 100, gocache.DefaultExpiration)
+
+	// n needs both c and h out, and is not hotter than h.
+	s.Get("n")
+	s.Get("n")
+	s.Set("n", 200, gocache.DefaultExpiration)
+	if stats := s.Stats(); stats.Rejections != 1 || stats.Evictions != 0 || stats.Entries != 3 {
+		t.Fatalf("expected n to be turned down without evictions, got %+v", stats)
+	}
+
+	for i := 0; i < 5; i++ {
+		s.Get("n")
+	}
+	s.Set("n", 200, gocache.DefaultExpiration)
+	if stats := s.Stats(); stats.Evictions != 2 || stats.Entries != 2 {
+		t.Fatalf("expected n to replace c and h, got %+v", stats)
+	}
+	for k, want := range map[string]bool{"c": false, "h": false, "x": true, "n": true} {
+		if _, found := s.Get(k); found != want {
+			t.Errorf("expected %s found to be %v", k, want)
+		}
+	}
+}
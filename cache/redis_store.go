@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// RedisStore is a RemoteStore backed by Redis, through any go-redis client
+// (single node, cluster or sentinel failover).
+type RedisStore struct {
+	client goredis.UniversalClient
+}
+
+// NewRedisStore returns a RemoteStore using the given go-redis client, e.g.
+// the one returned by redis.Client.GetUniversalConn
+func NewRedisStore(client goredis.UniversalClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// incrScript increments the counter, creating it with the ttl ARGV[2] if it
+// does not exist. The ttl of an existing counter is left alone, even if it
+// has none.
+var incrScript = goredis.NewScript(`
+if tonumber(ARGV[2]) > 0 then
+	redis.call('SET', KEYS[1], 0, 'NX', 'PX', ARGV[2])
+end
+return redis.call('INCRBY', KEYS[1], ARGV[1])
+`)
+
+func (s *RedisStore) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := s.client.Get(ctx, key).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return nil, ErrCacheMiss
+	}
+	return value, err
+}
+
+func (s *RedisStore) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+	// MGET fails in a cluster when the keys live in different slots, so
+	// fetch them one by one in a pipeline, which go-redis splits by node.
+	cmds := make([]*goredis.StringCmd, len(keys))
+	// Pipelined only returns the first error, which may be a mere
+	// goredis.Nil, so the commands are checked one by one.
+	s.client.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for i, k := range keys {
+			cmds[i] = pipe.Get(ctx, k)
+		}
+		return nil
+	})
+	for i, cmd := range cmds {
+		value, err := cmd.Bytes()
+		switch {
+		case err == nil:
+			values[keys[i]] = value
+		case !errors.Is(err, goredis.Nil):
+			return nil, fmt.Errorf("cache: get %q: %w", keys[i], err)
+		}
+	}
+	return values, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, key, value, max(ttl, 0)).Err()
+}
+
+func (s *RedisStore) SetMulti(ctx context.Context, items map[string][]byte, ttl time.Duration) error {
+	if len(items) == 0 {
+		return nil
+	}
+	// Same as GetMulti: MSET has no ttl and is limited to one slot.
+	_, err := s.client.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for k, value := range items {
+			pipe.Set(ctx, k, value, max(ttl, 0))
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStore) Add(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	stored, err := s.client.SetNX(ctx, key, value, max(ttl, 0)).Result()
+	if err == nil && !stored {
+		return ErrNotStored
+	}
+	return err
+}
+
+func (s *RedisStore) Delete(ctx context.Context, key string) error {
+	return s.client.Del(ctx, key).Err()
+}
+
+func (s *RedisStore) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	return incrScript.Run(ctx, s.client, []string{key}, delta, ttl.Milliseconds()).Int64()
+}
@@ -0,0 +1,173 @@
+package cache_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CloudStuffTech/go-utils/cache"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// fakeRedis is a minimal RESP server with GET, SET (and its NX option),
+// SETNX and DEL over plain strings. Reading the key "wrongtype" fails like reading a hash would.
+type fakeRedis struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func newRedisStore(t *testing.T) (*cache.RedisStore, *fakeRedis) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	f := &fakeRedis{values: make(map[string]string)}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c)
+		}
+	}()
+
+	client := goredis.NewClient(&goredis.Options{Addr: l.Addr().String(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	return cache.NewRedisStore(client), f
+}
+
+func (f *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+		args := make([]string, n)
+		for i := range args {
+			if _, err := r.ReadString('\n'); err != nil { // $len
+				return
+			}
+			arg, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			args[i] = strings.TrimSuffix(arg, "\r\n")
+		}
+		if n > 0 {
+			c.Write([]byte(f.reply(args)))
+		}
+	}
+}
+
+func (f *fakeRedis) reply(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		if args[1] == "wrongtype" {
+			return "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+		}
+		v, found := f.values[args[1]]
+		if !found {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "SET", "SETNX":
+		nx := strings.ToUpper(args[0]) == "SETNX"
+		for _, opt := range args[3:] {
+			nx = nx || strings.ToUpper(opt) == "NX"
+		}
+		if _, found := f.values[args[1]]; found && nx {
+			if strings.ToUpper(args[0]) == "SETNX" {
+				return ":0\r\n"
+			}
+			return "$-1\r\n"
+		}
+		f.values[args[1]] = args[2]
+		if strings.ToUpper(args[0]) == "SETNX" {
+			return ":1\r\n"
+		}
+		return "+OK\r\n"
+	case "DEL":
+		_, found := f.values[args[1]]
+		delete(f.values, args[1])
+		if found {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func TestRedisStore_GetSetDelete(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newRedisStore(t)
+
+	if _, err := store.Get(ctx, "k"); !errors.Is(err, cache.ErrCacheMiss) {
+		t.Fatalf("expected ErrCacheMiss, got %v", err)
+	}
+	if err := store.Set(ctx, "k", []byte("v"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := store.Get(ctx, "k"); err != nil || string(v) != "v" {
+		t.Fatalf("expected v, got %q, %v", v, err)
+	}
+	if err := store.Delete(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "k"); err != nil {
+		t.Errorf("deleting a missing key: %v", err)
+	}
+}
+
+func TestRedisStore_GetMulti(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newRedisStore(t)
+	store.SetMulti(ctx, map[string][]byte{"a": []byte("1"), "c": []byte("3")}, 0)
+
+	values, err := store.GetMulti(ctx, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || string(values["a"]) != "1" || string(values["c"]) != "3" {
+		t.Errorf("expected a and c only, got %q", values)
+	}
+
+	// A failed command must not be mistaken for a miss, even after one.
+	_, err = store.GetMulti(ctx, []string{"b", "wrongtype"})
+	if err == nil || !strings.Contains(err.Error(), "WRONGTYPE") {
+		t.Errorf("expected the WRONGTYPE error, got %v", err)
+	}
+}
+
+func TestRedisStore_Add(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newRedisStore(t)
+
+	for _, ttl := range []time.Duration{0, time.Minute} {
+		key := fmt.Sprint("k", ttl)
+		if err := store.Add(ctx, key, []byte("1"), ttl); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Add(ctx, key, []byte("2"), ttl); !errors.Is(err, cache.ErrNotStored) {
+			t.Errorf("ttl %v: expected ErrNotStored, got %v", ttl, err)
+		}
+		if v, _ := store.Get(ctx, key); string(v) != "1" {
+			t.Errorf("ttl %v: expected the first value to be kept, got %q", ttl, v)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/CloudStuffTech/go-utils/internal/histogram"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const instrumentationName = "github.com/CloudStuffTech/go-utils/cache"
+
+// Stats is a point-in-time snapshot of a MultiClient's counters, as returned
+// by MultiClient.Stats. All counters are cumulative since the client was
+// created.
+type Stats struct {
+	// Prefix is the key prefix of the client.
+	Prefix string
+
+	// LocalHits and RemoteHits count the lookups answered by the
+	// in-memory tier and by the shared tier; Misses those answered by
+	// neither. A multi-key lookup counts once per key.
+	LocalHits  uint64
+	RemoteHits uint64
+	Misses     uint64
+
+	// DecodeErrors counts the values found in the shared tier that could
+	// not be decoded. They are not counted as hits or misses.
+	DecodeErrors uint64
+
+	// RemoteErrors counts the calls to the shared tier that failed, cache
+	// misses and keys already added aside.
+	RemoteErrors uint64
+
+	// RemoteLatency is the distribution of the durations of the calls to
+	// the shared tier, in milliseconds, by operation: "get", "get_multi",
+	// "set", "set_multi", "add", "delete" and "incr".
+	RemoteLatency map[string]Histogram
+
+	// Local reports the activity of the in-memory tier.
+	Local LocalStats
+}
+
+// Histogram is a snapshot of a fixed-bucket histogram. Counts[i] is the
+// number of observations v with Bounds[i-1] < v <= Bounds[i]; the last
+// entry of Counts counts observations above the last bound.
+type Histogram = histogram.Histogram
+
+var latencyBounds = []float64{0.5, 1, 2, 5, 10, 20, 50, 100, 250, 500, 1000}
+
+// remoteOp is an operation of RemoteStore, for latency metrics.
+type remoteOp int
+
+const (
+	opGet remoteOp = iota
+	opGetMulti
+	opSet
+	opSetMulti
+	opAdd
+	opDelete
+	opIncr
+
+	numOps
+)
+
+func (op remoteOp) String() string {
+	switch op {
+	case opGet:
+		return "get"
+	case opGetMulti:
+		return "get_multi"
+	case opSet:
+		return "set"
+	case opSetMulti:
+		return "set_multi"
+	case opAdd:
+		return "add"
+	case opDelete:
+		return "delete"
+	case opIncr:
+		return "incr"
+	}
+	return "unknown"
+}
+
+// observer records the counters behind Stats and, when configured, exports
+// them through OpenTelemetry.
+type observer struct {
+	localHits    atomic.Uint64
+	remoteHits   atomic.Uint64
+	misses       atomic.Uint64
+	decodeErrors atomic.Uint64
+	remoteErrors atomic.Uint64
+	latency      [numOps]*histogram.Recorder
+
+	// The OpenTelemetry instruments are nil unless Config.MeterProvider
+	// is set.
+	otelLatency  metric.Float64Histogram
+	opAttrs      [numOps]metric.RecordOption
+	registration metric.Registration
+}
+
+// newObserver builds the observer of a client. local reports the stats of
+// the in-memory tier for the exported gauges.
+func newObserver(prefix string, mp metric.MeterProvider, local func() LocalStats) (*observer, error) {
+	o := &observer{}
+	for op := range numOps {
+		o.latency[op] = histogram.New(latencyBounds)
+	}
+	if mp == nil {
+		return o, nil
+	}
+
+	meter := mp.Meter(instrumentationName)
+	prefixAttr := attribute.String("cache.prefix", prefix)
+	for op := range numOps {
+		o.opAttrs[op] = metric.WithAttributes(prefixAttr, attribute.String("cache.operation", op.String()))
+	}
+
+	var err error
+	if o.otelLatency, err = meter.Float64Histogram("cache.remote.duration",
+		metric.WithDescription("Duration of the calls to the shared tier."),
+		metric.WithUnit("ms"),
+		metric.WithExplicitBucketBoundaries(latencyBounds...),
+	); err != nil {
+		return nil, err
+	}
+
+	lookups, err := meter.Int64ObservableCounter("cache.lookups",
+		metric.WithDescription("Key lookups, by result: local_hit, remote_hit or miss."), metric.WithUnit("{lookup}"))
+	if err != nil {
+		return nil, err
+	}
+	decodeErrors, err := meter.Int64ObservableCounter("cache.decode.errors",
+		metric.WithDescription("Values of the shared tier that could not be decoded."), metric.WithUnit("{value}"))
+	if err != nil {
+		return nil, err
+	}
+	remoteErrors, err := meter.Int64ObservableCounter("cache.remote.errors",
+		metric.WithDescription("Failed calls to the shared tier."), metric.WithUnit("{call}"))
+	if err != nil {
+		return nil, err
+	}
+	evictions, err := meter.Int64ObservableCounter("cache.local.evictions",
+		metric.WithDescription("Entries evicted from the bounded in-memory tier."), metric.WithUnit("{entry}"))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := meter.Int64ObservableGauge("cache.local.entries",
+		metric.WithDescription("Entries in the in-memory tier."), metric.WithUnit("{entry}"))
+	if err != nil {
+		return nil, err
+	}
+	size, err := meter.Int64ObservableGauge("cache.local.size",
+		metric.WithDescription("Approximate size of the bounded in-memory tier."), metric.WithUnit("By"))
+	if err != nil {
+		return nil, err
+	}
+
+	obsAttrs := metric.WithAttributes(prefixAttr)
+	resultAttrs := func(result string) metric.ObserveOption {
+		return metric.WithAttributes(prefixAttr, attribute.String("cache.result", result))
+	}
+	localHitAttrs, remoteHitAttrs, missAttrs := resultAttrs("local_hit"), resultAttrs("remote_hit"), resultAttrs("miss")
+
+	o.registration, err = meter.RegisterCallback(func(_ context.Context, ob metric.Observer) error {
+		ob.ObserveInt64(lookups, int64(o.localHits.Load()), localHitAttrs)
+		ob.ObserveInt64(lookups, int64(o.remoteHits.Load()), remoteHitAttrs)
+		ob.ObserveInt64(lookups, int64(o.misses.Load()), missAttrs)
+		ob.ObserveInt64(decodeErrors, int64(o.decodeErrors.Load()), obsAttrs)
+		ob.ObserveInt64(remoteErrors, int64(o.remoteErrors.Load()), obsAttrs)
+		stats := local()
+		ob.ObserveInt64(evictions, int64(stats.Evictions), obsAttrs)
+		ob.ObserveInt64(entries, int64(stats.Entries), obsAttrs)
+		ob.ObserveInt64(size, stats.Bytes, obsAttrs)
+		return nil
+	}, lookups, decodeErrors, remoteErrors, evictions, entries, size)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// close stops exporting the observable metrics. Stats keeps working.
+func (o *observer) close() {
+	if o.registration != nil {
+		o.registration.Unregister()
+	}
+}
+
+func (o *observer) localHit()    { o.localHits.Add(1) }
+func (o *observer) remoteHit()   { o.remoteHits.Add(1) }
+func (o *observer) miss()        { o.misses.Add(1) }
+func (o *observer) decodeError() { o.decodeErrors.Add(1) }
+
+// remoteCall records a call to the shared tier that started at start and
+// returned err.
+func (o *observer) remoteCall(ctx context.Context, op remoteOp, start time.Time, err error) {
+	ms := float64(time.Since(start)) / float64(time.Millisecond)
+	o.latency[op].Observe(ms)
+	if o.otelLatency != nil {
+		o.otelLatency.Record(ctx, ms, o.opAttrs[op])
+	}
+	if err != nil && !errors.Is(err, ErrCacheMiss) && !errors.Is(err, ErrNotStored) {
+		o.remoteErrors.Add(1)
+	}
+}
+
+func (o *observer) snapshot() Stats {
+	s := Stats{
+		LocalHits:     o.localHits.Load(),
+		RemoteHits:    o.remoteHits.Load(),
+		Misses:        o.misses.Load(),
+		DecodeErrors:  o.decodeErrors.Load(),
+		RemoteErrors:  o.remoteErrors.Load(),
+		RemoteLatency: make(map[string]Histogram, numOps),
+	}
+	for op := range numOps {
+		s.RemoteLatency[op.String()] = o.latency[op].Snapshot()
+	}
+	return s
+}
+
+// observedStore times the calls to a RemoteStore.
+type observedStore struct {
+	store RemoteStore
+	obs   *observer
+}
+
+func (s observedStore) Get(ctx context.Context, key string) ([]byte, error) {
+	start := time.Now()
+	value, err := s.store.Get(ctx, key)
+	s.obs.remoteCall(ctx, opGet, start, err)
+	return value, err
+}
+
+func (s observedStore) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	start := time.Now()
+	values, err := s.store.GetMulti(ctx, keys)
+	s.obs.remoteCall(ctx, opGetMulti, start, err)
+	return values, err
+}
+
+func (s observedStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	start := time.Now()
+	err := s.store.Set(ctx, key, value, ttl)
+	s.obs.remoteCall(ctx, opSet, start, err)
+	return err
+}
+
+func (s observedStore) SetMulti(ctx context.Context, items map[string][]byte, ttl time.Duration) error {
+	start := time.Now()
+	err := s.store.SetMulti(ctx, items, ttl)
+	s.obs.remoteCall(ctx, opSetMulti, start, err)
+	return err
+}
+
+func (s observedStore) Add(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	start := time.Now()
+	err := s.store.Add(ctx, key, value, ttl)
+	s.obs.remoteCall(ctx, opAdd, start, err)
+	return err
+}
+
+func (s observedStore) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := s.store.Delete(ctx, key)
+	s.obs.remoteCall(ctx, opDelete, start, err)
+	return err
+}
+
+func (s observedStore) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	start := time.Now()
+	n, err := s.store.Incr(ctx, key, delta, ttl)
+	s.obs.remoteCall(ctx, opIncr, start, err)
+	return n, err
+}
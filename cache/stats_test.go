@@ -0,0 +1,112 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/CloudStuffTech/go-utils/cache"
+	"github.com/CloudStuffTech/go-utils/cache/cachetest"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+func TestMultiClient_Stats(t *testing.T) {
+	mc, store := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+
+	mc.Set("k", "v")
+	mc.Get("k") // local hit
+	mc.DelFromMemory("k")
+	mc.Get("k")       // remote hit
+	mc.Get("missing") // miss
+	store.Set(context.Background(), "test_bad", []byte("{not json"), 0)
+	mc.Get("bad")       // decode error
+	mc.Get("has space") // malformed key
+
+	s := mc.Stats()
+	if s.Prefix != "test" {
+		t.Errorf("expected the prefix, got %q", s.Prefix)
+	}
+	if s.LocalHits != 1 || s.RemoteHits != 1 || s.Misses != 2 || s.DecodeErrors != 1 || s.RemoteErrors != 1 {
+		t.Errorf("unexpected counters %+v", s)
+	}
+	if n := s.RemoteLatency["get"].Count; n != 4 {
+		t.Errorf("expected 4 timed gets, got %d", n)
+	}
+	if n := s.RemoteLatency["set"].Count; n != 1 {
+		t.Errorf("expected 1 timed set, got %d", n)
+	}
+	if s.Local.Entries != 0 {
+		t.Errorf("expected an empty in-memory tier, got %d entries", s.Local.Entries)
+	}
+}
+
+// fakeMeterProvider hands out a meter that fails to register callbacks
+// with err, or keeps track of the registration otherwise.
+type fakeMeterProvider struct {
+	noop.MeterProvider
+	meter *fakeMeter
+}
+
+func (p fakeMeterProvider) Meter(string, ...metric.MeterOption) metric.Meter {
+	return p.meter
+}
+
+type fakeMeter struct {
+	noop.Meter
+	err          error
+	registered   bool
+	unregistered bool
+}
+
+func (m *fakeMeter) RegisterCallback(metric.Callback, ...metric.Observable) (metric.Registration, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	m.registered = true
+	return fakeRegistration{meter: m}, nil
+}
+
+type fakeRegistration struct {
+	noop.Registration
+	meter *fakeMeter
+}
+
+func (r fakeRegistration) Unregister() error {
+	r.meter.unregistered = true
+	return nil
+}
+
+func TestMultiClient_CloseUnregistersMetrics(t *testing.T) {
+	meter := &fakeMeter{}
+	mc, _ := cachetest.NewMultiClient(&cache.Config{Prefix: "test", MeterProvider: fakeMeterProvider{meter: meter}})
+	if !meter.registered {
+		t.Fatal("expected the metrics to be registered")
+	}
+	mc.Close()
+	if !meter.unregistered {
+		t.Error("expected Close to unregister the metrics")
+	}
+}
+
+type errorHandler func(err error)
+
+func (h errorHandler) Handle(err error) { h(err) }
+
+func TestMultiClient_MetricsError(t *testing.T) {
+	var handled error
+	defer otel.SetErrorHandler(otel.GetErrorHandler())
+	otel.SetErrorHandler(errorHandler(func(err error) { handled = err }))
+
+	errRegister := errors.New("register failed")
+	mc, _ := cachetest.NewMultiClient(&cache.Config{Prefix: "test", MeterProvider: fakeMeterProvider{meter: &fakeMeter{err: errRegister}}})
+	if !errors.Is(handled, errRegister) {
+		t.Errorf("expected the error to reach the OpenTelemetry error handler, got %v", handled)
+	}
+
+	mc.Set("k", "v")
+	if n := mc.Stats().RemoteLatency["set"].Count; n != 1 {
+		t.Errorf("expected Stats to keep working, got %d sets", n)
+	}
+}
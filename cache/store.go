@@ -0,0 +1,199 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// ErrCacheMiss is returned by a RemoteStore when the key does not exist.
+var ErrCacheMiss = errors.New("cache: miss")
+
+// ErrNotStored is returned by RemoteStore.Add when the key already exists.
+var ErrNotStored = errors.New("cache: not stored")
+
+// RemoteStore is the shared tier behind a MultiClient: a key/value store
+// reachable by every instance, such as memcache or Redis.
+//
+// A ttl of zero or less means that the value does not expire.
+type RemoteStore interface {
+	// Get returns the value stored for key, or ErrCacheMiss.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// GetMulti returns the values stored for the given keys. Keys that do
+	// not exist are left out of the map.
+	GetMulti(ctx context.Context, keys []string) (map[string][]byte, error)
+
+	// Set stores value for key.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// SetMulti stores every value of items under its key, all with the
+	// same ttl. On error, some of the values may have been stored.
+	SetMulti(ctx context.Context, items map[string][]byte, ttl time.Duration) error
+
+	// Add stores value for key only if the key does not exist, and
+	// returns ErrNotStored otherwise.
+	Add(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// Delete removes key. Deleting a key that does not exist is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+
+	// Incr atomically adds delta to the counter stored at key and returns
+	// the new value. If the key does not exist, it is created with the
+	// value delta and the given ttl; the ttl of an existing counter is
+	// left alone.
+	Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+}
+
+// MemcacheStore is a RemoteStore backed by memcache.
+//
+// memcache counters are unsigned: decrementing below zero leaves the
+// counter at 0. The client has no context support, so contexts are only
+// checked before each call; use memcache.Client.Timeout to bound calls.
+type MemcacheStore struct {
+	client *memcache.Client
+}
+
+// NewMemcacheStore returns a RemoteStore using the given memcache client
+func NewMemcacheStore(client *memcache.Client) *MemcacheStore {
+	return &MemcacheStore{client: client}
+}
+
+func (s *MemcacheStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	item, err := s.client.Get(key)
+	if err != nil {
+		return nil, memcacheErr(err)
+	}
+	return item.Value, nil
+}
+
+func (s *MemcacheStore) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	items, err := s.client.GetMulti(keys)
+	if err != nil {
+		return nil, memcacheErr(err)
+	}
+	values := make(map[string][]byte, len(items))
+	for k, item := range items {
+		values[k] = item.Value
+	}
+	return values, nil
+}
+
+func (s *MemcacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.client.Set(&memcache.Item{
+		Key:        key,
+		Value:      value,
+		Expiration: expiration(ttl),
+	})
+}
+
+// SetMulti sets the items one after the other, as memcache has no
+// multi-set command. It carries on past failures and returns the first one.
+func (s *MemcacheStore) SetMulti(ctx context.Context, items map[string][]byte, ttl time.Duration) error {
+	var first error
+	for k, value := range items {
+		if err := s.Set(ctx, k, value, ttl); err != nil && first == nil {
+			first = fmt.Errorf("cache: set %q: %w", k, err)
+		}
+	}
+	return first
+}
+
+func (s *MemcacheStore) Add(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := s.client.Add(&memcache.Item{Key: key, Value: value, Expiration: expiration(ttl)})
+	if errors.Is(err, memcache.ErrNotStored) {
+		return ErrNotStored
+	}
+	return err
+}
+
+func (s *MemcacheStore) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := s.client.Delete(key)
+	if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+		return err
+	}
+	return nil
+}
+
+func (s *MemcacheStore) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	// Try the common case first. If the counter does not exist, Add
+	// creates it unless another instance got there first, in which case
+	// incrementing again is safe.
+	for range 2 {
+		n, err := s.incr(key, delta)
+		if !errors.Is(err, memcache.ErrCacheMiss) {
+			return n, memcacheErr(err)
+		}
+		err = s.client.Add(&memcache.Item{
+			Key:        key,
+			Value:      []byte(fmt.Sprint(max(delta, 0))),
+			Expiration: expiration(ttl),
+		})
+		if err == nil {
+			return max(delta, 0), nil
+		}
+		if !errors.Is(err, memcache.ErrNotStored) {
+			return 0, err
+		}
+	}
+	return 0, fmt.Errorf("cache: incr %q: counter keeps disappearing", key)
+}
+
+func (s *MemcacheStore) incr(key string, delta int64) (int64, error) {
+	var n uint64
+	var err error
+	if delta >= 0 {
+		n, err = s.client.Increment(key, uint64(delta))
+	} else {
+		n, err = s.client.Decrement(key, uint64(-delta))
+	}
+	return int64(n), err
+}
+
+// memcacheErr maps memcache.ErrCacheMiss to ErrCacheMiss.
+func memcacheErr(err error) error {
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return ErrCacheMiss
+	}
+	return err
+}
+
+// maxRelativeExpiration is the longest expiration memcache takes as a
+// number of seconds; larger values are read as a unix timestamp.
+const maxRelativeExpiration = 30 * 24 * time.Hour
+
+// expiration converts ttl to a memcache expiration. A ttl under a second is
+// rounded up, as 0 means that the item never expires, which is what a ttl
+// of zero or less maps to.
+func expiration(ttl time.Duration) int32 {
+	if ttl <= 0 {
+		return 0
+	}
+	if ttl > maxRelativeExpiration {
+		return int32(time.Now().Add(ttl).Unix())
+	}
+	secs := int32((ttl + time.Second - 1) / time.Second)
+	return max(secs, 1)
+}
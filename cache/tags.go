@@ -0,0 +1,229 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// Tags let a single call invalidate every value derived from the same data,
+// e.g. all the cached query results over a collection, without knowing
+// their keys.
+//
+// Each tag has a version, stored in the shared tier. A tagged value is
+// stored along with the versions its tags had at the time, and is treated as
+// a miss once any of them has changed. InvalidateTag only bumps the version,
+// so its cost does not depend on how many values carry the tag.
+//
+// Versions are only cached in the in-memory tier along with an
+// InvalidationBus, which evicts them on every instance when they change.
+// Without one, each lookup of a tagged value reads the versions of its tags
+// from the shared tier. A version evicted from the shared tier is recreated
+// with a fresh value, which invalidates its values too.
+
+// tagVersion is the version a tag had when a value was stored.
+type tagVersion struct {
+	tag     string
+	version int64
+}
+
+// tagKey returns the key of the version of tag, before prefixing.
+func tagKey(tag string) string {
+	return "_tag:" + tag
+}
+
+// sortedTags returns the distinct tags, sorted.
+func sortedTags(tags []string) []string {
+	tags = slices.Clone(tags)
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// tagVersions returns the current versions of the tags, sorted by tag.
+// Missing versions are created.
+func (cc *MultiClient) tagVersions(ctx context.Context, tags []string) ([]tagVersion, error) {
+	versions := make([]tagVersion, 0, len(tags))
+	var missing, missingKeys []string
+	for _, tag := range sortedTags(tags) {
+		k := cc.getKeyName(tagKey(tag))
+		if v, found := cc.local.Get(k); found && cc.inv != nil {
+			if version, ok := v.(int64); ok {
+				versions = append(versions, tagVersion{tag: tag, version: version})
+				continue
+			}
+		}
+		missing = append(missing, tag)
+		missingKeys = append(missingKeys, k)
+	}
+	if len(missing) == 0 {
+		return versions, nil
+	}
+
+	data, err := cc.remote.GetMulti(ctx, missingKeys)
+	if err != nil {
+		return nil, fmt.Errorf("cache: get tag versions: %w", err)
+	}
+	for i, k := range missingKeys {
+		version, err := parseVersion(data[k])
+		if err != nil {
+			if version, err = cc.createTagVersion(ctx, k); err != nil {
+				return nil, err
+			}
+		}
+		if cc.inv != nil {
+			cc.setLocal(k, version, cache.DefaultExpiration)
+		}
+		versions = append(versions, tagVersion{tag: missing[i], version: version})
+	}
+	slices.SortFunc(versions, func(a, b tagVersion) int { return strings.Compare(a.tag, b.tag) })
+	return versions, nil
+}
+
+// createTagVersion creates the missing version stored at k, which was either
+// never set or evicted. It starts from the clock so that it cannot match the
+// versions stored with older values. If another instance creates it at the
+// same time, the version that instance stored is returned.
+func (cc *MultiClient) createTagVersion(ctx context.Context, k string) (int64, error) {
+	version := time.Now().UnixNano()
+	err := cc.remote.Add(ctx, k, []byte(strconv.FormatInt(version, 10)), 0)
+	if errors.Is(err, ErrNotStored) {
+		var data []byte
+		if data, err = cc.remote.Get(ctx, k); err == nil {
+			version, err = parseVersion(data)
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cache: create tag version: %w", err)
+	}
+	return version, nil
+}
+
+func parseVersion(data []byte) (int64, error) {
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// tagsValid reports whether the tags of a value still have the versions it
+// was stored with. Values whose versions cannot be checked are not valid.
+func (cc *MultiClient) tagsValid(ctx context.Context, stored []tagVersion) bool {
+	if len(stored) == 0 {
+		return true
+	}
+	tags := make([]string, len(stored))
+	for i, tv := range stored {
+		tags[i] = tv.tag
+	}
+	current, err := cc.tagVersions(ctx, tags)
+	return err == nil && slices.Equal(current, stored)
+}
+
+// SetWithTags method will set the object in both memory cache and the shared
+// tier like Set, until any of the tags is invalidated with InvalidateTag.
+// Tags follow the same rules as keys
+func (cc *MultiClient) SetWithTags(key string, val interface{}, tags ...string) {
+	NewTiered[interface{}](cc, TieredOptions[interface{}]{}).SetWithTags(context.Background(), key, val, tags...)
+}
+
+// InvalidateTag method will make every value stored with the tag a miss, on
+// every instance sharing the cache. Only the version of the tag is updated,
+// so it takes two round trips whatever the number of values
+func (cc *MultiClient) InvalidateTag(ctx context.Context, tag string) error {
+	k := cc.getKeyName(tagKey(tag))
+	// Incr alone would recreate an evicted version from 1, which older
+	// values may have been stored with; it is seeded from the clock first.
+	err := cc.remote.Add(ctx, k, []byte(strconv.FormatInt(time.Now().UnixNano(), 10)), 0)
+	if err == nil || errors.Is(err, ErrNotStored) {
+		_, err = cc.remote.Incr(ctx, k, 1, 0)
+	}
+	cc.local.Delete(k)
+	cc.invalidate(ctx, k)
+	if err != nil {
+		return fmt.Errorf("cache: invalidate tag %q: %w", tag, err)
+	}
+	return nil
+}
+
+// localValue returns the value stored in memory cache for k, unwrapping
+// entries stored by Tiered. Invalidated and negative entries are misses.
+func (cc *MultiClient) localValue(k string) (interface{}, bool) {
+	raw, found := cc.local.Get(k)
+	if !found {
+		return nil, false
+	}
+	e, ok := raw.(anyEntry)
+	if !ok {
+		cc.obs.localHit()
+		return raw, true
+	}
+	val, negative, tags := e.unwrap()
+	if negative || !cc.tagsValid(context.Background(), tags) {
+		return nil, false
+	}
+	cc.obs.localHit()
+	return val, true
+}
+
+// remoteValue returns the value stored in the shared tier for k, without
+// its envelope if it has one. Invalidated and negative entries are misses,
+// and are counted as such; the caller counts the hit once it has decoded
+// the value.
+func (cc *MultiClient) remoteValue(k string) ([]byte, error) {
+	data, err := cc.remote.Get(context.Background(), k)
+	if err != nil {
+		cc.obs.miss()
+		return nil, err
+	}
+	payload, env, ok := decodeEnvelope(data)
+	if !ok {
+		return data, nil
+	}
+	if env.negative || !cc.tagsValid(context.Background(), env.tags) {
+		cc.obs.miss()
+		return nil, ErrCacheMiss
+	}
+	return payload, nil
+}
+
+// SetWithTags method will set the object in memory cache until any of the
+// tags is invalidated with InvalidateTag
+func (cc *Client) SetWithTags(key string, val interface{}, tags ...string) {
+	e := entry[interface{}]{val: val, tags: cc.tagVersions(tags)}
+	cc.local.Set(cc.getKeyName(key), e, cache.DefaultExpiration)
+}
+
+// InvalidateTag method will make every object stored with the tag a miss
+func (cc *Client) InvalidateTag(tag string) {
+	cc.tagMu.Lock()
+	defer cc.tagMu.Unlock()
+
+	k := cc.getKeyName(tagKey(tag))
+	version := time.Now().UnixNano()
+	if v, found := cc.local.Get(k); found {
+		version = max(version, v.(int64)+1)
+	}
+	cc.local.Set(k, version, cache.NoExpiration)
+}
+
+// tagVersions returns the current versions of the tags, creating the missing
+// ones from the clock so that an evicted version cannot match older values.
+func (cc *Client) tagVersions(tags []string) []tagVersion {
+	cc.tagMu.Lock()
+	defer cc.tagMu.Unlock()
+
+	versions := make([]tagVersion, 0, len(tags))
+	for _, tag := range sortedTags(tags) {
+		k := cc.getKeyName(tagKey(tag))
+		v, found := cc.local.Get(k)
+		if !found {
+			v = time.Now().UnixNano()
+			cc.local.Set(k, v, cache.NoExpiration)
+		}
+		versions = append(versions, tagVersion{tag: tag, version: v.(int64)})
+	}
+	return versions
+}
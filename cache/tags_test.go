@@ -0,0 +1,138 @@
+package cache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CloudStuffTech/go-utils/cache"
+	"github.com/CloudStuffTech/go-utils/cache/cachetest"
+)
+
+func TestInvalidateTag(t *testing.T) {
+	ctx := context.Background()
+	mc, _ := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+	campaigns := cache.NewTiered[campaign](mc, cache.TieredOptions[campaign]{})
+
+	campaigns.SetWithTags(ctx, "c1", campaign{ID: "c1"}, "campaigns")
+	campaigns.Set(ctx, "c2", campaign{ID: "c2"})
+	if _, found, _ := campaigns.Get(ctx, "c1"); !found {
+		t.Fatal("expected a hit before InvalidateTag")
+	}
+
+	if err := mc.InvalidateTag(ctx, "campaigns"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := campaigns.Get(ctx, "c1"); found {
+		t.Error("expected a local miss after InvalidateTag")
+	}
+	mc.DelFromMemory("c1")
+	if _, found, _ := campaigns.Get(ctx, "c1"); found {
+		t.Error("expected a remote miss after InvalidateTag")
+	}
+	if _, found, _ := campaigns.Get(ctx, "c2"); !found {
+		t.Error("expected the untagged value to be kept")
+	}
+
+	// Values stored after the invalidation carry the new version.
+	campaigns.SetWithTags(ctx, "c1", campaign{ID: "c1"}, "campaigns")
+	if _, found, _ := campaigns.Get(ctx, "c1"); !found {
+		t.Error("expected a hit on the value stored after InvalidateTag")
+	}
+}
+
+// TestInvalidateTag_OtherInstance verifies that, without an invalidation
+// bus, a tag invalidated on one instance also invalidates the copies the
+// others keep in memory.
+func TestInvalidateTag_OtherInstance(t *testing.T) {
+	ctx := context.Background()
+	store := cachetest.NewStore()
+	a := cache.NewMultiClientV2(&cache.Config{Prefix: "test", CacheTime: 60, Remote: store})
+	b := cache.NewMultiClientV2(&cache.Config{Prefix: "test", CacheTime: 60, Remote: store})
+	onA := cache.NewTiered[campaign](a, cache.TieredOptions[campaign]{})
+	onB := cache.NewTiered[campaign](b, cache.TieredOptions[campaign]{})
+
+	onA.SetWithTags(ctx, "c1", campaign{ID: "c1"}, "campaigns")
+	if _, found, _ := onB.Get(ctx, "c1"); !found {
+		t.Fatal("expected a hit")
+	}
+	if err := a.InvalidateTag(ctx, "campaigns"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := onB.Get(ctx, "c1"); found {
+		t.Error("expected the other instance to miss after InvalidateTag")
+	}
+}
+
+// TestTagVersion_Evicted verifies that a tag version missing from the
+// shared tier is recreated without matching the values stored before.
+func TestTagVersion_Evicted(t *testing.T) {
+	ctx := context.Background()
+	mc, store := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+	campaigns := cache.NewTiered[campaign](mc, cache.TieredOptions[campaign]{})
+
+	campaigns.SetWithTags(ctx, "c1", campaign{ID: "c1"}, "campaigns")
+	time.Sleep(time.Millisecond) // the clock seeds the new version
+	store.Delete(ctx, "test__tag:campaigns")
+	if _, found, _ := campaigns.Get(ctx, "c1"); found {
+		t.Error("expected a miss once the version is gone")
+	}
+}
+
+// racingStore is a Store on which another instance creates every key right
+// before Add does, with the value 42.
+type racingStore struct {
+	*cachetest.Store
+}
+
+func (s racingStore) Add(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.Store.Add(ctx, key, []byte("42"), ttl)
+	return s.Store.Add(ctx, key, value, ttl)
+}
+
+// TestTagVersion_Race verifies that instances creating a tag version at
+// the same time end up with the same version.
+func TestTagVersion_Race(t *testing.T) {
+	ctx := context.Background()
+	store := racingStore{cachetest.NewStore()}
+	mc := cache.NewMultiClientV2(&cache.Config{Prefix: "test", CacheTime: 60, Remote: store})
+	campaigns := cache.NewTiered[campaign](mc, cache.TieredOptions[campaign]{})
+
+	if err := campaigns.SetWithTags(ctx, "c1", campaign{ID: "c1"}, "campaigns"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := store.Get(ctx, "test__tag:campaigns"); err != nil || string(v) != "42" {
+		t.Fatalf("expected the version of the other instance, got %q, %v", v, err)
+	}
+	mc.DelFromMemory("c1")
+	if _, found, _ := campaigns.Get(ctx, "c1"); !found {
+		t.Error("expected the value to carry the version of the other instance")
+	}
+}
+
+// TestInvalidateTag_Evicted verifies that invalidating a tag whose version
+// was evicted from the shared tier does not bring back older values.
+func TestInvalidateTag_Evicted(t *testing.T) {
+	ctx := context.Background()
+	mc, store := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+	campaigns := cache.NewTiered[campaign](mc, cache.TieredOptions[campaign]{})
+
+	store.Delete(ctx, "test__tag:campaigns")
+	if err := mc.InvalidateTag(ctx, "campaigns"); err != nil {
+		t.Fatal(err)
+	}
+	campaigns.SetWithTags(ctx, "c1", campaign{ID: "stale"}, "campaigns")
+
+	time.Sleep(time.Millisecond) // the clock seeds the new version
+	store.Delete(ctx, "test__tag:campaigns")
+	if err := mc.InvalidateTag(ctx, "campaigns"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := campaigns.Get(ctx, "c1"); found {
+		t.Error("expected a local miss after InvalidateTag")
+	}
+	mc.DelFromMemory("c1")
+	if _, found, _ := campaigns.Get(ctx, "c1"); found {
+		t.Error("expected a remote miss after InvalidateTag")
+	}
+}
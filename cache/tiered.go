@@ -0,0 +1,527 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// ErrNotFound is returned by a GetOrLoad loader to report that the value
+// does not exist at the source. GetOrLoad caches that outcome for
+// NegativeTTL and returns ErrNotFound to its callers.
+var ErrNotFound = errors.New("cache: not found")
+
+// TieredOptions holds the settings of a Tiered cache. Every field is
+// optional.
+type TieredOptions[V any] struct {
+	// Codec encodes values for the remote tier. Default: JSONCodec.
+	Codec Codec[V]
+
+	// LocalTTL is how long a value stays in the in-process tier after a
+	// Set or after being read back from the remote tier. Keeping it short
+	// bounds how long an instance serves a value another instance has
+	// changed.
+	// Default: the default expiration of the MultiClient.
+	LocalTTL time.Duration
+
+	// RemoteTTL is how long a value stays in the remote tier. It is the
+	// hard TTL of values stored by GetOrLoad: once it has passed, the next
+	// call waits for the loader.
+	// Default: the expiration of the MultiClient.
+	RemoteTTL time.Duration
+
+	// SoftTTL, if set, is how long a value stored by GetOrLoad is
+	// considered fresh. Past it, GetOrLoad keeps returning the stale value
+	// while one goroutine reloads it in the background.
+	// Default: 0 (values stay fresh until they expire).
+	SoftTTL time.Duration
+
+	// NegativeTTL is how long GetOrLoad remembers that the loader returned
+	// ErrNotFound. Keep it shorter than RemoteTTL so that newly created
+	// values show up quickly.
+	// Default: 0 (not found results are not cached).
+	NegativeTTL time.Duration
+
+	// Tags are given to every value stored by the Tiered cache, on top of
+	// those passed to SetWithTags, see MultiClient.InvalidateTag.
+	// Default: none.
+	Tags []string
+}
+
+// Tiered is a typed view over a MultiClient: values of type V are kept
+// decoded in the in-process tier and encoded with a Codec in the remote
+// tier (memcache, Redis... see RemoteStore). Unlike MultiClient, it decodes
+// remote hits into V and reports remote and decoding errors instead of
+// treating them as misses.
+//
+//	campaigns := cache.NewTiered[Campaign](mc, cache.TieredOptions[Campaign]{
+//	    LocalTTL: 30 * time.Second,
+//	})
+//	c, found, err := campaigns.Get(ctx, id)
+//
+// Several Tiered caches of different types may share a MultiClient as long
+// as their keys do not overlap.
+type Tiered[V any] struct {
+	mc          *MultiClient
+	codec       Codec[V]
+	localTTL    time.Duration
+	remoteTTL   time.Duration
+	softTTL     time.Duration
+	negativeTTL time.Duration
+	tags        []string
+
+	// typeName tells the loads of the Tiered views of different types
+	// apart, see loadKey
+	typeName string
+}
+
+// NewTiered returns a Tiered cache storing values in mc
+func NewTiered[V any](mc *MultiClient, opts TieredOptions[V]) *Tiered[V] {
+	var t = &Tiered[V]{
+		mc:          mc,
+		codec:       opts.Codec,
+		localTTL:    opts.LocalTTL,
+		remoteTTL:   opts.RemoteTTL,
+		softTTL:     opts.SoftTTL,
+		negativeTTL: opts.NegativeTTL,
+		tags:        opts.Tags,
+		typeName:    reflect.TypeFor[V]().String(),
+	}
+	if t.codec == nil {
+		t.codec = JSONCodec[V]{}
+	}
+	if t.localTTL <= 0 {
+		t.localTTL = cache.DefaultExpiration
+	}
+	if t.remoteTTL <= 0 {
+		t.remoteTTL = time.Duration(mc.expiration) * time.Second
+	}
+	return t
+}
+
+// Get looks the key up in the in-process tier, then in the remote tier. A
+// remote hit is decoded and stored in the in-process tier for LocalTTL.
+//
+// found is false, with a nil error, when neither tier has the key. A remote
+// failure or a value that cannot be decoded is returned as an error.
+func (t *Tiered[V]) Get(ctx context.Context, key string) (V, bool, error) {
+	var zero V
+	e, found, err := t.lookup(ctx, key)
+	if err != nil || !found || e.negative {
+		return zero, false, err
+	}
+	return e.val, true, nil
+}
+
+// GetMulti looks the keys up like Get, with a single remote round trip for
+// all the keys missing from the in-process tier. It returns the values found
+// and, in the order of keys, the keys found in neither tier, e.g. to load
+// them in bulk from the database and SetMulti them. Keys cached as not found
+// by GetOrLoad are in neither result.
+//
+// On error, the values found so far are returned, and missing also holds the
+// keys that could not be read or decoded.
+func (t *Tiered[V]) GetMulti(ctx context.Context, keys []string) (values map[string]V, missing []string, err error) {
+	values = make(map[string]V, len(keys))
+	negative := make(map[string]bool)
+	var remoteKeys []string
+	for _, key := range keys {
+		k := t.mc.getKeyName(key)
+		e, found := t.getLocal(ctx, k)
+		switch {
+		case !found:
+			remoteKeys = append(remoteKeys, k)
+		case e.negative:
+			negative[key] = true
+			t.mc.obs.localHit()
+		default:
+			values[key] = e.val
+			t.mc.obs.localHit()
+		}
+	}
+
+	if len(remoteKeys) > 0 {
+		err = t.getRemoteMulti(ctx, keys, remoteKeys, values, negative)
+	}
+	for _, key := range keys {
+		if _, found := values[key]; !found && !negative[key] {
+			missing = append(missing, key)
+		}
+	}
+	return values, missing, err
+}
+
+// getRemoteMulti fetches the remote keys and adds what it finds to values
+// and negative, back-filling the in-process tier.
+func (t *Tiered[V]) getRemoteMulti(ctx context.Context, keys, remoteKeys []string, values map[string]V, negative map[string]bool) error {
+	data, err := t.mc.remote.GetMulti(ctx, remoteKeys)
+	if err != nil {
+		t.mc.obs.misses.Add(uint64(len(remoteKeys)))
+		return fmt.Errorf("cache: get %d keys: %w", len(remoteKeys), err)
+	}
+
+	var first error
+	misses := len(remoteKeys)
+	for _, key := range keys {
+		k := t.mc.getKeyName(key)
+		raw, found := data[k]
+		if !found {
+			continue
+		}
+		e, err := t.decode(raw)
+		if err != nil {
+			misses--
+			t.mc.obs.decodeError()
+			if first == nil {
+				first = fmt.Errorf("cache: decode %q: %w", key, err)
+			}
+			continue
+		}
+		if !t.mc.tagsValid(ctx, e.tags) {
+			continue
+		}
+		misses--
+		t.mc.obs.remoteHit()
+
+		localTTL := t.localTTL
+		if e.negative {
+			localTTL = t.negativeTTL
+			negative[key] = true
+		} else {
+			values[key] = e.val
+		}
+		t.setLocal(k, e, localTTL)
+	}
+	t.mc.obs.misses.Add(uint64(misses))
+	return first
+}
+
+// GetOrLoad returns the cached value for key, calling loader to produce it
+// on a miss. Concurrent misses for the same key, within this process, share
+// a single loader call, so a hot key expiring does not send every request
+// to the database at once.
+//
+//	c, err := campaigns.GetOrLoad(ctx, id, func(ctx context.Context) (Campaign, error) {
+//	    c, err := findCampaign(ctx, id)
+//	    if errors.Is(err, mongo.ErrNoDocuments) {
+//	        return c, cache.ErrNotFound
+//	    }
+//	    return c, err
+//	})
+//
+// With SoftTTL set, a value older than SoftTTL is still returned, and a
+// reload is started in the background; callers only wait for the loader
+// once the value has expired for good. A loader returning ErrNotFound is
+// remembered for NegativeTTL. Other loader errors are returned and not
+// cached.
+//
+// The loader receives a context that keeps ctx's values but not its
+// cancellation, since its result is shared with other callers; it should
+// bound its own run time. If ctx is done first, GetOrLoad returns ctx.Err()
+// and the load carries on for the others.
+//
+// A failing remote tier is treated as a miss, and failing to store the loaded
+// value is ignored: the cache being down must not fail reads.
+func (t *Tiered[V]) GetOrLoad(ctx context.Context, key string, loader func(ctx context.Context) (V, error)) (V, error) {
+	var zero V
+	e, found, err := t.lookup(ctx, key)
+	if err != nil && ctx.Err() != nil {
+		return zero, ctx.Err()
+	}
+	if found {
+		if e.stale(time.Now()) {
+			// Nobody waits for the result: callers keep getting the
+			// stale value until the reload has stored the new one.
+			t.mc.loads.DoChan(t.loadKey(key), func() (interface{}, error) {
+				return t.load(ctx, key, loader)
+			})
+		}
+		if e.negative {
+			return zero, ErrNotFound
+		}
+		return e.val, nil
+	}
+
+	ch := t.mc.loads.DoChan(t.loadKey(key), func() (interface{}, error) {
+		return t.load(ctx, key, loader)
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return zero, res.Err
+		}
+		v, ok := res.Val.(V)
+		if !ok && res.Val != nil {
+			return zero, fmt.Errorf("cache: load %q: got a %T, want a %T", key, res.Val, zero)
+		}
+		return v, nil
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// loadKey returns the singleflight key of key. loads is shared by the Tiered
+// views of every type, so the type is part of the key: a caller must never
+// receive the result of a loader of another type.
+func (t *Tiered[V]) loadKey(key string) string {
+	return t.mc.getKeyName(key) + "\x00" + t.typeName
+}
+
+// load calls loader and caches its outcome.
+func (t *Tiered[V]) load(ctx context.Context, key string, loader func(ctx context.Context) (V, error)) (V, error) {
+	ctx = context.WithoutCancel(ctx)
+
+	// The tag versions are read first: if a tag is invalidated while the
+	// loader runs, the value may predate the change and must not be kept.
+	tags, tagsErr := t.mc.tagVersions(ctx, t.tags)
+	v, err := loader(ctx)
+	if tagsErr != nil {
+		return v, err
+	}
+	if errors.Is(err, ErrNotFound) {
+		if t.negativeTTL > 0 {
+			t.store(ctx, key, entry[V]{negative: true, tags: tags}, t.negativeTTL, t.negativeTTL)
+		}
+		return v, ErrNotFound
+	}
+	if err != nil {
+		return v, err
+	}
+
+	e := entry[V]{val: v, tags: tags}
+	if t.softTTL > 0 {
+		e.softExpiry = time.Now().Add(t.softTTL)
+	}
+	t.store(ctx, key, e, t.localTTL, t.remoteTTL)
+	return v, nil
+}
+
+// Set stores the value in both tiers, for LocalTTL and RemoteTTL.
+func (t *Tiered[V]) Set(ctx context.Context, key string, val V) error {
+	return t.SetWithTags(ctx, key, val)
+}
+
+// SetWithTags stores the value like Set, until any of the tags, or of the
+// Tags of the Tiered cache, is invalidated with MultiClient.InvalidateTag.
+func (t *Tiered[V]) SetWithTags(ctx context.Context, key string, val V, tags ...string) error {
+	e, err := t.entry(ctx, val, tags)
+	if err == nil {
+		err = t.store(ctx, key, e, t.localTTL, t.remoteTTL)
+	}
+	t.mc.invalidate(ctx, t.mc.getKeyName(key))
+	return err
+}
+
+// SetMulti stores the values in both tiers like Set, with a single remote
+// round trip where the remote tier supports it.
+func (t *Tiered[V]) SetMulti(ctx context.Context, values map[string]V) error {
+	entries := make(map[string]entry[V], len(values))
+	items := make(map[string][]byte, len(values))
+	for key, val := range values {
+		e, err := t.entry(ctx, val, nil)
+		if err != nil {
+			return err
+		}
+		data, err := t.encode(e)
+		if err != nil {
+			return fmt.Errorf("cache: encode %q: %w", key, err)
+		}
+		k := t.mc.getKeyName(key)
+		entries[k] = e
+		items[k] = data
+	}
+	for k, e := range entries {
+		t.setLocal(k, e, t.localTTL)
+	}
+
+	err := t.mc.remote.SetMulti(ctx, items, t.remoteTTL)
+	for k := range items {
+		t.mc.invalidate(ctx, k)
+	}
+	if err != nil {
+		return fmt.Errorf("cache: set %d keys: %w", len(items), err)
+	}
+	return nil
+}
+
+// SetWithExpire stores the value in both tiers for at most ttl: the remote
+// tier keeps it for ttl, the in-process tier for the shorter of ttl and
+// LocalTTL.
+func (t *Tiered[V]) SetWithExpire(ctx context.Context, key string, val V, ttl time.Duration) error {
+	localTTL := t.localTTL
+	if localTTL == cache.DefaultExpiration || ttl < localTTL {
+		localTTL = ttl
+	}
+	e, err := t.entry(ctx, val, nil)
+	if err == nil {
+		err = t.store(ctx, key, e, localTTL, ttl)
+	}
+	t.mc.invalidate(ctx, t.mc.getKeyName(key))
+	return err
+}
+
+// entry returns the entry to store val with, tagged with the current
+// versions of tags and of the Tags of t.
+func (t *Tiered[V]) entry(ctx context.Context, val V, tags []string) (entry[V], error) {
+	if len(tags) == 0 && len(t.tags) == 0 {
+		return entry[V]{val: val}, nil
+	}
+	versions, err := t.mc.tagVersions(ctx, slices.Concat(tags, t.tags))
+	if err != nil {
+		return entry[V]{}, err
+	}
+	return entry[V]{val: val, tags: versions}, nil
+}
+
+// Delete removes the key from both tiers. Deleting a key that does not
+// exist is not an error.
+func (t *Tiered[V]) Delete(ctx context.Context, key string) error {
+	k := t.mc.getKeyName(key)
+	t.mc.local.Delete(k)
+
+	err := t.mc.remote.Delete(ctx, k)
+	t.mc.invalidate(ctx, k)
+	if err != nil {
+		return fmt.Errorf("cache: delete %q: %w", key, err)
+	}
+	return nil
+}
+
+// lookup finds the entry for key in the in-process tier, then in
+// the remote tier, back-filling the in-process tier on a remote hit.
+func (t *Tiered[V]) lookup(ctx context.Context, key string) (entry[V], bool, error) {
+	k := t.mc.getKeyName(key)
+	if e, found := t.getLocal(ctx, k); found {
+		t.mc.obs.localHit()
+		return e, true, nil
+	}
+
+	data, err := t.mc.remote.Get(ctx, k)
+	if err != nil {
+		t.mc.obs.miss()
+		if errors.Is(err, ErrCacheMiss) {
+			return entry[V]{}, false, nil
+		}
+		return entry[V]{}, false, fmt.Errorf("cache: get %q: %w", key, err)
+	}
+	e, err := t.decode(data)
+	if err != nil {
+		t.mc.obs.decodeError()
+		return entry[V]{}, false, fmt.Errorf("cache: decode %q: %w", key, err)
+	}
+	if !t.mc.tagsValid(ctx, e.tags) {
+		t.mc.obs.miss()
+		return entry[V]{}, false, nil
+	}
+	t.mc.obs.remoteHit()
+
+	localTTL := t.localTTL
+	if e.negative {
+		localTTL = t.negativeTTL
+	}
+	t.setLocal(k, e, localTTL)
+	return e, true, nil
+}
+
+// store writes the entry to both tiers.
+func (t *Tiered[V]) store(ctx context.Context, key string, e entry[V], localTTL, remoteTTL time.Duration) error {
+	k := t.mc.getKeyName(key)
+	data, err := t.encode(e)
+	if err != nil {
+		return fmt.Errorf("cache: encode %q: %w", key, err)
+	}
+	t.setLocal(k, e, localTTL)
+
+	if err := t.mc.remote.Set(ctx, k, data, remoteTTL); err != nil {
+		return fmt.Errorf("cache: set %q: %w", key, err)
+	}
+	return nil
+}
+
+// getLocal returns the entry held in the in-process tier. Entries of
+// another type, e.g. stored through MultiClient.Set, and entries whose tags
+// were invalidated count as misses.
+func (t *Tiered[V]) getLocal(ctx context.Context, k string) (entry[V], bool) {
+	raw, found := t.mc.local.Get(k)
+	if !found {
+		return entry[V]{}, false
+	}
+	// entry[V] goes first: when V is an interface type, it matches
+	// entries as well.
+	switch v := raw.(type) {
+	case entry[V]:
+		return v, t.mc.tagsValid(ctx, v.tags)
+	case V:
+		return entry[V]{val: v}, true
+	}
+	return entry[V]{}, false
+}
+
+// setLocal keeps plain values as V in the in-process tier, so that they can
+// be read back by MultiClient.Get too.
+func (t *Tiered[V]) setLocal(k string, e entry[V], ttl time.Duration) {
+	if e.plain() {
+		t.mc.setLocal(k, e.val, ttl)
+	} else {
+		t.mc.setLocal(k, e, ttl)
+	}
+}
+
+func (t *Tiered[V]) encode(e entry[V]) ([]byte, error) {
+	env := envelope{negative: e.negative, softExpiry: e.softExpiry, tags: e.tags}
+	if e.negative {
+		return encodeEnvelope(nil, env), nil
+	}
+	data, err := t.codec.Marshal(e.val)
+	if err != nil || e.plain() {
+		return data, err
+	}
+	return encodeEnvelope(data, env), nil
+}
+
+func (t *Tiered[V]) decode(data []byte) (entry[V], error) {
+	payload, env, ok := decodeEnvelope(data)
+	if !ok {
+		payload = data
+	}
+	if env.negative {
+		return entry[V]{negative: true, tags: env.tags}, nil
+	}
+	v, err := t.codec.Unmarshal(payload)
+	if err != nil {
+		return entry[V]{}, err
+	}
+	return entry[V]{val: v, softExpiry: env.softExpiry, tags: env.tags}, nil
+}
+
+// entry is a cached value along with what GetOrLoad needs to know about
+// it. negative marks a cached ErrNotFound; softExpiry, if not zero, is when
+// the value becomes stale; tags are the versions of its tags.
+type entry[V any] struct {
+	val        V
+	negative   bool
+	softExpiry time.Time
+	tags       []tagVersion
+}
+
+// anyEntry gives the methods that do not know V access to an entry[V].
+type anyEntry interface {
+	unwrap() (val interface{}, negative bool, tags []tagVersion)
+}
+
+func (e entry[V]) unwrap() (interface{}, bool, []tagVersion) {
+	return e.val, e.negative, e.tags
+}
+
+func (e entry[V]) plain() bool {
+	return !e.negative && e.softExpiry.IsZero() && len(e.tags) == 0
+}
+
+func (e entry[V]) stale(now time.Time) bool {
+	return !e.softExpiry.IsZero() && now.After(e.softExpiry)
+}
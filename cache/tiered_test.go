@@ -0,0 +1,368 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CloudStuffTech/go-utils/cache"
+	"github.com/CloudStuffTech/go-utils/cache/cachetest"
+)
+
+func TestTiered_GetSet(t *testing.T) {
+	ctx := context.Background()
+	mc, store := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+	campaigns := cache.NewTiered[campaign](mc, cache.TieredOptions[campaign]{Codec: cache.GobCodec[campaign]{}})
+
+	if _, found, err := campaigns.Get(ctx, "c1"); found || err != nil {
+		t.Fatalf("expected a miss, got %v, %v", found, err)
+	}
+
+	want := campaign{ID: "c1", Budget: 10, Tags: []string{"a"}}
+	if err := campaigns.Set(ctx, "c1", want); err != nil {
+		t.Fatal(err)
+	}
+	calls := store.Calls()
+	got, found, err := campaigns.Get(ctx, "c1")
+	if err != nil || !found || got.ID != "c1" || got.Budget != 10 {
+		t.Fatalf("expected a local hit, got %+v, %v, %v", got, found, err)
+	}
+	if store.Calls() != calls {
+		t.Error("a local hit reached the shared tier")
+	}
+
+	// Decoded from the shared tier with the codec, then served locally.
+	mc.DelFromMemory("c1")
+	got, found, err = campaigns.Get(ctx, "c1")
+	if err != nil || !found || got.ID != "c1" || len(got.Tags) != 1 {
+		t.Fatalf("expected a remote hit, got %+v, %v, %v", got, found, err)
+	}
+	calls = store.Calls()
+	campaigns.Get(ctx, "c1")
+	if store.Calls() != calls {
+		t.Error("a remote hit was not stored in the in-memory tier")
+	}
+
+	if err := campaigns.Delete(ctx, "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := campaigns.Get(ctx, "c1"); found {
+		t.Error("expected a miss after Delete")
+	}
+}
+
+// TestTiered_DecodeError verifies that a value the codec cannot decode is
+// reported as an error rather than as a miss.
+func TestTiered_DecodeError(t *testing.T) {
+	ctx := context.Background()
+	mc, store := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+	campaigns := cache.NewTiered[campaign](mc, cache.TieredOptions[campaign]{})
+
+	store.Set(ctx, "test_c1", []byte("{not json"), 0)
+	if _, found, err := campaigns.Get(ctx, "c1"); found || err == nil {
+		t.Fatalf("expected a decode error, got %v, %v", found, err)
+	}
+	if n := mc.Stats().DecodeErrors; n != 1 {
+		t.Errorf("expected 1 decode error, got %d", n)
+	}
+}
+
+// TestTiered_SharesKeysWithMultiClient verifies that the default JSON codec
+// reads what MultiClient.Set wrote.
+func TestTiered_SharesKeysWithMultiClient(t *testing.T) {
+	ctx := context.Background()
+	mc, _ := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+	campaigns := cache.NewTiered[campaign](mc, cache.TieredOptions[campaign]{})
+
+	mc.Set("c1", campaign{ID: "c1", Budget: 3})
+	mc.DelFromMemory("c1")
+	got, found, err := campaigns.Get(ctx, "c1")
+	if err != nil || !found || got.Budget != 3 {
+		t.Fatalf("expected the value set by MultiClient, got %+v, %v, %v", got, found, err)
+	}
+}
+
+func TestTiered_SetWithExpire(t *testing.T) {
+	ctx := context.Background()
+	mc, store := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+	campaigns := cache.NewTiered[campaign](mc, cache.TieredOptions[campaign]{})
+
+	campaigns.SetWithExpire(ctx, "c1", campaign{ID: "c1"}, 2*time.Second)
+	mc.DelFromMemory("c1")
+	store.Advance(3 * time.Second)
+	if _, found, err := campaigns.Get(ctx, "c1"); found || err != nil {
+		t.Fatalf("expected the value to have expired, got %v, %v", found, err)
+	}
+
+	if _, err := store.Get(ctx, "test_c1"); !errors.Is(err, cache.ErrCacheMiss) {
+		t.Errorf("expected the shared tier to have dropped the value, got %v", err)
+	}
+}
+
+// TestTiered_GetOrLoadDedupe verifies that concurrent misses for a key share
+// a single loader call.
+func TestTiered_GetOrLoadDedupe(t *testing.T) {
+	ctx := context.Background()
+	mc, _ := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+	campaigns := cache.NewTiered[campaign](mc, cache.TieredOptions[campaign]{})
+
+	var calls atomic.Int64
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (campaign, error) {
+		calls.Add(1)
+		<-release
+		return campaign{ID: "c1"}, nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := campaigns.GetOrLoad(ctx, "c1", loader)
+			if err == nil && c.ID != "c1" {
+				err = fmt.Errorf("got %+v", c)
+			}
+			errs <- err
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected a single loader call, got %d", n)
+	}
+
+	// Now cached: the loader is not called again.
+	campaigns.GetOrLoad(ctx, "c1", loader)
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected the loaded value to be cached, got %d loader calls", n)
+	}
+}
+
+// TestTiered_GetOrLoadTypes verifies that Tiered views of different types
+// loading the same key at the same time do not share their results.
+func TestTiered_GetOrLoadTypes(t *testing.T) {
+	ctx := context.Background()
+	mc, _ := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+	names := cache.NewTiered[string](mc, cache.TieredOptions[string]{})
+	counts := cache.NewTiered[int](mc, cache.TieredOptions[int]{})
+
+	started, release := make(chan struct{}), make(chan struct{})
+	go names.GetOrLoad(ctx, "k", func(ctx context.Context) (string, error) {
+		close(started)
+		<-release
+		return "name", nil
+	})
+	<-started
+	defer close(release)
+
+	n, err := counts.GetOrLoad(ctx, "k", func(ctx context.Context) (int, error) {
+		return 42, nil
+	})
+	if err != nil || n != 42 {
+		t.Fatalf("expected the int loader's result, got %v, %v", n, err)
+	}
+}
+
+// TestTiered_GetOrLoadStale verifies that past SoftTTL the stale value is
+// returned at once while a single reload runs in the background.
+func TestTiered_GetOrLoadStale(t *testing.T) {
+	ctx := context.Background()
+	mc, _ := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+	campaigns := cache.NewTiered[campaign](mc, cache.TieredOptions[campaign]{SoftTTL: 20 * time.Millisecond})
+
+	var version atomic.Int64
+	reloaded := make(chan struct{}, 10)
+	loader := func(ctx context.Context) (campaign, error) {
+		v := version.Add(1)
+		if v > 1 {
+			time.Sleep(20 * time.Millisecond)
+			reloaded <- struct{}{}
+		}
+		return campaign{ID: fmt.Sprint(v)}, nil
+	}
+
+	if c, err := campaigns.GetOrLoad(ctx, "c1", loader); err != nil || c.ID != "1" {
+		t.Fatalf("first load: %+v, %v", c, err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	for range 5 {
+		start := time.Now()
+		c, err := campaigns.GetOrLoad(ctx, "c1", loader)
+		if err != nil || c.ID != "1" {
+			t.Fatalf("expected the stale value, got %+v, %v", c, err)
+		}
+		if time.Since(start) > 10*time.Millisecond {
+			t.Fatal("a stale read waited for the reload")
+		}
+	}
+
+	select {
+	case <-reloaded:
+	case <-time.After(time.Second):
+		t.Fatal("expected a background reload")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := version.Load(); n != 2 {
+		t.Errorf("expected a single reload, got %d", n-1)
+	}
+	if c, _ := campaigns.GetOrLoad(ctx, "c1", loader); c.ID != "2" {
+		t.Errorf("expected the reloaded value, got %+v", c)
+	}
+}
+
+// TestTiered_GetOrLoadNegative verifies that ErrNotFound is cached for
+// NegativeTTL only, and that other errors are not cached.
+func TestTiered_GetOrLoadNegative(t *testing.T) {
+	ctx := context.Background()
+	mc, store := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+	campaigns := cache.NewTiered[campaign](mc, cache.TieredOptions[campaign]{NegativeTTL: time.Second})
+
+	var calls atomic.Int64
+	notFound := func(ctx context.Context) (campaign, error) {
+		calls.Add(1)
+		return campaign{}, cache.ErrNotFound
+	}
+	for range 2 {
+		if _, err := campaigns.GetOrLoad(ctx, "c1", notFound); !errors.Is(err, cache.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected the not found result to be cached, got %d loader calls", n)
+	}
+	if _, found, err := campaigns.Get(ctx, "c1"); found || err != nil {
+		t.Errorf("expected Get to report a cached not found as a miss, got %v, %v", found, err)
+	}
+
+	// Once both tiers have dropped it, the loader runs again.
+	mc.DelFromMemory("c1")
+	store.Advance(2 * time.Second)
+	campaigns.GetOrLoad(ctx, "c1", notFound)
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected the loader to run after NegativeTTL, got %d calls", n)
+	}
+
+	boom := errors.New("boom")
+	for range 2 {
+		campaigns.GetOrLoad(ctx, "c2", func(ctx context.Context) (campaign, error) {
+			calls.Add(1)
+			return campaign{}, boom
+		})
+	}
+	if n := calls.Load(); n != 4 {
+		t.Errorf("expected loader errors not to be cached, got %d calls", n)
+	}
+}
+
+// TestTiered_GetOrLoadNoNegativeTTL verifies that ErrNotFound is not cached
+// without NegativeTTL.
+func TestTiered_GetOrLoadNoNegativeTTL(t *testing.T) {
+	ctx := context.Background()
+	mc, _ := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+	campaigns := cache.NewTiered[campaign](mc, cache.TieredOptions[campaign]{})
+
+	var calls atomic.Int64
+	for range 2 {
+		campaigns.GetOrLoad(ctx, "c1", func(ctx context.Context) (campaign, error) {
+			calls.Add(1)
+			return campaign{}, cache.ErrNotFound
+		})
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected 2 loader calls, got %d", n)
+	}
+}
+
+// TestTiered_GetMulti verifies that the keys missing from memory are looked
+// up in a single call to the shared tier, and that local hits, remote hits,
+// misses and decode errors are told apart.
+func TestTiered_GetMulti(t *testing.T) {
+	ctx := context.Background()
+	mc, store := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+	campaigns := cache.NewTiered[campaign](mc, cache.TieredOptions[campaign]{})
+
+	campaigns.Set(ctx, "local", campaign{ID: "local"})
+	campaigns.Set(ctx, "remote", campaign{ID: "remote"})
+	mc.DelFromMemory("remote")
+	store.Set(ctx, "test_bad", []byte("{not json"), 0)
+
+	calls := store.Calls()
+	values, missing, err := campaigns.GetMulti(ctx, []string{"local", "remote", "absent", "bad"})
+	if store.Calls() != calls+1 {
+		t.Errorf("expected a single call to the shared tier, got %d", store.Calls()-calls)
+	}
+	if err == nil {
+		t.Error("expected the decode error of bad")
+	}
+	if len(values) != 2 || values["local"].ID != "local" || values["remote"].ID != "remote" {
+		t.Errorf("expected local and remote, got %+v", values)
+	}
+	if len(missing) != 2 || missing[0] != "absent" || missing[1] != "bad" {
+		t.Errorf("expected absent and bad to be missing, got %v", missing)
+	}
+	stats := mc.Stats()
+	if stats.LocalHits != 1 || stats.RemoteHits != 1 || stats.Misses != 1 || stats.DecodeErrors != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// The remote hit was back-filled in memory.
+	calls = store.Calls()
+	if _, missing, _ := campaigns.GetMulti(ctx, []string{"local", "remote"}); len(missing) != 0 || store.Calls() != calls {
+		t.Errorf("expected local hits only, got %v missing and %d calls", missing, store.Calls()-calls)
+	}
+}
+
+func TestTiered_SetMulti(t *testing.T) {
+	ctx := context.Background()
+	mc, store := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+	campaigns := cache.NewTiered[campaign](mc, cache.TieredOptions[campaign]{})
+
+	err := campaigns.SetMulti(ctx, map[string]campaign{"c1": {ID: "c1"}, "c2": {ID: "c2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Len() != 2 {
+		t.Errorf("expected 2 keys in the shared tier, got %d", store.Len())
+	}
+	calls := store.Calls()
+	if values, _, _ := campaigns.GetMulti(ctx, []string{"c1", "c2"}); len(values) != 2 || store.Calls() != calls {
+		t.Errorf("expected both keys in memory, got %+v", values)
+	}
+
+	mc.DelFromMemory("c1")
+	mc.DelFromMemory("c2")
+	if values, _, _ := campaigns.GetMulti(ctx, []string{"c1", "c2"}); values["c1"].ID != "c1" || values["c2"].ID != "c2" {
+		t.Errorf("expected both keys in the shared tier, got %+v", values)
+	}
+}
+
+// TestMultiClient_GetMulti verifies the untyped GetMulti and SetMulti of
+// MultiClient, which read values back from the shared tier as generic JSON.
+func TestMultiClient_GetMulti(t *testing.T) {
+	mc, _ := cachetest.NewMultiClient(&cache.Config{Prefix: "test"})
+	mc.SetMulti(map[string]interface{}{"a": "x", "b": 2})
+	mc.DelFromMemory("b")
+
+	values, missing := mc.GetMulti([]string{"a", "b", "c"})
+	if values["a"] != "x" || values["b"] != float64(2) {
+		t.Errorf("expected a and b, got %v", values)
+	}
+	if len(missing) != 1 || missing[0] != "c" {
+		t.Errorf("expected c to be missing, got %v", missing)
+	}
+}
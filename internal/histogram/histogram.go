@@ -0,0 +1,55 @@
+// Package histogram implements the fixed-bucket histograms behind the
+// Stats of the buffer and cache packages.
+package histogram
+
+import "sync/atomic"
+
+// Histogram is a snapshot of a fixed-bucket histogram. Counts[i] is the
+// number of observations v with Bounds[i-1] < v <= Bounds[i]; the last
+// entry of Counts counts observations above the last bound.
+type Histogram struct {
+	Bounds []float64
+	Counts []uint64
+	Count  uint64
+	Sum    float64
+}
+
+// Recorder is the lock-free counterpart of Histogram.
+type Recorder struct {
+	bounds []float64
+	counts []atomic.Uint64
+	count  atomic.Uint64
+	// sum is stored in thousandths so it can be kept in an integer.
+	sum atomic.Uint64
+}
+
+// New returns an empty Recorder with the given bucket bounds, in
+// increasing order.
+func New(bounds []float64) *Recorder {
+	return &Recorder{bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1)}
+}
+
+// Observe records v.
+func (h *Recorder) Observe(v float64) {
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.count.Add(1)
+	h.sum.Add(uint64(v * 1000))
+}
+
+// Snapshot returns the observations recorded so far.
+func (h *Recorder) Snapshot() Histogram {
+	s := Histogram{
+		Bounds: h.bounds,
+		Counts: make([]uint64, len(h.counts)),
+		Count:  h.count.Load(),
+		Sum:    float64(h.sum.Load()) / 1000,
+	}
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Load()
+	}
+	return s
+}
@@ -0,0 +1,21 @@
+package histogram
+
+import "testing"
+
+func TestRecorder_Buckets(t *testing.T) {
+	h := New([]float64{1, 10})
+	for _, v := range []float64{0.5, 1, 5, 10, 50} {
+		h.Observe(v)
+	}
+
+	s := h.Snapshot()
+	want := []uint64{2, 2, 1}
+	for i := range want {
+		if s.Counts[i] != want[i] {
+			t.Errorf("bucket %d: expected %d, got %d", i, want[i], s.Counts[i])
+		}
+	}
+	if s.Count != 5 || s.Sum != 66.5 {
+		t.Errorf("expected count 5 sum 66.5, got %d %v", s.Count, s.Sum)
+	}
+}
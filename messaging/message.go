@@ -92,6 +92,12 @@ func (m *Message) Receive(callback func(ctx context.Context, msg *pubsub.Message
 	return err
 }
 
+// ReceiveContext method works like Receive but stops receiving, and returns
+// nil, once the given context is done
+func (m *Message) ReceiveContext(ctx context.Context, callback func(ctx context.Context, msg *pubsub.Message)) error {
+	return m.sub.Receive(ctx, callback)
+}
+
 func (m *Message) getContext() (context.Context, context.CancelFunc) {
 	if m.Timeout > 0 {
 		var ctx, cancelCtx = context.WithTimeout(m.ctx, time.Duration(m.Timeout)*time.Millisecond)
@@ -133,6 +139,34 @@ func (m *Message) SendWithID(msg []byte) (string, error) {
 	return "", errors.New("invalid message type")
 }
 
+// SendBatch publishes all the messages and waits until each of them was
+// acknowledged by the service or failed. Unlike Send, it uses the given
+// context instead of the one held by the message, so the caller controls
+// cancellation and timeout. The returned slice has one entry per message,
+// nil for the ones that were delivered
+func (m *Message) SendBatch(ctx context.Context, msgs [][]byte) []error {
+	var errs = make([]error, len(msgs))
+	switch m.messageType {
+	case _pubSub:
+		// Publish everything first so the client can batch the messages,
+		// then collect the results
+		var results = make([]*pubsub.PublishResult, len(msgs))
+		for i, msg := range msgs {
+			results[i] = m.topic.Publish(ctx, &pubsub.Message{
+				Data: msg,
+			})
+		}
+		for i, result := range results {
+			_, errs[i] = result.Get(ctx)
+		}
+		return errs
+	}
+	for i := range errs {
+		errs[i] = errors.New("invalid message type")
+	}
+	return errs
+}
+
 // SendBackground delivers the message in background
 func (m *Message) SendBackground(msg []byte) {
 	var ctx, cancelCtx = m.getContext()
@@ -3,6 +3,7 @@ package modelsv2
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -16,6 +17,11 @@ import (
 
 const OBJECT_ID_LEN = 24
 
+// ErrQueryCacheNotInvalidated is wrapped by the error Save returns when the
+// document was written but the cached query results of the collection could
+// not be invalidated. The write must not be retried then
+var ErrQueryCacheNotInvalidated = errors.New("modelsv2: query cache not invalidated")
+
 // Model interface is a generic type for all the models
 type Model interface {
 	New() Model
@@ -32,6 +38,11 @@ type FindOptions struct {
 	Limit, Skip *int64
 	BatchSize   *int32
 	Timeout     time.Duration
+	// InvalidateOnSave tags the result cached by CacheFirstWithOpts with
+	// QueryCacheTag, so that Save invalidates it. Checking the tag costs a
+	// read of the shared cache on every hit, unless the cache client has an
+	// InvalidationBus
+	InvalidateOnSave bool
 }
 
 // AggregateOpts method is used for holding options while doing aggregation
@@ -223,7 +234,10 @@ func clearCache(cacheClient *cache.MultiClient, model Model, id string) {
 	cacheClient.Delete(cacheKey)
 }
 
-// Save method will save the document in db and update the cache
+// Save method will save the document in db and update the cache. It returns
+// the error of the write. If the write succeeded but the cached query results
+// of the collection could not be invalidated, which may then be stale until
+// they expire, the error returned wraps ErrQueryCacheNotInvalidated
 func Save(db *mongo.Database, cacheClient *cache.MultiClient, model Model, id string) error {
 	var err error
 	if model.IsEmpty() {
@@ -239,8 +253,15 @@ func Save(db *mongo.Database, cacheClient *cache.MultiClient, model Model, id st
 		}
 	}
 	clearCache(cacheClient, model, id)
+	tagErr := cacheClient.InvalidateTag(context.Background(), QueryCacheTag(model))
 	model.ClearCacheData(cacheClient)
-	return err
+	if err != nil {
+		return err
+	}
+	if tagErr != nil {
+		return fmt.Errorf("%w: %w", ErrQueryCacheNotInvalidated, tagErr)
+	}
+	return nil
 }
 
 // Query method will return cursor to the database
@@ -281,31 +302,65 @@ func UpdateMany(db *mongo.Database, model Model, query, updateObj bson.M) error
 }
 
 // CacheFirst method will try to find the object with given id in cache else it
-// will query the db and save the result in cache
+// will query the db and save the result in cache. Concurrent misses for the
+// same id share a single db query
 func CacheFirst(cacheClient *cache.MultiClient, db *mongo.Database, model Model, id string) Model {
 	var cacheKey = GetCacheKey(model, id)
-	var result, found = cacheClient.Get(cacheKey)
-	if found {
-		return result.(Model)
-	}
-	r := model.FindByID(db, id)
-	cacheClient.Set(cacheKey, r)
+	var r, _ = modelCache(cacheClient, model).GetOrLoad(context.Background(), cacheKey, func(ctx context.Context) (Model, error) {
+		return model.FindByID(db, id), nil
+	})
 	return r
 }
 
 // CacheFirstWithOpts method will try to find the object with given query and find options in cache else it
-// will query the db and save the result in cache
+// will query the db and save the result in cache. Concurrent misses for the
+// same query share a single db query. With FindOptions.InvalidateOnSave,
+// Save invalidates the result
 func CacheFirstWithOpts(cacheClient *cache.MultiClient, db *mongo.Database, model Model, query bson.M, queryOpts *FindOptions) Model {
 	var cacheKey = GetCacheKeyWithOpts(model, query, queryOpts)
-	var result, found = cacheClient.Get(cacheKey)
-	if found {
-		return result.(Model)
+	var tags []string
+	if queryOpts != nil && queryOpts.InvalidateOnSave {
+		tags = append(tags, QueryCacheTag(model))
 	}
-	r := FindOneWithOpts(db, model, query, queryOpts)
-	cacheClient.Set(cacheKey, r)
+	var r, _ = modelCache(cacheClient, model, tags...).GetOrLoad(context.Background(), cacheKey, func(ctx context.Context) (Model, error) {
+		return FindOneWithOpts(db, model, query, queryOpts), nil
+	})
 	return r
 }
 
+// QueryCacheTag method returns the cache tag of the query results of model's
+// collection cached with FindOptions.InvalidateOnSave. Any document saved may
+// change what a query returns, so Save invalidates it; call cache.MultiClient.InvalidateTag with it after writing
+// to the collection by other means
+func QueryCacheTag(model Model) string {
+	return model.Table() + "::queries"
+}
+
+// modelCache returns a typed view of the cache client which decodes the
+// values found in memcache into new instances of the model, tagging them
+// with tags
+func modelCache(cacheClient *cache.MultiClient, model Model, tags ...string) *cache.Tiered[Model] {
+	return cache.NewTiered(cacheClient, cache.TieredOptions[Model]{
+		Codec: modelCodec{model: model},
+		Tags:  tags,
+	})
+}
+
+// modelCodec encodes models as JSON, like cache.MultiClient.Set does
+type modelCodec struct {
+	model Model
+}
+
+func (c modelCodec) Marshal(m Model) ([]byte, error) {
+	return json.Marshal(m)
+}
+
+func (c modelCodec) Unmarshal(data []byte) (Model, error) {
+	var m = c.model.New()
+	var err = json.Unmarshal(data, m)
+	return m, err
+}
+
 // DateQuery will return the bson representation to query daterange between
 // 2 time intervals
 func DateQuery(start, end time.Time) bson.M {
@@ -355,6 +410,9 @@ func GetCacheKeyWithOpts(model Model, query bson.M, queryOpts *FindOptions) stri
 		if queryOpts.Timeout > 0 {
 			keyParts = append(keyParts, fmt.Sprintf("timeout=%s", queryOpts.Timeout.String()))
 		}
+		if queryOpts.InvalidateOnSave {
+			keyParts = append(keyParts, "invalidateOnSave")
+		}
 	}
 
 	return misc.JoinKeyParts(keyParts)
@@ -0,0 +1,462 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+	"github.com/redis/go-redis/v9"
+)
+
+// errCrossSlot is returned by Batch.ExecTx on a Redis Cluster when the
+// commands do not all have a key in the same slot
+var errCrossSlot = errors.New("redis: transaction commands must have keys in the same cluster slot")
+
+// ErrTxAborted is returned by Batch.ExecTx when redis discarded the
+// transaction, e.g. because one of its commands could not be queued
+var ErrTxAborted = errors.New("redis: transaction aborted")
+
+// Batch queues commands to send them to redis in a single round trip,
+// either as a pipeline with Exec or as a MULTI/EXEC transaction with ExecTx.
+// Get one with Client.Batch or Clientv2.Batch:
+//
+//	results, err := client.Batch().
+//	    HIncrBy("clicks:"+day, campaignID, 1).
+//	    HIncrByFloat("revenue:"+day, campaignID, payout).
+//	    Expire("clicks:"+day, 48*time.Hour).
+//	    Exec(ctx)
+//
+// Only commands with a single value reply can be queued. A Batch is not safe
+// for concurrent use.
+//
+// On a Redis Cluster, the commands of a pipeline are sent to the nodes
+// serving their keys, while those of a transaction must all have a key, in
+// the same slot, e.g. by sharing a {hash tag}
+type Batch struct {
+	run  func(ctx context.Context, cmds [][]string, tx bool) ([]Result, error)
+	cmds [][]string
+}
+
+// Result is the reply of a command of a Batch
+type Result struct {
+	// Val is the reply, with integers in base 10
+	Val string
+	// Err is the error returned by redis for the command, or ErrNil if the
+	// reply was nil
+	Err error
+}
+
+// Int returns the reply as an integer
+func (r Result) Int() (int64, error) {
+	if r.Err != nil {
+		return 0, r.Err
+	}
+	return strconv.ParseInt(r.Val, 10, 64)
+}
+
+// Float returns the reply as a float, e.g. for HINCRBYFLOAT
+func (r Result) Float() (float64, error) {
+	if r.Err != nil {
+		return 0, r.Err
+	}
+	return strconv.ParseFloat(r.Val, 64)
+}
+
+// Bool returns whether the reply is the integer 1, e.g. for SISMEMBER
+func (r Result) Bool() (bool, error) {
+	n, err := r.Int()
+	return n == 1, err
+}
+
+// Batch method will return an empty batch of commands to run on the client
+func (c *Client) Batch() *Batch {
+	return &Batch{run: c.runBatch}
+}
+
+// Batch method will return an empty batch of commands to run on the client
+func (c *Clientv2) Batch() *Batch {
+	return &Batch{run: c.runBatch}
+}
+
+// Len returns the number of queued commands
+func (b *Batch) Len() int {
+	return len(b.cmds)
+}
+
+// Cmd queues any command with a single value reply
+func (b *Batch) Cmd(name string, args ...string) *Batch {
+	b.cmds = append(b.cmds, append([]string{name}, args...))
+	return b
+}
+
+// HIncrBy queues a HINCRBY
+func (b *Batch) HIncrBy(key, field string, inc int64) *Batch {
+	return b.Cmd("HINCRBY", key, field, strconv.FormatInt(inc, 10))
+}
+
+// HIncrByFloat queues a HINCRBYFLOAT
+func (b *Batch) HIncrByFloat(key, field string, inc float64) *Batch {
+	return b.Cmd("HINCRBYFLOAT", key, field, strconv.FormatFloat(inc, 'f', -1, 64))
+}
+
+// HSet queues a HSET of a single field
+func (b *Batch) HSet(key, field, value string) *Batch {
+	return b.Cmd("HSET", key, field, value)
+}
+
+// HGet queues a HGET, whose Result has ErrNil if the field does not exist
+func (b *Batch) HGet(key, field string) *Batch {
+	return b.Cmd("HGET", key, field)
+}
+
+// HDel queues a HDEL
+func (b *Batch) HDel(key string, fields ...string) *Batch {
+	return b.Cmd("HDEL", append([]string{key}, fields...)...)
+}
+
+// IncrBy queues an INCRBY
+func (b *Batch) IncrBy(key string, inc int64) *Batch {
+	return b.Cmd("INCRBY", key, strconv.FormatInt(inc, 10))
+}
+
+// SAdd queues a SADD
+func (b *Batch) SAdd(key string, members ...string) *Batch {
+	return b.Cmd("SADD", append([]string{key}, members...)...)
+}
+
+// SRem queues a SREM
+func (b *Batch) SRem(key string, members ...string) *Batch {
+	return b.Cmd("SREM", append([]string{key}, members...)...)
+}
+
+// SIsMember queues a SISMEMBER, see Result.Bool
+func (b *Batch) SIsMember(key, member string) *Batch {
+	return b.Cmd("SISMEMBER", key, member)
+}
+
+// SCard queues a SCARD
+func (b *Batch) SCard(key string) *Batch {
+	return b.Cmd("SCARD", key)
+}
+
+// Expire queues an expiration of key after ttl, as a PEXPIRE so that it is
+// precise to the millisecond. A positive ttl under a millisecond is rounded
+// up, as PEXPIRE 0 deletes the key
+func (b *Batch) Expire(key string, ttl time.Duration) *Batch {
+	ms := ttl.Milliseconds()
+	if ttl > 0 && ms == 0 {
+		ms = 1
+	}
+	return b.Cmd("PEXPIRE", key, strconv.FormatInt(ms, 10))
+}
+
+// Del queues a DEL. On a Redis Cluster, the keys must be in the same slot,
+// see DelMulti otherwise
+func (b *Batch) Del(keys ...string) *Batch {
+	return b.Cmd("DEL", keys...)
+}
+
+// Exec sends the queued commands as a pipeline and returns their results,
+// in order. Commands run independently: some may fail while others succeed.
+// The error is that of the whole batch, e.g. a connection error, in which
+// case it is unknown which commands ran, or else the first error of a
+// command. Nil replies are not errors. The batch is emptied
+func (b *Batch) Exec(ctx context.Context) ([]Result, error) {
+	return b.exec(ctx, false)
+}
+
+// ExecTx sends the queued commands as a MULTI/EXEC transaction: either all
+// of them run, or none does and the error is ErrTxAborted. Redis errors of a
+// command at run time, e.g. HINCRBY on a field that is not an integer, do
+// not abort the transaction and are reported like with Exec
+func (b *Batch) ExecTx(ctx context.Context) ([]Result, error) {
+	return b.exec(ctx, true)
+}
+
+func (b *Batch) exec(ctx context.Context, tx bool) ([]Result, error) {
+	cmds := b.cmds
+	b.cmds = nil
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+
+	results, err := b.run(ctx, cmds, tx)
+	if err != nil {
+		return results, err
+	}
+	for i, r := range results {
+		if r.Err != nil && !errors.Is(r.Err, ErrNil) {
+			return results, fmt.Errorf("redis: batch command %d (%s): %w", i, cmds[i][0], r.Err)
+		}
+	}
+	return results, nil
+}
+
+func (c *Client) runBatch(ctx context.Context, cmds [][]string, tx bool) ([]Result, error) {
+	if _, ok := c.conn.(*redis.ClusterClient); ok && tx && !sameSlot(cmds) {
+		// go-redis would run one transaction per slot
+		return nil, errCrossSlot
+	}
+
+	var pipe redis.Pipeliner
+	if tx {
+		pipe = c.conn.TxPipeline()
+	} else {
+		pipe = c.conn.Pipeline()
+	}
+	queued := make([]*redis.Cmd, len(cmds))
+	for i, cmd := range cmds {
+		args := make([]interface{}, len(cmd))
+		for j, arg := range cmd {
+			args[j] = arg
+		}
+		queued[i] = pipe.Do(ctx, args...)
+	}
+
+	_, err := pipe.Exec(ctx)
+	if tx && (errors.Is(err, redis.TxFailedErr) || isRedisError(err, "EXECABORT")) {
+		return nil, fmt.Errorf("%w: %v", ErrTxAborted, err)
+	}
+	if err != nil && !isRedisError(err, "") {
+		// Not a reply of redis, e.g. a connection error
+		return nil, err
+	}
+	results := make([]Result, len(cmds))
+	for i, cmd := range queued {
+		val, err := cmd.Result()
+		results[i] = Result{Val: replyString(val), Err: convertErr(err)}
+	}
+	return results, nil
+}
+
+// cmdSlot returns the Redis Cluster slot of the key of cmd, if it has one
+func cmdSlot(cmd []string) (uint16, bool) {
+	keys := radix.Cmd(nil, cmd[0], cmd[1:]...).Keys()
+	if len(keys) == 0 {
+		return 0, false
+	}
+	return radix.ClusterSlot([]byte(keys[0])), true
+}
+
+// keysBySlot groups the keys by Redis Cluster slot, in the order of their
+// first key
+func keysBySlot(keys []string) [][]string {
+	var groups [][]string
+	index := make(map[uint16]int)
+	for _, key := range keys {
+		slot := radix.ClusterSlot([]byte(key))
+		i, ok := index[slot]
+		if !ok {
+			i = len(groups)
+			index[slot] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], key)
+	}
+	return groups
+}
+
+// sameSlot reports whether the commands all have a key, in the same Redis
+// Cluster slot. Multi-key commands are checked by redis itself
+func sameSlot(cmds [][]string) bool {
+	first, ok := cmdSlot(cmds[0])
+	if !ok {
+		return false
+	}
+	for _, cmd := range cmds[1:] {
+		if slot, ok := cmdSlot(cmd); !ok || slot != first {
+			return false
+		}
+	}
+	return true
+}
+
+// replyString formats a reply of go-redis as the string redis sent
+func replyString(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	}
+	return fmt.Sprint(val)
+}
+
+// isRedisError reports whether err is an error reply of redis starting with
+// prefix
+func isRedisError(err error, prefix string) bool {
+	var redisErr redis.Error
+	return errors.As(err, &redisErr) && strings.HasPrefix(redisErr.Error(), prefix)
+}
+
+func (c *Clientv2) runBatch(ctx context.Context, cmds [][]string, tx bool) ([]Result, error) {
+	if _, ok := c.client.(*radix.Cluster); ok {
+		if tx {
+			if !sameSlot(cmds) {
+				return nil, errCrossSlot
+			}
+		} else {
+			return c.runSlots(ctx, cmds)
+		}
+	}
+	return c.pipeline(ctx, cmds, tx)
+}
+
+// runSlots runs the commands on a Redis Cluster, as one pipeline per slot
+// since radix sends a pipeline to a single node. The error of a pipeline is
+// that of each of its commands
+func (c *Clientv2) runSlots(ctx context.Context, cmds [][]string) ([]Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	bySlot := make(map[uint16][]int)
+	for i, cmd := range cmds {
+		slot, _ := cmdSlot(cmd)
+		bySlot[slot] = append(bySlot[slot], i)
+	}
+
+	results := make([]Result, len(cmds))
+	var wg sync.WaitGroup
+	for _, indexes := range bySlot {
+		wg.Add(1)
+		go func(indexes []int) {
+			defer wg.Done()
+			group := make([][]string, len(indexes))
+			for j, i := range indexes {
+				group[j] = cmds[i]
+			}
+			groupResults, err := c.pipeline(ctx, group, false)
+			for j, i := range indexes {
+				if err != nil {
+					results[i] = Result{Err: err}
+				} else {
+					results[i] = groupResults[j]
+				}
+			}
+		}(indexes)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func (c *Clientv2) pipeline(ctx context.Context, cmds [][]string, tx bool) ([]Result, error) {
+	replies := make([]batchReply, len(cmds))
+	actions := make([]radix.CmdAction, 0, len(cmds)+2)
+	exec := execReply{replies: replies}
+	queued := make([]batchReply, len(cmds))
+	if tx {
+		actions = append(actions, radix.Cmd(nil, "MULTI"))
+	}
+	for i, cmd := range cmds {
+		rcv := &replies[i]
+		if tx {
+			rcv = &queued[i]
+		}
+		actions = append(actions, radix.Cmd(rcv, cmd[0], cmd[1:]...))
+	}
+	if tx {
+		actions = append(actions, radix.Cmd(&exec, "EXEC"))
+	}
+
+	if err := c.do(ctx, radix.Pipeline(actions...)); err != nil {
+		return nil, err
+	}
+	if exec.err != nil {
+		// Tell why, the error of EXEC is not explicit
+		for _, q := range queued {
+			if q.Err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrTxAborted, q.Err)
+			}
+		}
+		return nil, fmt.Errorf("%w: %v", ErrTxAborted, exec.err)
+	}
+
+	results := make([]Result, len(cmds))
+	for i, r := range replies {
+		results[i] = Result(r)
+	}
+	return results, nil
+}
+
+// batchReply receives the reply of a command of a radix pipeline. A redis
+// error is kept as the error of the command instead of failing the
+// pipeline, so that each command gets its own error
+type batchReply Result
+
+func (r *batchReply) UnmarshalRESP(br *bufio.Reader) error {
+	var rm resp2.RawMessage
+	if err := rm.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	switch {
+	case rm.IsNil():
+		r.Err = ErrNil
+	case rm[0] == resp2.ErrorPrefix[0]:
+		var redisErr resp2.Error
+		if err := rm.UnmarshalInto(&redisErr); err != nil {
+			return err
+		}
+		r.Err = redisErr
+	default:
+		return rm.UnmarshalInto(resp2.Any{I: &r.Val})
+	}
+	return nil
+}
+
+// execReply receives the reply of EXEC into the replies of the commands of
+// the transaction
+type execReply struct {
+	replies []batchReply
+	// err is set if the transaction was aborted
+	err error
+}
+
+func (r *execReply) UnmarshalRESP(br *bufio.Reader) error {
+	b, err := br.Peek(1)
+	if err != nil {
+		return err
+	}
+	if b[0] == resp2.ErrorPrefix[0] {
+		var redisErr resp2.Error
+		if err := redisErr.UnmarshalRESP(br); err != nil {
+			return err
+		}
+		r.err = redisErr
+		return nil
+	}
+
+	var header resp2.ArrayHeader
+	if err := header.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	if header.N < 0 {
+		// A watched key was modified
+		r.err = errors.New("nil reply")
+		return nil
+	}
+	if header.N != len(r.replies) {
+		return fmt.Errorf("redis: EXEC returned %d replies for %d commands", header.N, len(r.replies))
+	}
+	for i := range r.replies {
+		if err := r.replies[i].UnmarshalRESP(br); err != nil {
+			return err
+		}
+	}
+	return nil
+}
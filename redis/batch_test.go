@@ -0,0 +1,215 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rest is what follows the reply in the tests of the parsers, which must be
+// left unread.
+const rest = "+next\r\n"
+
+func TestBatchReply_UnmarshalRESP(t *testing.T) {
+	tests := []struct {
+		name, reply string
+		want        Result
+		wantErr     string // of the command
+	}{
+		{name: "bulk", reply: "$3\r\nabc\r\n", want: Result{Val: "abc"}},
+		{name: "empty bulk", reply: "$0\r\n\r\n", want: Result{Val: ""}},
+		{name: "integer", reply: ":-42\r\n", want: Result{Val: "-42"}},
+		{name: "simple string", reply: "+OK\r\n", want: Result{Val: "OK"}},
+		{name: "nil", reply: "$-1\r\n", want: Result{Err: ErrNil}},
+		{name: "error", reply: "-ERR hash value is not an integer\r\n", wantErr: "ERR hash value is not an integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br := bufio.NewReader(strings.NewReader(tt.reply + rest))
+			var r batchReply
+			if err := r.UnmarshalRESP(br); err != nil {
+				t.Fatalf("expected the reply to be parsed, got %v", err)
+			}
+			switch {
+			case tt.wantErr != "":
+				if r.Err == nil || r.Err.Error() != tt.wantErr {
+					t.Errorf("expected the error %q, got %v", tt.wantErr, r.Err)
+				}
+			case r.Val != tt.want.Val || !errors.Is(r.Err, tt.want.Err):
+				t.Errorf("expected %+v, got %+v", tt.want, r)
+			}
+			if left, _ := io.ReadAll(br); string(left) != rest {
+				t.Errorf("expected %q to be left unread, got %q", rest, left)
+			}
+		})
+	}
+}
+
+func TestBatchReply_UnmarshalRESP_Truncated(t *testing.T) {
+	var r batchReply
+	if err := r.UnmarshalRESP(bufio.NewReader(strings.NewReader("$3\r\nab"))); err == nil {
+		t.Error("expected an error on a truncated reply")
+	}
+}
+
+func TestExecReply_UnmarshalRESP(t *testing.T) {
+	tests := []struct {
+		name, reply string
+		commands    int
+		want        []Result // nil when the transaction is aborted
+		wantErr     bool     // of the parser
+	}{
+		{
+			name:     "replies",
+			reply:    "*3\r\n:1\r\n$-1\r\n$2\r\nok\r\n",
+			commands: 3,
+			want:     []Result{{Val: "1"}, {Err: ErrNil}, {Val: "ok"}},
+		},
+		{
+			name:     "error of a command",
+			reply:    "*2\r\n-ERR not an integer\r\n:5\r\n",
+			commands: 2,
+			want:     []Result{{Err: errors.New("ERR not an integer")}, {Val: "5"}},
+		},
+		{
+			name:     "aborted",
+			reply:    "-EXECABORT Transaction discarded because of previous errors.\r\n",
+			commands: 1,
+		},
+		{name: "watched key modified", reply: "*-1\r\n", commands: 1},
+		{name: "count mismatch", reply: "*1\r\n:1\r\n", commands: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br := bufio.NewReader(strings.NewReader(tt.reply + rest))
+			r := execReply{replies: make([]batchReply, tt.commands)}
+			err := r.UnmarshalRESP(br)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected a parse error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected the reply to be parsed, got %v", err)
+			}
+			if tt.want == nil {
+				if r.err == nil {
+					t.Error("expected the transaction to be aborted")
+				}
+			} else {
+				if r.err != nil {
+					t.Fatalf("expected the transaction to run, got %v", r.err)
+				}
+				for i, want := range tt.want {
+					got := r.replies[i]
+					if got.Val != want.Val || (got.Err == nil) != (want.Err == nil) ||
+						(want.Err != nil && got.Err.Error() != want.Err.Error()) {
+						t.Errorf("reply %d: expected %+v, got %+v", i, want, got)
+					}
+				}
+			}
+			if left, _ := io.ReadAll(br); string(left) != rest {
+				t.Errorf("expected %q to be left unread, got %q", rest, left)
+			}
+		})
+	}
+}
+
+func TestBatch_Exec(t *testing.T) {
+	ctx := context.Background()
+	for name, c := range clients(t, newFakeRedis(t).options()) {
+		t.Run(name, func(t *testing.T) {
+			h := name + ":h" // the clients share the server
+			if results, err := c.Batch().Exec(ctx); results != nil || err != nil {
+				t.Errorf("expected an empty batch to do nothing, got %v, %v", results, err)
+			}
+
+			b := c.Batch().HSet(h, "text", "abc").HIncrBy(h, "n", 2).HGet(h, "missing")
+			if b.Len() != 3 {
+				t.Errorf("expected 3 queued commands, got %d", b.Len())
+			}
+			results, err := b.Exec(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.Len() != 0 {
+				t.Error("expected Exec to empty the batch")
+			}
+			if n, err := results[1].Int(); err != nil || n != 2 {
+				t.Errorf("expected 2, got %d, %v", n, err)
+			}
+			if !errors.Is(results[2].Err, ErrNil) {
+				t.Errorf("expected ErrNil, got %v", results[2].Err)
+			}
+
+			// A failing command does not prevent the others from running.
+			results, err = c.Batch().HIncrBy(h, "text", 1).HIncrBy(h, "n", 1).Exec(ctx)
+			if err == nil || !strings.Contains(err.Error(), "batch command 0 (HINCRBY)") {
+				t.Errorf("expected the error of the first command, got %v", err)
+			}
+			if len(results) != 2 || results[0].Err == nil || results[1].Val != "3" {
+				t.Errorf("expected the results of both commands, got %+v", results)
+			}
+		})
+	}
+}
+
+func TestBatch_ExecTx(t *testing.T) {
+	ctx := context.Background()
+	for name, c := range clients(t, newFakeRedis(t).options()) {
+		t.Run(name, func(t *testing.T) {
+			s := name + ":s" // the clients share the server
+			results, err := c.Batch().SAdd(s, "a", "b").SCard(s).ExecTx(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, _ := results[1].Int(); n != 2 {
+				t.Errorf("expected 2 members, got %+v", results)
+			}
+
+			_, err = c.Batch().SAdd(s, "c").Cmd("BOGUS", s).ExecTx(ctx)
+			if !errors.Is(err, ErrTxAborted) {
+				t.Fatalf("expected ErrTxAborted, got %v", err)
+			}
+			if n, _ := c.SCard(ctx, s); n != 2 {
+				t.Errorf("expected the aborted transaction not to run, got %d members", n)
+			}
+		})
+	}
+}
+
+func TestBatch_Expire(t *testing.T) {
+	tests := []struct {
+		ttl  time.Duration
+		want string
+	}{
+		{48 * time.Hour, "172800000"},
+		{1500 * time.Microsecond, "1"},
+		{time.Microsecond, "1"},
+		{0, "0"},
+	}
+	for _, tt := range tests {
+		b := (&Batch{}).Expire("k", tt.ttl)
+		if got := b.cmds[0]; len(got) != 3 || got[0] != "PEXPIRE" || got[2] != tt.want {
+			t.Errorf("Expire(%v): expected PEXPIRE k %s, got %v", tt.ttl, tt.want, got)
+		}
+	}
+}
+
+func TestKeysBySlot(t *testing.T) {
+	groups := keysBySlot([]string{"{a}1", "b", "{a}2", "{b}3"})
+	want := [][]string{{"{a}1", "{a}2"}, {"b", "{b}3"}}
+	if len(groups) != len(want) {
+		t.Fatalf("expected %v, got %v", want, groups)
+	}
+	for i := range want {
+		if strings.Join(groups[i], " ") != strings.Join(want[i], " ") {
+			t.Errorf("expected %v, got %v", want, groups)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotObtained is returned when a lock is held by someone else
+	ErrNotObtained = errors.New("redis: lock not obtained")
+
+	// ErrLockNotHeld is returned when a lock expired, or was taken over by
+	// someone else after expiring, before being extended or released
+	ErrLockNotHeld = errors.New("redis: lock not held")
+)
+
+// minLockTTL is the shortest ttl of a lock. Redis counts it in milliseconds
+// and the renewal runs every third of it
+const minLockTTL = 10 * time.Millisecond
+
+// acquireScript takes the lock KEYS[1] with the token ARGV[1] for ARGV[2]
+// milliseconds, and returns the next fencing token from KEYS[2], or 0 if
+// the lock is held
+var acquireScript = newScript(2, `
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return redis.call('INCR', KEYS[2])
+end
+return 0
+`)
+
+// releaseScript deletes the lock KEYS[1] if it still has the token ARGV[1]
+var releaseScript = newScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// extendScript sets the ttl of the lock KEYS[1] to ARGV[2] milliseconds if
+// it still has the token ARGV[1]
+var extendScript = newScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// LockerOptions holds the settings of a Locker. Every field is optional
+type LockerOptions struct {
+	// Prefix is prepended to the names of the locks to make their keys.
+	// Default: "lock:"
+	Prefix string
+
+	// RetryInterval is how often Lock tries again to take a lock held by
+	// someone else.
+	// Default: 100 milliseconds
+	RetryInterval time.Duration
+
+	// NoRenew disables the renewal of the locks: they expire after their
+	// ttl unless extended with Lock.Extend. By default, a lock is extended
+	// by its ttl every third of its ttl until it is released
+	NoRenew bool
+}
+
+func (opts LockerOptions) withDefaults() LockerOptions {
+	if opts.Prefix == "" {
+		opts.Prefix = "lock:"
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = 100 * time.Millisecond
+	}
+	return opts
+}
+
+// Locker hands out locks shared by every process using the same redis, e.g.
+// to run a cron job on a single pod at a time:
+//
+//	locker := redis.NewLocker(client, redis.LockerOptions{})
+//	lock, err := locker.TryLock(ctx, "daily-report", time.Minute)
+//	if errors.Is(err, redis.ErrNotObtained) {
+//	    return // running elsewhere
+//	}
+//	defer lock.Unlock(context.Background())
+//
+// A lock is a lease: if its holder stops renewing it, e.g. because it lost
+// its connection to redis, it expires after its ttl and someone else may
+// take it while the holder still believes it holds it. Lock.Lost tells the
+// holder, and Lock.Token gives a fencing token that increases with every
+// acquisition of the lock, which the resources written under the lock can
+// use to reject writes from former holders.
+//
+// Locks are single-node: with Sentinel, a lock may be lost on failover. On
+// a Redis Cluster, the keys of a lock share the {hash tag} of its name
+type Locker struct {
+	client Scripter
+	opts   LockerOptions
+}
+
+// NewLocker returns a Locker using client, a *Client or a *Clientv2
+func NewLocker(client Scripter, opts LockerOptions) *Locker {
+	return &Locker{client: client, opts: opts.withDefaults()}
+}
+
+// NewLocalLocker returns a Locker that keeps its locks in memory instead of
+// redis, with the same behavior. It locks within a single process and never
+// forgets the fencing tokens, it is meant for tests
+func NewLocalLocker(opts LockerOptions) *Locker {
+	return NewLocker(newLocalLocks(), opts)
+}
+
+// Lock is a lock held, as returned by Locker.Lock and Locker.TryLock
+type Lock struct {
+	locker *Locker
+	name   string
+	key    string
+	token  string
+	fence  int64
+
+	mu  sync.Mutex
+	ttl time.Duration
+
+	// stop ends the renewal, done is closed once it has ended
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	lost     chan struct{}
+	lostOnce sync.Once
+}
+
+// Lock takes the lock name for ttl, waiting for it to be released while
+// someone else holds it. It returns an error wrapping ErrNotObtained and the
+// error of ctx if ctx is done first
+func (l *Locker) Lock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	ticker := time.NewTicker(l.opts.RetryInterval)
+	defer ticker.Stop()
+	for {
+		lock, err := l.TryLock(ctx, name, ttl)
+		if !errors.Is(err, ErrNotObtained) {
+			return lock, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %w", ErrNotObtained, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// TryLock takes the lock name for ttl, or returns ErrNotObtained at once if
+// someone else holds it. ttl must be at least 10 milliseconds
+func (l *Locker) TryLock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	if err := checkTTL(ttl); err != nil {
+		return nil, fmt.Errorf("redis: lock %q: %w", name, err)
+	}
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	key := l.opts.Prefix + "{" + name + "}"
+	res, err := l.client.evalInts(ctx, acquireScript, []string{key, key + ":fence"}, token, strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return nil, fmt.Errorf("redis: lock %q: %w", name, err)
+	}
+	if res[0] == 0 {
+		return nil, ErrNotObtained
+	}
+
+	lock := &Lock{
+		locker: l,
+		name:   name,
+		key:    key,
+		token:  token,
+		fence:  res[0],
+		ttl:    ttl,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		lost:   make(chan struct{}),
+	}
+	if l.opts.NoRenew {
+		close(lock.done)
+	} else {
+		go lock.renew()
+	}
+	return lock, nil
+}
+
+func checkTTL(ttl time.Duration) error {
+	if ttl < minLockTTL {
+		return fmt.Errorf("ttl %v is shorter than %v", ttl, minLockTTL)
+	}
+	return nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("redis: random token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Name returns the name of the lock
+func (l *Lock) Name() string {
+	return l.name
+}
+
+// Token returns the fencing token of the lock, greater than that of every
+// previous holder of the lock
+func (l *Lock) Token() int64 {
+	return l.fence
+}
+
+// Lost returns a channel closed when the renewal finds that the lock is no
+// longer held, or fails for a whole ttl. The holder should stop working
+// under the lock then
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Extend resets the ttl of the lock to ttl, which the renewal uses from
+// then on. It returns ErrLockNotHeld if the lock has expired. ttl must be at
+// least 10 milliseconds
+func (l *Lock) Extend(ctx context.Context, ttl time.Duration) error {
+	if err := checkTTL(ttl); err != nil {
+		return fmt.Errorf("redis: extend lock %q: %w", l.name, err)
+	}
+	if err := l.extend(ctx, ttl); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.ttl = ttl
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *Lock) extend(ctx context.Context, ttl time.Duration) error {
+	res, err := l.locker.client.evalInts(ctx, extendScript, []string{l.key}, l.token, strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return fmt.Errorf("redis: extend lock %q: %w", l.name, err)
+	}
+	if res[0] == 0 {
+		l.markLost()
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Unlock stops the renewal and releases the lock. It returns ErrLockNotHeld
+// if the lock had expired
+func (l *Lock) Unlock(ctx context.Context) error {
+	l.stopOnce.Do(func() { close(l.stop) })
+	<-l.done
+
+	res, err := l.locker.client.evalInts(ctx, releaseScript, []string{l.key}, l.token)
+	if err != nil {
+		return fmt.Errorf("redis: unlock %q: %w", l.name, err)
+	}
+	if res[0] == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func (l *Lock) markLost() {
+	l.lostOnce.Do(func() { close(l.lost) })
+}
+
+// renew extends the lock every third of its ttl until Unlock is called or
+// the lock is lost. Failed extensions are retried until the lock would have
+// expired
+func (l *Lock) renew() {
+	defer close(l.done)
+
+	l.mu.Lock()
+	ttl := l.ttl
+	l.mu.Unlock()
+	extended := time.Now()
+	timer := time.NewTimer(ttl / 3)
+	defer timer.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-l.lost:
+			return
+		case <-timer.C:
+		}
+
+		l.mu.Lock()
+		ttl = l.ttl
+		l.mu.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), ttl/3)
+		start := time.Now()
+		err := l.extend(ctx, ttl)
+		cancel()
+		switch {
+		case err == nil:
+			extended = start
+		case errors.Is(err, ErrLockNotHeld):
+			return
+		case time.Since(extended) >= ttl:
+			l.markLost()
+			return
+		}
+		timer.Reset(ttl / 3)
+	}
+}
+
+// localLocks is the in-memory state of the lockers of NewLocalLocker,
+// mirroring the scripts
+type localLocks struct {
+	mu     sync.Mutex
+	locks  map[string]localLock
+	fences map[string]int64
+}
+
+type localLock struct {
+	token   string
+	expires time.Time
+}
+
+func newLocalLocks() *localLocks {
+	return &localLocks{locks: make(map[string]localLock), fences: make(map[string]int64)}
+}
+
+func (ll *localLocks) evalInts(ctx context.Context, s *script, keys []string, args ...string) ([]int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+	now := time.Now()
+
+	lock, held := ll.locks[keys[0]]
+	if held && !now.Before(lock.expires) {
+		delete(ll.locks, keys[0])
+		held = false
+	}
+	switch s {
+	case acquireScript:
+		if held {
+			return []int64{0}, nil
+		}
+		ms, _ := strconv.ParseInt(args[1], 10, 64)
+		ll.locks[keys[0]] = localLock{token: args[0], expires: now.Add(time.Duration(ms) * time.Millisecond)}
+		ll.fences[keys[1]]++
+		return []int64{ll.fences[keys[1]]}, nil
+	case releaseScript:
+		if !held || lock.token != args[0] {
+			return []int64{0}, nil
+		}
+		delete(ll.locks, keys[0])
+		return []int64{1}, nil
+	case extendScript:
+		if !held || lock.token != args[0] {
+			return []int64{0}, nil
+		}
+		ms, _ := strconv.ParseInt(args[1], 10, 64)
+		lock.expires = now.Add(time.Duration(ms) * time.Millisecond)
+		ll.locks[keys[0]] = lock
+		return []int64{1}, nil
+	}
+	return nil, errors.New("redis: script not supported in memory")
+}
@@ -0,0 +1,170 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLocker_TryLock(t *testing.T) {
+	ctx := context.Background()
+	locker := NewLocalLocker(LockerOptions{})
+
+	lock, err := locker.TryLock(ctx, "job", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.Name() != "job" {
+		t.Errorf("expected the name of the lock, got %q", lock.Name())
+	}
+	if _, err := locker.TryLock(ctx, "job", time.Minute); !errors.Is(err, ErrNotObtained) {
+		t.Errorf("expected ErrNotObtained while the lock is held, got %v", err)
+	}
+	if _, err := locker.TryLock(ctx, "other", time.Minute); err != nil {
+		t.Errorf("expected another lock to be obtained, got %v", err)
+	}
+	if err := lock.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Unlock(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld on a second Unlock, got %v", err)
+	}
+
+	next, err := locker.TryLock(ctx, "job", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer next.Unlock(ctx)
+	if next.Token() <= lock.Token() {
+		t.Errorf("expected the fencing token to increase, got %d then %d", lock.Token(), next.Token())
+	}
+}
+
+func TestLocker_TTL(t *testing.T) {
+	ctx := context.Background()
+	locker := NewLocalLocker(LockerOptions{})
+	for _, ttl := range []time.Duration{-time.Second, 0, time.Millisecond, 9 * time.Millisecond} {
+		_, err := locker.TryLock(ctx, "job", ttl)
+		if err == nil || errors.Is(err, ErrNotObtained) || !strings.Contains(err.Error(), "ttl") {
+			t.Errorf("ttl %v: expected an invalid ttl error, got %v", ttl, err)
+		}
+		if _, err := locker.Lock(ctx, "job", ttl); err == nil || errors.Is(err, ErrNotObtained) {
+			t.Errorf("ttl %v: expected Lock to fail at once, got %v", ttl, err)
+		}
+	}
+
+	lock, err := locker.TryLock(ctx, "job", minLockTTL)
+	if err != nil {
+		t.Fatalf("expected the minimum ttl to be valid, got %v", err)
+	}
+	defer lock.Unlock(ctx)
+	if err := lock.Extend(ctx, 0); err == nil || errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected Extend to reject the ttl, got %v", err)
+	}
+}
+
+func TestLocker_Lock(t *testing.T) {
+	ctx := context.Background()
+	locker := NewLocalLocker(LockerOptions{RetryInterval: 5 * time.Millisecond, NoRenew: true})
+	if _, err := locker.TryLock(ctx, "job", 30*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := locker.Lock(short, "job", time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the error of ctx, got %v", err)
+	}
+
+	// The lock is not renewed, it expires
+	lock, err := locker.Lock(ctx, "job", time.Minute)
+	if err != nil {
+		t.Fatalf("expected the lock once expired, got %v", err)
+	}
+	lock.Unlock(ctx)
+}
+
+func TestLock_Expired(t *testing.T) {
+	ctx := context.Background()
+	locker := NewLocalLocker(LockerOptions{NoRenew: true})
+	lock, err := locker.TryLock(ctx, "job", 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Extend(ctx, 20*time.Millisecond); err != nil {
+		t.Fatalf("expected the lock to be extended, got %v", err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if err := lock.Extend(ctx, time.Minute); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld, got %v", err)
+	}
+	select {
+	case <-lock.Lost():
+	default:
+		t.Error("expected the lock to be lost")
+	}
+	if err := lock.Unlock(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld, got %v", err)
+	}
+}
+
+func TestLock_Renew(t *testing.T) {
+	ctx := context.Background()
+	locker := NewLocalLocker(LockerOptions{})
+	lock, err := locker.TryLock(ctx, "job", 30*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := locker.TryLock(ctx, "job", time.Minute); !errors.Is(err, ErrNotObtained) {
+		t.Errorf("expected the renewed lock to be held, got %v", err)
+	}
+	select {
+	case <-lock.Lost():
+		t.Error("expected the renewed lock not to be lost")
+	default:
+	}
+	if err := lock.Unlock(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+// failingScripter fails every script once fail is set, like a redis that
+// went away.
+type failingScripter struct {
+	Scripter
+	fail atomic.Bool
+}
+
+func (s *failingScripter) evalInts(ctx context.Context, sc *script, keys []string, args ...string) ([]int64, error) {
+	if s.fail.Load() {
+		return nil, errors.New("connection refused")
+	}
+	return s.Scripter.evalInts(ctx, sc, keys, args...)
+}
+
+// TestLock_RenewalFails verifies that a lock is lost once its renewal has
+// failed for a whole ttl.
+func TestLock_RenewalFails(t *testing.T) {
+	ctx := context.Background()
+	client := &failingScripter{Scripter: newLocalLocks()}
+	lock, err := NewLocker(client, LockerOptions{}).TryLock(ctx, "job", 30*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.fail.Store(true)
+	select {
+	case <-lock.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("expected the lock to be lost")
+	}
+	if err := lock.Unlock(ctx); err == nil || errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected the error of redis, got %v", err)
+	}
+}
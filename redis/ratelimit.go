@@ -0,0 +1,350 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateAlgorithm is the way a RateLimiter counts requests
+type RateAlgorithm int
+
+const (
+	// TokenBucket allows Limit.Rate requests per Limit.Period on average,
+	// and up to Limit.Burst at once after a quiet period. It stores two
+	// numbers per key
+	TokenBucket RateAlgorithm = iota
+
+	// SlidingWindow allows at most Limit.Rate requests in any Limit.Period,
+	// exactly. It stores every request of the last period, so it suits
+	// limits of at most a few thousand requests
+	SlidingWindow
+)
+
+// Limit is a number of requests allowed per period
+type Limit struct {
+	Rate   int
+	Period time.Duration
+
+	// Burst is the number of requests TokenBucket allows at once after a
+	// quiet period. Defaults to Rate
+	Burst int
+}
+
+// PerSecond returns a Limit of rate requests per second
+func PerSecond(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Second}
+}
+
+// PerMinute returns a Limit of rate requests per minute
+func PerMinute(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Minute}
+}
+
+// PerHour returns a Limit of rate requests per hour
+func PerHour(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Hour}
+}
+
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Rate
+}
+
+// RateLimitResult is the decision of a RateLimiter
+type RateLimitResult struct {
+	Allowed bool
+	// Remaining is the number of requests still allowed right now
+	Remaining int
+	// RetryAfter is how long to wait before the denied requests would be
+	// allowed, 0 if they were allowed
+	RetryAfter time.Duration
+}
+
+// RateLimiterOptions holds the settings of a RateLimiter. Every field is
+// optional
+type RateLimiterOptions struct {
+	// Prefix is prepended to the keys given to the RateLimiter.
+	// Default: "ratelimit:"
+	Prefix string
+
+	// Algorithm is the way requests are counted.
+	// Default: TokenBucket
+	Algorithm RateAlgorithm
+
+	// OnError, if set, is called with the error of redis when Middleware
+	// lets a request through because of it, e.g. to log it or count it
+	OnError func(err error)
+}
+
+func (opts RateLimiterOptions) withDefaults() RateLimiterOptions {
+	if opts.Prefix == "" {
+		opts.Prefix = "ratelimit:"
+	}
+	return opts
+}
+
+// tokenBucketScript takes ARGV[3] tokens from the bucket KEYS[1], refilled
+// with ARGV[1] tokens per millisecond up to ARGV[2] tokens. It returns
+// whether they were taken, the tokens left and the milliseconds to wait
+// before they can be taken
+var tokenBucketScript = newScript(1, `
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local time = redis.call('TIME')
+local now = time[1] * 1000 + math.floor(time[2] / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed, retry = 0, 0
+if tokens >= n then
+	tokens = tokens - n
+	allowed = 1
+else
+	retry = math.ceil((n - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate))
+return {allowed, math.floor(tokens), retry}
+`)
+
+// slidingWindowScript adds ARGV[3] requests, named after ARGV[4], to the
+// log KEYS[1] if it holds fewer than ARGV[2] requests of the last ARGV[1]
+// milliseconds. It returns whether they were added, the requests left and
+// the milliseconds to wait before they can be added
+var slidingWindowScript = newScript(1, `
+redis.replicate_commands()
+local period = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local time = redis.call('TIME')
+local now = time[1] * 1000 + math.floor(time[2] / 1000)
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - period)
+local count = redis.call('ZCARD', KEYS[1])
+if count + n <= limit then
+	for i = 1, n do
+		redis.call('ZADD', KEYS[1], now, ARGV[4] .. ':' .. i)
+	end
+	redis.call('PEXPIRE', KEYS[1], period)
+	return {1, limit - count - n, 0}
+end
+
+-- Wait for the request that must leave the window for n to fit
+local retry = 0
+local i = count + n - limit - 1
+local oldest = redis.call('ZRANGE', KEYS[1], i, i, 'WITHSCORES')
+if oldest[2] then
+	retry = tonumber(oldest[2]) + period - now
+end
+return {0, math.max(0, limit - count), retry}
+`)
+
+// RateLimiter limits the rate of requests per key, e.g. per tenant or per
+// postback URL, across every process using the same redis. Each decision
+// takes a single atomic script run, timed with the clock of redis.
+//
+// In HTTP handlers, see Middleware. In background jobs, Wait blocks until
+// the job may run:
+//
+//	limiter := redis.NewRateLimiter(client, redis.RateLimiterOptions{})
+//	if err := limiter.Wait(ctx, "postback:"+host, redis.PerSecond(10)); err != nil {
+//	    return false
+//	}
+type RateLimiter struct {
+	client Scripter
+	opts   RateLimiterOptions
+
+	// local replaces client for the limiters of NewLocalRateLimiter
+	local *localLimits
+}
+
+// NewRateLimiter returns a RateLimiter using client, a *Client or a
+// *Clientv2
+func NewRateLimiter(client Scripter, opts RateLimiterOptions) *RateLimiter {
+	return &RateLimiter{client: client, opts: opts.withDefaults()}
+}
+
+// NewLocalRateLimiter returns a RateLimiter that counts requests in memory
+// instead of redis, with the same algorithms. It limits a single process and
+// never forgets a key, it is meant for tests
+func NewLocalRateLimiter(opts RateLimiterOptions) *RateLimiter {
+	return &RateLimiter{opts: opts.withDefaults(), local: newLocalLimits()}
+}
+
+// Allow reports whether a request for key is allowed under limit, and counts
+// it if so
+func (rl *RateLimiter) Allow(ctx context.Context, key string, limit Limit) (RateLimitResult, error) {
+	return rl.AllowN(ctx, key, limit, 1)
+}
+
+// AllowN reports whether n requests for key are allowed at once under limit,
+// and counts them if so. n must be at least 1, and may not be larger than the
+// burst of limit
+func (rl *RateLimiter) AllowN(ctx context.Context, key string, limit Limit, n int) (RateLimitResult, error) {
+	if limit.Rate <= 0 || limit.Period < time.Millisecond {
+		return RateLimitResult{}, fmt.Errorf("redis: rate limit %q: invalid limit %+v", key, limit)
+	}
+	capacity := limit.Rate
+	if rl.opts.Algorithm == TokenBucket {
+		capacity = limit.burst()
+	}
+	if n < 1 {
+		return RateLimitResult{}, fmt.Errorf("redis: rate limit %q: invalid number of requests %d", key, n)
+	}
+	if n > capacity {
+		return RateLimitResult{}, fmt.Errorf("redis: rate limit %q: %d requests can never fit in %d", key, n, capacity)
+	}
+
+	k := rl.opts.Prefix + key
+	if rl.local != nil {
+		return rl.local.allow(k, rl.opts.Algorithm, limit, n), nil
+	}
+
+	var res []int64
+	var err error
+	switch rl.opts.Algorithm {
+	case SlidingWindow:
+		var id string
+		if id, err = randomToken(); err != nil {
+			return RateLimitResult{}, err
+		}
+		res, err = rl.client.evalInts(ctx, slidingWindowScript, []string{k},
+			strconv.FormatInt(limit.Period.Milliseconds(), 10), strconv.Itoa(limit.Rate), strconv.Itoa(n), id)
+	default:
+		rate := float64(limit.Rate) / float64(limit.Period.Milliseconds())
+		res, err = rl.client.evalInts(ctx, tokenBucketScript, []string{k},
+			strconv.FormatFloat(rate, 'g', -1, 64), strconv.Itoa(limit.burst()), strconv.Itoa(n))
+	}
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("redis: rate limit %q: %w", key, err)
+	}
+	if len(res) != 3 {
+		return RateLimitResult{}, fmt.Errorf("redis: rate limit %q: script returned %d values", key, len(res))
+	}
+	return RateLimitResult{
+		Allowed:    res[0] == 1,
+		Remaining:  int(res[1]),
+		RetryAfter: time.Duration(res[2]) * time.Millisecond,
+	}, nil
+}
+
+// Wait blocks until a request for key is allowed under limit and counts it,
+// or until ctx is done
+func (rl *RateLimiter) Wait(ctx context.Context, key string, limit Limit) error {
+	for {
+		res, err := rl.Allow(ctx, key, limit)
+		if err != nil {
+			return err
+		}
+		if res.Allowed {
+			return nil
+		}
+
+		timer := time.NewTimer(res.RetryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Middleware returns an HTTP middleware limiting the requests under limit,
+// per the key keyFunc returns for them, e.g. the tenant or the client IP.
+// Denied requests get a 429 with a Retry-After header. Requests are let
+// through when redis fails, so that an outage of redis does not take the
+// API down with it, see RateLimiterOptions.OnError
+func (rl *RateLimiter) Middleware(limit Limit, keyFunc func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, err := rl.Allow(r.Context(), keyFunc(r), limit)
+			if err != nil {
+				if rl.opts.OnError != nil {
+					rl.opts.OnError(err)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Rate))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			if !res.Allowed {
+				retryAfter := int(math.Ceil(res.RetryAfter.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// localLimits is the in-memory state of the limiters of
+// NewLocalRateLimiter, mirroring the scripts
+type localLimits struct {
+	mu      sync.Mutex
+	buckets map[string]*localBucket
+	logs    map[string][]time.Time
+}
+
+type localBucket struct {
+	tokens float64
+	ts     time.Time
+}
+
+func newLocalLimits() *localLimits {
+	return &localLimits{buckets: make(map[string]*localBucket), logs: make(map[string][]time.Time)}
+}
+
+func (ll *localLimits) allow(key string, algo RateAlgorithm, limit Limit, n int) RateLimitResult {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+	now := time.Now()
+
+	if algo == SlidingWindow {
+		log := ll.logs[key]
+		start := 0
+		for start < len(log) && !log[start].After(now.Add(-limit.Period)) {
+			start++
+		}
+		log = log[start:]
+		if len(log)+n <= limit.Rate {
+			for i := 0; i < n; i++ {
+				log = append(log, now)
+			}
+			ll.logs[key] = log
+			return RateLimitResult{Allowed: true, Remaining: limit.Rate - len(log)}
+		}
+		ll.logs[key] = log
+		oldest := log[len(log)+n-limit.Rate-1]
+		return RateLimitResult{Remaining: max(0, limit.Rate-len(log)), RetryAfter: oldest.Add(limit.Period).Sub(now)}
+	}
+
+	burst := float64(limit.burst())
+	rate := float64(limit.Rate) / float64(limit.Period)
+	b, ok := ll.buckets[key]
+	if !ok {
+		b = &localBucket{tokens: burst, ts: now}
+		ll.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+float64(now.Sub(b.ts))*rate)
+	b.ts = now
+	if b.tokens >= float64(n) {
+		b.tokens -= float64(n)
+		return RateLimitResult{Allowed: true, Remaining: int(b.tokens)}
+	}
+	retry := time.Duration(math.Ceil((float64(n) - b.tokens) / rate))
+	return RateLimitResult{Remaining: int(b.tokens), RetryAfter: retry}
+}
@@ -0,0 +1,159 @@
+package redis
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLocalLimits_Allow(t *testing.T) {
+	type step struct {
+		n         int
+		allowed   bool
+		remaining int
+	}
+	tests := []struct {
+		name  string
+		algo  RateAlgorithm
+		limit Limit
+		steps []step
+		// retry bounds the RetryAfter of the last step
+		retry [2]time.Duration
+	}{
+		{
+			name:  "token bucket",
+			algo:  TokenBucket,
+			limit: PerSecond(2),
+			steps: []step{{1, true, 1}, {1, true, 0}, {1, false, 0}},
+			retry: [2]time.Duration{400 * time.Millisecond, 500 * time.Millisecond},
+		},
+		{
+			name:  "token bucket burst",
+			algo:  TokenBucket,
+			limit: Limit{Rate: 1, Period: time.Second, Burst: 3},
+			steps: []step{{3, true, 0}, {1, false, 0}},
+			retry: [2]time.Duration{900 * time.Millisecond, time.Second},
+		},
+		{
+			name:  "token bucket partial",
+			algo:  TokenBucket,
+			limit: PerSecond(10),
+			steps: []step{{8, true, 2}, {5, false, 2}},
+			retry: [2]time.Duration{200 * time.Millisecond, 300 * time.Millisecond},
+		},
+		{
+			name:  "sliding window",
+			algo:  SlidingWindow,
+			limit: PerMinute(3),
+			steps: []step{{1, true, 2}, {2, true, 0}, {1, false, 0}},
+			retry: [2]time.Duration{59 * time.Second, time.Minute},
+		},
+		{
+			name:  "sliding window partial",
+			algo:  SlidingWindow,
+			limit: PerMinute(3),
+			steps: []step{{2, true, 1}, {2, false, 1}},
+			retry: [2]time.Duration{59 * time.Second, time.Minute},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ll := newLocalLimits()
+			var res RateLimitResult
+			for i, s := range tt.steps {
+				res = ll.allow("k", tt.algo, tt.limit, s.n)
+				if res.Allowed != s.allowed || res.Remaining != s.remaining {
+					t.Fatalf("step %d: expected allowed %v with %d remaining, got %+v", i, s.allowed, s.remaining, res)
+				}
+				if res.Allowed && res.RetryAfter != 0 {
+					t.Errorf("step %d: expected no RetryAfter when allowed, got %v", i, res.RetryAfter)
+				}
+			}
+			if res.RetryAfter <= tt.retry[0] || res.RetryAfter > tt.retry[1] {
+				t.Errorf("expected RetryAfter in (%v, %v], got %v", tt.retry[0], tt.retry[1], res.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestLocalLimits_Refill(t *testing.T) {
+	for name, algo := range map[string]RateAlgorithm{"token bucket": TokenBucket, "sliding window": SlidingWindow} {
+		t.Run(name, func(t *testing.T) {
+			ll := newLocalLimits()
+			limit := Limit{Rate: 1, Period: 20 * time.Millisecond}
+			ll.allow("k", algo, limit, 1)
+			if res := ll.allow("k", algo, limit, 1); res.Allowed {
+				t.Fatal("expected the second request to be denied")
+			}
+			time.Sleep(25 * time.Millisecond)
+			if res := ll.allow("k", algo, limit, 1); !res.Allowed {
+				t.Errorf("expected a request to be allowed after a period, got %+v", res)
+			}
+		})
+	}
+}
+
+func TestRateLimiter_AllowNInvalid(t *testing.T) {
+	ctx := context.Background()
+	for _, algo := range []RateAlgorithm{TokenBucket, SlidingWindow} {
+		rl := NewLocalRateLimiter(RateLimiterOptions{Algorithm: algo})
+		for _, n := range []int{0, -1, 4} {
+			if _, err := rl.AllowN(ctx, "k", PerSecond(3), n); err == nil {
+				t.Errorf("algorithm %v: expected an error for %d requests", algo, n)
+			}
+		}
+		if res, err := rl.AllowN(ctx, "k", PerSecond(3), 3); err != nil || !res.Allowed {
+			t.Errorf("algorithm %v: expected a full burst to be allowed, got %+v, %v", algo, res, err)
+		}
+	}
+}
+
+func TestRateLimiter_Middleware(t *testing.T) {
+	rl := NewLocalRateLimiter(RateLimiterOptions{})
+	handler := rl.Middleware(PerMinute(1), func(r *http.Request) string { return "tenant" })(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("expected the first request to go through, got %d %v", w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected a 429 with Retry-After, got %d %v", w.Code, w.Header())
+	}
+}
+
+// TestRateLimiter_MiddlewareOnError verifies that the requests are let
+// through when redis fails, and that OnError gets the error.
+func TestRateLimiter_MiddlewareOnError(t *testing.T) {
+	client := &failingScripter{}
+	client.fail.Store(true)
+	var errs []error
+	rl := NewRateLimiter(client, RateLimiterOptions{OnError: func(err error) { errs = append(errs, err) }})
+
+	served := false
+	handler := rl.Middleware(PerSecond(1), func(r *http.Request) string { return "tenant" })(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served = true
+			if len(errs) != 1 {
+				t.Error("expected OnError to be called before the handler")
+			}
+		}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !served || w.Code != http.StatusOK {
+		t.Errorf("expected the request to go through, got %d", w.Code)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expected OnError to be called once, got %d calls", len(errs))
+	}
+
+	if _, err := rl.Allow(context.Background(), "tenant", PerSecond(1)); err == nil {
+		t.Error("expected Allow to return the error")
+	}
+}
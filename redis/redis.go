@@ -2,6 +2,8 @@ package redis
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
@@ -10,9 +12,13 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
-var ctx = context.Background()
+// ErrNil is returned when the key or field read does not exist, so that
+// callers can tell a miss from a failure of redis
+var ErrNil = errors.New("redis: nil")
 
-// ClientOptions struct contains the options for connecting to redis
+// ClientOptions struct contains the options for connecting to redis. The
+// client connects to a single node at Host:Port, unless MasterName or
+// ClusterAddrs is set
 type ClientOptions struct {
 	Host            string
 	Port            string
@@ -23,18 +29,47 @@ type ClientOptions struct {
 	WriteTimeout    time.Duration
 	DB              int
 	PoolSize        int
-}
 
-var dummyHashMap = make(map[string]string)
+	// Username, if set, authenticates as this redis ACL user with Password
+	// instead of with the default user
+	Username string
+
+	// TLSConfig, if set, enables TLS on the connections to redis and to
+	// the sentinels
+	TLSConfig *tls.Config
+
+	// ClusterAddrs, if set, are the host:port addresses of some nodes of a
+	// Redis Cluster. The client discovers the other nodes and sends each
+	// command to the node serving the slot of its key. Host, Port and DB
+	// are ignored
+	ClusterAddrs []string
+
+	// MasterName, if set, is the name of the master to connect to, as
+	// monitored by the sentinels at the host:port addresses SentinelAddrs.
+	// The client follows failovers. Host and Port are ignored, and so is
+	// ClusterAddrs
+	MasterName    string
+	SentinelAddrs []string
+
+	// SentinelUsername and SentinelPassword authenticate with the
+	// sentinels, if they require it
+	SentinelUsername string
+	SentinelPassword string
+}
 
 // Client struct holds connection to redis
 type Client struct {
-	conn *redis.Client
+	conn redis.UniversalClient
 }
 
 // Clientv2 struct holds pool connection to redis using radix dep
 type Clientv2 struct {
-	pool *radix.Pool
+	// client is a *radix.Pool, or a *radix.Sentinel or *radix.Cluster as
+	// per ClientOptions
+	client radix.Client
+	// err is the error that prevented the client from being created, it
+	// is returned by every method
+	err error
 }
 
 // NewClient method will return a pointer to new client object
@@ -43,178 +78,339 @@ func NewClient(opts *ClientOptions) *Client {
 	if opts.PoolSize > 0 {
 		poolSize = opts.PoolSize
 	}
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:            opts.Host + ":" + opts.Port,
-		Password:        opts.Password,
-		DB:              opts.DB,
-		MaxRetries:      opts.MaxRetries,
-		MinRetryBackoff: opts.MinRetryBackOff,
-		MaxRetryBackoff: opts.MaxRetryBackOff,
-		WriteTimeout:    opts.WriteTimeout,
-		PoolSize:        poolSize,
-	})
-	var client = &Client{conn: redisClient}
+
+	var conn redis.UniversalClient
+	switch {
+	case opts.MasterName != "":
+		conn = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       opts.MasterName,
+			SentinelAddrs:    opts.SentinelAddrs,
+			SentinelUsername: opts.SentinelUsername,
+			SentinelPassword: opts.SentinelPassword,
+			Username:         opts.Username,
+			Password:         opts.Password,
+			DB:               opts.DB,
+			MaxRetries:       opts.MaxRetries,
+			MinRetryBackoff:  opts.MinRetryBackOff,
+			MaxRetryBackoff:  opts.MaxRetryBackOff,
+			WriteTimeout:     opts.WriteTimeout,
+			PoolSize:         poolSize,
+			TLSConfig:        opts.TLSConfig,
+		})
+	case len(opts.ClusterAddrs) > 0:
+		conn = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:           opts.ClusterAddrs,
+			Username:        opts.Username,
+			Password:        opts.Password,
+			MaxRetries:      opts.MaxRetries,
+			MinRetryBackoff: opts.MinRetryBackOff,
+			MaxRetryBackoff: opts.MaxRetryBackOff,
+			WriteTimeout:    opts.WriteTimeout,
+			PoolSize:        poolSize,
+			TLSConfig:       opts.TLSConfig,
+		})
+	default:
+		conn = redis.NewClient(&redis.Options{
+			Addr:            opts.Host + ":" + opts.Port,
+			Username:        opts.Username,
+			Password:        opts.Password,
+			DB:              opts.DB,
+			MaxRetries:      opts.MaxRetries,
+			MinRetryBackoff: opts.MinRetryBackOff,
+			MaxRetryBackoff: opts.MaxRetryBackOff,
+			WriteTimeout:    opts.WriteTimeout,
+			PoolSize:        poolSize,
+			TLSConfig:       opts.TLSConfig,
+		})
+	}
+	var client = &Client{conn: conn}
 	return client
 }
 
-// NewV2Client will return the pool connection to radix object
+// NewV2Client will return the pool connection to radix object. If the
+// connection fails, every method of the client returns the error
 func NewV2Client(opts *ClientOptions) *Clientv2 {
 	// Ref: https://github.com/mediocregopher/radix/blob/master/radix.go#L107
 	customConnFunc := func(network, addr string) (radix.Conn, error) {
-		return radix.Dial(network, addr,
-			radix.DialTimeout(opts.WriteTimeout),
-			radix.DialAuthPass(opts.Password),
-			radix.DialSelectDB(opts.DB),
-		)
+		dialOpts := []radix.DialOpt{radix.DialTimeout(opts.WriteTimeout)}
+		dialOpts = append(dialOpts, dialAuth(opts.Username, opts.Password)...)
+		if opts.DB != 0 && len(opts.ClusterAddrs) == 0 {
+			dialOpts = append(dialOpts, radix.DialSelectDB(opts.DB))
+		}
+		if opts.TLSConfig != nil {
+			dialOpts = append(dialOpts, radix.DialUseTLS(opts.TLSConfig))
+		}
+		return radix.Dial(network, addr, dialOpts...)
 	}
 	poolSize := opts.PoolSize
 	if poolSize == 0 {
 		poolSize = 15
 	}
+	poolFunc := func(network, addr string) (radix.Client, error) {
+		return radix.NewPool(network, addr, poolSize, radix.PoolConnFunc(customConnFunc))
+	}
 
-	rclient, _ := radix.NewPool("tcp", opts.Host+":"+opts.Port, poolSize, radix.PoolConnFunc(customConnFunc))
-	var client = &Clientv2{pool: rclient}
+	var rclient radix.Client
+	var err error
+	switch {
+	case opts.MasterName != "":
+		sentinelConnFunc := func(network, addr string) (radix.Conn, error) {
+			dialOpts := []radix.DialOpt{radix.DialTimeout(opts.WriteTimeout)}
+			dialOpts = append(dialOpts, dialAuth(opts.SentinelUsername, opts.SentinelPassword)...)
+			if opts.TLSConfig != nil {
+				dialOpts = append(dialOpts, radix.DialUseTLS(opts.TLSConfig))
+			}
+			return radix.Dial(network, addr, dialOpts...)
+		}
+		rclient, err = radix.NewSentinel(opts.MasterName, opts.SentinelAddrs,
+			radix.SentinelConnFunc(sentinelConnFunc), radix.SentinelPoolFunc(poolFunc))
+	case len(opts.ClusterAddrs) > 0:
+		rclient, err = radix.NewCluster(opts.ClusterAddrs, radix.ClusterPoolFunc(poolFunc))
+	default:
+		rclient, err = poolFunc("tcp", opts.Host+":"+opts.Port)
+	}
+	var client = &Clientv2{}
+	if err != nil {
+		client.err = fmt.Errorf("redis: connect: %w", err)
+	} else {
+		client.client = rclient
+	}
 	return client
 }
 
-// GetConn returns a pointer to the underlying redis library
+func dialAuth(username, password string) []radix.DialOpt {
+	switch {
+	case username != "":
+		return []radix.DialOpt{radix.DialAuthUser(username, password)}
+	case password != "":
+		return []radix.DialOpt{radix.DialAuthPass(password)}
+	}
+	return nil
+}
+
+// GetConn returns a pointer to the underlying redis library.
+//
+// GetConn returns nil if the client is connected to a Redis Cluster, i.e.
+// ClusterAddrs is set: callers must check the result before using it.
+//
+// Deprecated: use GetUniversalConn, which works whatever the kind of
+// deployment the client is connected to
 func (c *Client) GetConn() *redis.Client {
+	conn, _ := c.conn.(*redis.Client)
+	return conn
+}
+
+// GetUniversalConn returns the underlying redis library, whatever the kind of
+// deployment the client is connected to
+func (c *Client) GetUniversalConn() redis.UniversalClient {
 	return c.conn
 }
 
-// HIncrBy will increment a hash map key
-func (c *Client) HIncrBy(key, field string, inc int64) int64 {
-	resp := c.conn.HIncrBy(ctx, key, field, inc)
-	result, _ := resp.Result()
-	return result
+// convertErr returns ErrNil in place of go-redis's own nil reply error
+func convertErr(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return ErrNil
+	}
+	return err
 }
 
-func (c *Client) SIsMember(key, member string) bool {
-	resp := c.conn.SIsMember(ctx, key, member)
-	result, _ := resp.Result()
-	return result
+// HIncrBy will increment a hash map key and return its new value
+func (c *Client) HIncrBy(ctx context.Context, key, field string, inc int64) (int64, error) {
+	return c.conn.HIncrBy(ctx, key, field, inc).Result()
 }
 
-func (c *Client) SAdd(key, member string) int64 {
-	resp := c.conn.SAdd(ctx, key, member)
-	result, _ := resp.Result()
-	return result
+// HIncrByFloat will increment a hash map key and return its new value
+func (c *Client) HIncrByFloat(ctx context.Context, key, field string, inc float64) (float64, error) {
+	return c.conn.HIncrByFloat(ctx, key, field, inc).Result()
 }
 
-func (c *Client) SRandMember(key string) string {
-	resp := c.conn.SRandMember(ctx, key)
-	result, _ := resp.Result()
-	return result
+// SIsMember will check if member is in the set
+func (c *Client) SIsMember(ctx context.Context, key, member string) (bool, error) {
+	return c.conn.SIsMember(ctx, key, member).Result()
 }
 
-func (c *Client) SCard(key string) int64 {
-	resp := c.conn.SCard(ctx, key)
-	result, _ := resp.Result()
-	return result
+// SAdd will add the member to the set and return the number of members added
+func (c *Client) SAdd(ctx context.Context, key, member string) (int64, error) {
+	return c.conn.SAdd(ctx, key, member).Result()
 }
 
-func (c *Client) SRem(key, member string) int64 {
-	resp := c.conn.SRem(ctx, key, member)
-	result, _ := resp.Result()
-	return result
+// SRandMember will return a random member of the set, or ErrNil if the set
+// is empty
+func (c *Client) SRandMember(ctx context.Context, key string) (string, error) {
+	result, err := c.conn.SRandMember(ctx, key).Result()
+	return result, convertErr(err)
 }
 
-// HGetAll will return the hash map
-func (c *Client) HGetAll(key string) map[string]string {
-	resp := c.conn.HGetAll(ctx, key)
-	result, err := resp.Result()
-	if err != nil {
-		return dummyHashMap
-	}
-	return result
+// SCard will get the size of set
+func (c *Client) SCard(ctx context.Context, key string) (int64, error) {
+	return c.conn.SCard(ctx, key).Result()
 }
 
-// HGet will return the hash map key
-func (c *Client) HGet(key, field string) (string, bool) {
-	resp := c.conn.HGet(ctx, key, field)
-	result, err := resp.Result()
-	if err != nil {
-		return "", false
-	}
-	return result, true
+// SRem will remove the member from the set and return the number of members
+// removed
+func (c *Client) SRem(ctx context.Context, key, member string) (int64, error) {
+	return c.conn.SRem(ctx, key, member).Result()
+}
+
+// HGetAll will return the hash map, empty if it does not exist
+func (c *Client) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return c.conn.HGetAll(ctx, key).Result()
+}
+
+// HGet will return the hash map key, or ErrNil if it does not exist
+func (c *Client) HGet(ctx context.Context, key, field string) (string, error) {
+	result, err := c.conn.HGet(ctx, key, field).Result()
+	return result, convertErr(err)
 }
 
 // Del method will remove single key from redis
-func (c *Client) Del(key string) {
-	c.conn.Del(ctx, key)
+func (c *Client) Del(ctx context.Context, key string) error {
+	return c.conn.Del(ctx, key).Err()
 }
 
-// DelMulti method will remove multiple keys from redis
-func (c *Client) DelMulti(keys []string) {
-	c.conn.Del(ctx, keys...)
+// DelMulti method will remove multiple keys from redis. On a Redis Cluster,
+// the keys are deleted with one DEL per slot, in a single pipeline, and some
+// of them may be deleted even if an error is returned
+func (c *Client) DelMulti(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if _, ok := c.conn.(*redis.ClusterClient); ok {
+		return delBySlot(ctx, c.Batch(), keys)
+	}
+	return c.conn.Del(ctx, keys...).Err()
 }
 
-// HIncrBy will increment a hash map key
-func (c *Clientv2) HIncrBy(key, field string, inc int64) {
-	if c.pool != nil {
-		val := strconv.Itoa(int(inc))
-		c.pool.Do(radix.Cmd(nil, "HINCRBY", key, field, val))
+// do runs the action on the client. radix has no support for contexts: if ctx
+// is done first, do returns ctx.Err() and the action completes in the
+// background, its result discarded
+func (c *Clientv2) do(ctx context.Context, a radix.Action) error {
+	if c.client == nil {
+		return c.err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		return c.client.Do(a)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.client.Do(a)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
-// HIncrByFloat will increment a hash map key
-func (c *Clientv2) HIncrByFloat(key, field string, inc float64) {
-	if c.pool != nil {
-		val := fmt.Sprintf("%f", inc)
-		c.pool.Do(radix.Cmd(nil, "HINCRBYFLOAT", key, field, val))
+// doMaybeNil runs the command cmd, whose reply may be nil, into rcv. It
+// returns ErrNil on a nil reply
+func (c *Clientv2) doMaybeNil(ctx context.Context, rcv interface{}, cmd string, args ...string) error {
+	mn := radix.MaybeNil{Rcv: rcv}
+	if err := c.do(ctx, radix.Cmd(&mn, cmd, args...)); err != nil {
+		return err
+	}
+	if mn.Nil {
+		return ErrNil
 	}
+	return nil
 }
 
-// HGet will get the value of hashmap field
-func (c *Clientv2) HGet(key, field string) string {
+// HIncrBy will increment a hash map key and return its new value
+func (c *Clientv2) HIncrBy(ctx context.Context, key, field string, inc int64) (int64, error) {
+	var result int64
+	err := c.do(ctx, radix.Cmd(&result, "HINCRBY", key, field, strconv.FormatInt(inc, 10)))
+	return result, err
+}
+
+// HIncrByFloat will increment a hash map key and return its new value
+func (c *Clientv2) HIncrByFloat(ctx context.Context, key, field string, inc float64) (float64, error) {
+	var result float64
+	err := c.do(ctx, radix.Cmd(&result, "HINCRBYFLOAT", key, field, strconv.FormatFloat(inc, 'f', -1, 64)))
+	return result, err
+}
+
+// HGet will get the value of hashmap field, or ErrNil if it does not exist
+func (c *Clientv2) HGet(ctx context.Context, key, field string) (string, error) {
 	var result string
-	if c.pool != nil {
-		c.pool.Do(radix.Cmd(&result, "HGET", key, field))
-	}
-	return result
+	err := c.doMaybeNil(ctx, &result, "HGET", key, field)
+	return result, err
+}
+
+// HGetAll will return the hash map, empty if it does not exist
+func (c *Clientv2) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	result := make(map[string]string)
+	err := c.do(ctx, radix.Cmd(&result, "HGETALL", key))
+	return result, err
 }
 
 // SCard will get the size of set
-func (c *Clientv2) SCard(key string) int {
-	var count int
-	if c.pool != nil {
-		c.pool.Do(radix.Cmd(&count, "SCARD", key))
-	}
-	return count
+func (c *Clientv2) SCard(ctx context.Context, key string) (int64, error) {
+	var count int64
+	err := c.do(ctx, radix.Cmd(&count, "SCARD", key))
+	return count, err
 }
 
-func (c *Clientv2) SRem(key, member string) int {
-	var count int
-	if c.pool != nil {
-		c.pool.Do(radix.Cmd(&count, "SREM", key, member))
-	}
-	return count
+// SRem will remove the member from the set and return the number of members
+// removed
+func (c *Clientv2) SRem(ctx context.Context, key, member string) (int64, error) {
+	var count int64
+	err := c.do(ctx, radix.Cmd(&count, "SREM", key, member))
+	return count, err
 }
 
 // SIsMember will will check if value is in the set
-func (c *Clientv2) SIsMember(key, val string) int {
-	var isMember int
-	if c.pool != nil {
-		c.pool.Do(radix.Cmd(&isMember, "SISMEMBER", key, val))
-	}
-	return isMember
+func (c *Clientv2) SIsMember(ctx context.Context, key, val string) (bool, error) {
+	var isMember bool
+	err := c.do(ctx, radix.Cmd(&isMember, "SISMEMBER", key, val))
+	return isMember, err
 }
 
-// SAdd will add the member to the set
-func (c *Clientv2) SAdd(key, field string) int {
-	var success int
-	if c.pool != nil {
-		c.pool.Do(radix.Cmd(&success, "SADD", key, field))
-	}
-	return success
+// SAdd will add the member to the set and return the number of members added
+func (c *Clientv2) SAdd(ctx context.Context, key, field string) (int64, error) {
+	var added int64
+	err := c.do(ctx, radix.Cmd(&added, "SADD", key, field))
+	return added, err
 }
 
-func (c *Clientv2) SRandMember(key string) string {
+// SRandMember will return a random member of the set, or ErrNil if the set
+// is empty
+func (c *Clientv2) SRandMember(ctx context.Context, key string) (string, error) {
 	var result string
-	if c.pool != nil {
-		c.pool.Do(radix.Cmd(&result, "SRANDMEMBER", key))
+	err := c.doMaybeNil(ctx, &result, "SRANDMEMBER", key)
+	return result, err
+}
+
+// Del method will remove single key from redis
+func (c *Clientv2) Del(ctx context.Context, key string) error {
+	return c.do(ctx, radix.Cmd(nil, "DEL", key))
+}
+
+// DelMulti method will remove multiple keys from redis. On a Redis Cluster,
+// the keys are deleted with one DEL per slot, in parallel, and some of them
+// may be deleted even if an error is returned
+func (c *Clientv2) DelMulti(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if _, ok := c.client.(*radix.Cluster); ok {
+		return delBySlot(ctx, c.Batch(), keys)
+	}
+	return c.do(ctx, radix.Cmd(nil, "DEL", keys...))
+}
+
+// delBySlot deletes the keys with one DEL per Redis Cluster slot, since
+// redis rejects a DEL of keys in different slots with a CROSSSLOT error
+func delBySlot(ctx context.Context, b *Batch, keys []string) error {
+	for _, group := range keysBySlot(keys) {
+		b.Del(group...)
 	}
-	return result
+	_, err := b.Exec(ctx)
+	return err
 }
 
 // Close method closes the redis connection
@@ -226,7 +422,7 @@ func (c *Client) Close() {
 
 // Close method closes the redis connection
 func (c *Clientv2) Close() {
-	if c.pool != nil {
-		c.pool.Close()
+	if c.client != nil {
+		c.client.Close()
 	}
 }
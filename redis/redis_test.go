@@ -0,0 +1,467 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// fakeRedis is a minimal RESP server keeping hashes and sets in memory,
+// with MULTI/EXEC. A command that is not known while queued in a
+// transaction makes EXEC fail with EXECABORT, like in redis.
+//
+// In cluster mode, the server serves every slot and rejects a DEL of keys in
+// different slots with a CROSSSLOT error.
+type fakeRedis struct {
+	host, port string
+	cluster    bool
+
+	mu     sync.Mutex
+	hashes map[string]map[string]string
+	sets   map[string]map[string]bool
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	f := &fakeRedis{hashes: make(map[string]map[string]string), sets: make(map[string]map[string]bool)}
+	f.host, f.port, _ = net.SplitHostPort(l.Addr().String())
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) options() *ClientOptions {
+	return &ClientOptions{Host: f.host, Port: f.port, PoolSize: 2, MaxRetries: -1}
+}
+
+// newFakeCluster returns a fakeRedis in cluster mode, and the options to
+// connect to it as a Redis Cluster.
+func newFakeCluster(t *testing.T) (*fakeRedis, *ClientOptions) {
+	f := newFakeRedis(t)
+	f.cluster = true
+	return f, &ClientOptions{ClusterAddrs: []string{net.JoinHostPort(f.host, f.port)}, PoolSize: 2, MaxRetries: -1}
+}
+
+func (f *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	var queue [][]string
+	var inMulti, abort bool
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		var reply string
+		switch name := strings.ToUpper(args[0]); {
+		case name == "MULTI":
+			inMulti, abort, queue = true, false, nil
+			reply = "+OK\r\n"
+		case name == "EXEC" && abort:
+			inMulti = false
+			reply = "-EXECABORT Transaction discarded because of previous errors.\r\n"
+		case name == "EXEC":
+			inMulti = false
+			reply = fmt.Sprintf("*%d\r\n", len(queue))
+			for _, cmd := range queue {
+				reply += f.reply(cmd)
+			}
+		case inMulti && !f.known(name):
+			abort = true
+			reply = unknownCommand(args[0])
+		case inMulti:
+			queue = append(queue, args)
+			reply = "+QUEUED\r\n"
+		default:
+			reply = f.reply(args)
+		}
+		if _, err := c.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil { // $len
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func (f *fakeRedis) known(name string) bool {
+	switch name {
+	case "PING", "SELECT", "CLUSTER", "DEL", "PEXPIRE", "HINCRBY", "HINCRBYFLOAT", "HSET", "HGET", "HGETALL", "HDEL",
+		"SADD", "SREM", "SISMEMBER", "SCARD", "SRANDMEMBER":
+		return true
+	}
+	return false
+}
+
+func unknownCommand(name string) string {
+	return "-ERR unknown command '" + name + "'\r\n"
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func integer(n int) string {
+	return fmt.Sprintf(":%d\r\n", n)
+}
+
+func (f *fakeRedis) reply(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := strings.ToUpper(args[0])
+	if !f.known(name) {
+		return unknownCommand(args[0])
+	}
+	switch name {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT":
+		return "+OK\r\n"
+	case "CLUSTER":
+		if !f.cluster {
+			return "-ERR This instance has cluster support disabled\r\n"
+		}
+		port, _ := strconv.Atoi(f.port)
+		return "*1\r\n*3\r\n" + integer(0) + integer(16383) + "*2\r\n" + bulk(f.host) + integer(port)
+	case "DEL":
+		if f.cluster {
+			for _, k := range args[2:] {
+				if radix.ClusterSlot([]byte(k)) != radix.ClusterSlot([]byte(args[1])) {
+					return "-CROSSSLOT Keys in request don't hash to the same slot\r\n"
+				}
+			}
+		}
+		n := 0
+		for _, k := range args[1:] {
+			if f.hashes[k] != nil || f.sets[k] != nil {
+				n++
+			}
+			delete(f.hashes, k)
+			delete(f.sets, k)
+		}
+		return integer(n)
+	case "PEXPIRE":
+		if f.hashes[args[1]] == nil && f.sets[args[1]] == nil {
+			return integer(0)
+		}
+		return integer(1)
+	case "HINCRBY", "HINCRBYFLOAT":
+		h := f.hash(args[1])
+		if name == "HINCRBY" {
+			var n int64
+			if v, found := h[args[2]]; found {
+				var err error
+				if n, err = strconv.ParseInt(v, 10, 64); err != nil {
+					return "-ERR hash value is not an integer\r\n"
+				}
+			}
+			inc, _ := strconv.ParseInt(args[3], 10, 64)
+			h[args[2]] = strconv.FormatInt(n+inc, 10)
+			return ":" + h[args[2]] + "\r\n"
+		}
+		v, _ := strconv.ParseFloat(h[args[2]], 64)
+		inc, _ := strconv.ParseFloat(args[3], 64)
+		h[args[2]] = strconv.FormatFloat(v+inc, 'f', -1, 64)
+		return bulk(h[args[2]])
+	case "HSET":
+		h := f.hash(args[1])
+		_, found := h[args[2]]
+		h[args[2]] = args[3]
+		if found {
+			return integer(0)
+		}
+		return integer(1)
+	case "HGET":
+		v, found := f.hashes[args[1]][args[2]]
+		if !found {
+			return "$-1\r\n"
+		}
+		return bulk(v)
+	case "HGETALL":
+		h := f.hashes[args[1]]
+		reply := fmt.Sprintf("*%d\r\n", 2*len(h))
+		for k, v := range h {
+			reply += bulk(k) + bulk(v)
+		}
+		return reply
+	case "HDEL":
+		n := 0
+		for _, field := range args[2:] {
+			if _, found := f.hashes[args[1]][field]; found {
+				delete(f.hashes[args[1]], field)
+				n++
+			}
+		}
+		return integer(n)
+	case "SADD":
+		s := f.set(args[1])
+		n := 0
+		for _, m := range args[2:] {
+			if !s[m] {
+				s[m] = true
+				n++
+			}
+		}
+		return integer(n)
+	case "SREM":
+		n := 0
+		for _, m := range args[2:] {
+			if f.sets[args[1]][m] {
+				delete(f.sets[args[1]], m)
+				n++
+			}
+		}
+		return integer(n)
+	case "SISMEMBER":
+		if f.sets[args[1]][args[2]] {
+			return integer(1)
+		}
+		return integer(0)
+	case "SCARD":
+		return integer(len(f.sets[args[1]]))
+	case "SRANDMEMBER":
+		members := make([]string, 0, len(f.sets[args[1]]))
+		for m := range f.sets[args[1]] {
+			members = append(members, m)
+		}
+		if len(members) == 0 {
+			return "$-1\r\n"
+		}
+		sort.Strings(members)
+		return bulk(members[0])
+	}
+	return unknownCommand(args[0])
+}
+
+func (f *fakeRedis) hash(key string) map[string]string {
+	if f.hashes[key] == nil {
+		f.hashes[key] = make(map[string]string)
+	}
+	return f.hashes[key]
+}
+
+func (f *fakeRedis) set(key string) map[string]bool {
+	if f.sets[key] == nil {
+		f.sets[key] = make(map[string]bool)
+	}
+	return f.sets[key]
+}
+
+// commands is the API shared by Client and Clientv2.
+type commands interface {
+	HIncrBy(ctx context.Context, key, field string, inc int64) (int64, error)
+	HIncrByFloat(ctx context.Context, key, field string, inc float64) (float64, error)
+	HGet(ctx context.Context, key, field string) (string, error)
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	SAdd(ctx context.Context, key, member string) (int64, error)
+	SRem(ctx context.Context, key, member string) (int64, error)
+	SIsMember(ctx context.Context, key, member string) (bool, error)
+	SCard(ctx context.Context, key string) (int64, error)
+	SRandMember(ctx context.Context, key string) (string, error)
+	Del(ctx context.Context, key string) error
+	DelMulti(ctx context.Context, keys []string) error
+	Batch() *Batch
+	Close()
+}
+
+// clients returns a Client and a Clientv2 on opts.
+func clients(t *testing.T, opts *ClientOptions) map[string]commands {
+	t.Helper()
+	c, c2 := NewClient(opts), NewV2Client(opts)
+	t.Cleanup(c.Close)
+	t.Cleanup(c2.Close)
+	return map[string]commands{"Client": c, "Clientv2": c2}
+}
+
+func TestClient_Hashes(t *testing.T) {
+	ctx := context.Background()
+	for name, c := range clients(t, newFakeRedis(t).options()) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := c.HGet(ctx, "h", "a"); !errors.Is(err, ErrNil) {
+				t.Errorf("expected ErrNil for a missing field, got %v", err)
+			}
+			if n, err := c.HIncrBy(ctx, "h", "a", 2); err != nil || n != 2 {
+				t.Errorf("expected 2, got %d, %v", n, err)
+			}
+			if v, err := c.HIncrByFloat(ctx, "h", "f", 0.5); err != nil || v != 0.5 {
+				t.Errorf("expected 0.5, got %v, %v", v, err)
+			}
+			if v, err := c.HGet(ctx, "h", "a"); err != nil || v != "2" {
+				t.Errorf("expected 2, got %q, %v", v, err)
+			}
+			if h, err := c.HGetAll(ctx, "h"); err != nil || len(h) != 2 || h["f"] != "0.5" {
+				t.Errorf("expected both fields, got %v, %v", h, err)
+			}
+			if h, err := c.HGetAll(ctx, "missing"); err != nil || len(h) != 0 {
+				t.Errorf("expected an empty hash, got %v, %v", h, err)
+			}
+			if err := c.Del(ctx, "h"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.HGet(ctx, "h", "a"); !errors.Is(err, ErrNil) {
+				t.Errorf("expected ErrNil after Del, got %v", err)
+			}
+		})
+	}
+}
+
+func TestClient_Sets(t *testing.T) {
+	ctx := context.Background()
+	for name, c := range clients(t, newFakeRedis(t).options()) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := c.SRandMember(ctx, "s"); !errors.Is(err, ErrNil) {
+				t.Errorf("expected ErrNil for an empty set, got %v", err)
+			}
+			if n, err := c.SAdd(ctx, "s", "a"); err != nil || n != 1 {
+				t.Errorf("expected 1 member added, got %d, %v", n, err)
+			}
+			if n, _ := c.SAdd(ctx, "s", "a"); n != 0 {
+				t.Errorf("expected an existing member not to be added, got %d", n)
+			}
+			if ok, err := c.SIsMember(ctx, "s", "a"); err != nil || !ok {
+				t.Errorf("expected a member, got %v, %v", ok, err)
+			}
+			if m, err := c.SRandMember(ctx, "s"); err != nil || m != "a" {
+				t.Errorf("expected a, got %q, %v", m, err)
+			}
+			if n, err := c.SRem(ctx, "s", "a"); err != nil || n != 1 {
+				t.Errorf("expected 1 member removed, got %d, %v", n, err)
+			}
+			if n, err := c.SCard(ctx, "s"); err != nil || n != 0 {
+				t.Errorf("expected an empty set, got %d, %v", n, err)
+			}
+		})
+	}
+}
+
+func TestClient_DelMulti(t *testing.T) {
+	ctx := context.Background()
+	for name, c := range clients(t, newFakeRedis(t).options()) {
+		t.Run(name, func(t *testing.T) {
+			c.SAdd(ctx, "a", "1")
+			c.SAdd(ctx, "b", "1")
+			c.SAdd(ctx, "c", "1")
+			if err := c.DelMulti(ctx, nil); err != nil {
+				t.Errorf("expected no keys to be a no-op, got %v", err)
+			}
+			if err := c.DelMulti(ctx, []string{"a", "b", "missing"}); err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range map[string]int64{"a": 0, "b": 0, "c": 1} {
+				if n, _ := c.SCard(ctx, key); n != want {
+					t.Errorf("expected %d members in %s, got %d", want, key, n)
+				}
+			}
+		})
+	}
+}
+
+// TestClient_DelMultiCluster verifies that DelMulti deletes keys in
+// different slots of a Redis Cluster.
+func TestClient_DelMultiCluster(t *testing.T) {
+	ctx := context.Background()
+	_, opts := newFakeCluster(t)
+	for name, c := range clients(t, opts) {
+		t.Run(name, func(t *testing.T) {
+			keys := []string{name + ":a", name + ":b", name + ":c", "{" + name + "}:d", "{" + name + "}:e"}
+			for _, key := range keys {
+				c.SAdd(ctx, key, "1")
+			}
+			if err := c.DelMulti(ctx, keys); err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range keys {
+				if n, _ := c.SCard(ctx, key); n != 0 {
+					t.Errorf("expected %s to be deleted", key)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_GetConn(t *testing.T) {
+	f, clusterOpts := newFakeCluster(t)
+	c := NewClient(f.options())
+	defer c.Close()
+	if c.GetConn() == nil {
+		t.Error("expected the conn of a single node")
+	}
+	cluster := NewClient(clusterOpts)
+	defer cluster.Close()
+	if cluster.GetConn() != nil || cluster.GetUniversalConn() == nil {
+		t.Error("expected only the universal conn on a Redis Cluster")
+	}
+}
+
+// TestClient_Errors verifies that replies and failures of redis are
+// returned as errors, and that a done context is.
+func TestClient_Errors(t *testing.T) {
+	f := newFakeRedis(t)
+	ctx := context.Background()
+	for name, c := range clients(t, f.options()) {
+		t.Run(name, func(t *testing.T) {
+			c.Batch().HSet("h", "text", "abc").Exec(ctx)
+			if _, err := c.HIncrBy(ctx, "h", "text", 1); err == nil || !strings.Contains(err.Error(), "not an integer") {
+				t.Errorf("expected the error of redis, got %v", err)
+			}
+
+			canceled, cancel := context.WithCancel(ctx)
+			cancel()
+			if _, err := c.HGet(canceled, "h", "text"); !errors.Is(err, context.Canceled) {
+				t.Errorf("expected context.Canceled, got %v", err)
+			}
+		})
+	}
+
+	opts := f.options()
+	opts.Port = "1"
+	opts.WriteTimeout = 100 * time.Millisecond
+	for name, c := range clients(t, opts) {
+		if _, err := c.HGet(ctx, "h", "a"); err == nil || errors.Is(err, ErrNil) {
+			t.Errorf("%s: expected a connection error, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/redis/go-redis/v9"
+)
+
+// Scripter is implemented by Client and Clientv2, so that the helpers of
+// this package built on Lua scripts, like Locker, work with either of them.
+// It cannot be implemented outside of this package: in tests, use
+// NewLocalLocker and NewLocalRateLimiter instead of a fake Scripter
+type Scripter interface {
+	evalInts(ctx context.Context, s *script, keys []string, args ...string) ([]int64, error)
+}
+
+// script is a Lua script returning an integer or an array of integers, sent
+// with EVALSHA and loaded with EVAL when redis does not know it yet. On a
+// Redis Cluster, its keys must be in the same slot
+type script struct {
+	goredis *redis.Script
+	radix   radix.EvalScript
+}
+
+func newScript(numKeys int, src string) *script {
+	return &script{goredis: redis.NewScript(src), radix: radix.NewEvalScript(numKeys, src)}
+}
+
+func (c *Client) evalInts(ctx context.Context, s *script, keys []string, args ...string) ([]int64, error) {
+	argv := make([]interface{}, len(args))
+	for i, arg := range args {
+		argv[i] = arg
+	}
+	val, err := s.goredis.Run(ctx, c.conn, keys, argv...).Result()
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	return replyInts(val)
+}
+
+func (c *Clientv2) evalInts(ctx context.Context, s *script, keys []string, args ...string) ([]int64, error) {
+	var val interface{}
+	mn := radix.MaybeNil{Rcv: &val}
+	if err := c.do(ctx, s.radix.Cmd(&mn, slices.Concat(keys, args)...)); err != nil {
+		return nil, err
+	}
+	if mn.Nil {
+		return nil, ErrNil
+	}
+	return replyInts(val)
+}
+
+// replyInts returns the integers of the reply of a script
+func replyInts(val interface{}) ([]int64, error) {
+	switch v := val.(type) {
+	case int64:
+		return []int64{v}, nil
+	case []interface{}:
+		ints := make([]int64, len(v))
+		for i, e := range v {
+			n, ok := e.(int64)
+			if !ok {
+				return nil, fmt.Errorf("redis: script returned %T, not an integer", e)
+			}
+			ints[i] = n
+		}
+		return ints, nil
+	}
+	return nil, fmt.Errorf("redis: script returned %T, not an integer", val)
+}
@@ -0,0 +1,41 @@
+package redis
+
+import "testing"
+
+func TestReplyInts(t *testing.T) {
+	tests := []struct {
+		name    string
+		val     interface{}
+		want    []int64
+		wantErr bool
+	}{
+		{name: "integer", val: int64(7), want: []int64{7}},
+		{name: "array", val: []interface{}{int64(1), int64(0), int64(-3)}, want: []int64{1, 0, -3}},
+		{name: "empty array", val: []interface{}{}, want: []int64{}},
+		{name: "string", val: "OK", wantErr: true},
+		{name: "nil", val: nil, wantErr: true},
+		{name: "array of strings", val: []interface{}{int64(1), "2"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := replyInts(tt.val)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("expected %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+}